@@ -0,0 +1,408 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	flag "github.com/spf13/pflag"
+
+	"github.com/gardener/apiserver-proxy/internal/app"
+	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/proxy"
+)
+
+func TestApiserverProxySidecar(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apiserver Proxy Sidecar Suite")
+}
+
+var _ = Describe("Config file", func() {
+
+	var (
+		dir    string
+		path   string
+		fs     *flag.FlagSet
+		params *app.ConfigParams
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "config.yaml")
+
+		params = &app.ConfigParams{}
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		addFlags(fs, params)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	writeConfig := func(content string) {
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+	}
+
+	It("should default the configuration and map it onto the parameters", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+- fd00::1
+netns: /var/run/netns/proxy
+port: 443
+rules:
+  enabled: true
+  backend: nftables
+server:
+  healthBindAddress: :8080
+duplicates:
+  exclude:
+  - kube-ipvs0
+`)
+		Expect(fs.Parse(nil)).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params).To(Equal(&app.ConfigParams{
+			IPAddresses:              []string{"10.0.0.1", "fd00::1"},
+			NetNS:                    "/var/run/netns/proxy",
+			LocalPort:                "443",
+			Interface:                "lo",
+			Interval:                 time.Minute,
+			Daemon:                   true,
+			StateFile:                "/run/apiserver-proxy/state.json",
+			SetupIptables:            true,
+			RulesBackend:             "nftables",
+			ProbeMode:                "none",
+			ProbeTimeout:             5 * time.Second,
+			HealthBindAddress:        ":8080",
+			DuplicatePolicy:          "remove",
+			DuplicateExclude:         []string{"kube-ipvs0"},
+			ConflictThreshold:        3,
+			ConflictWindow:           10 * time.Minute,
+			ProxyLoadBalancing:       "round-robin",
+			ProxyDialTimeout:         5 * time.Second,
+			ProxyHealthCheckInterval: 10 * time.Second,
+			ProxyUnhealthyThreshold:  3,
+			ProxyDrainTimeout:        30 * time.Second,
+		}))
+	})
+
+	It("should derive the interface mode from the interface overridden by the flag", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+interface: foo0
+`)
+		Expect(fs.Parse([]string{"--interface=lo"})).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.Interface).To(Equal("lo"))
+		Expect(params.InterfaceMode).To(BeEmpty())
+		Expect(validateParams(params)).To(Succeed())
+
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+`)
+		params = &app.ConfigParams{}
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		addFlags(fs, params)
+		Expect(fs.Parse([]string{"--interface=foo"})).To(Succeed())
+
+		cfg, err = loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.Interface).To(Equal("foo"))
+		Expect(params.InterfaceMode).To(BeEmpty())
+		Expect(validateParams(params)).To(Succeed())
+	})
+
+	It("should map the proxy configuration onto the parameters", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+interface: apiserver-proxy
+interfaceMode: existing
+proxy:
+  upstreams:
+  - address: 10.0.0.10:443
+  - address: 10.0.0.11:443
+    serverNames:
+    - api.example.com
+    - api.internal.example.com
+  - address: 10.0.0.12:443
+    proxyProtocol:
+      version: v2
+      authority: true
+      tlvs:
+      - type: 224
+        value: node-1
+    healthCheck:
+      mode: https
+      tokenFile: /var/run/secrets/token
+      caFile: /var/run/secrets/ca.crt
+  loadBalancing: least-connections
+  unhealthyThreshold: 5
+  drainTimeout: 1m
+`)
+		Expect(fs.Parse([]string{"--proxy-dial-timeout=1s"})).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.InterfaceMode).To(Equal("existing"))
+		Expect(params.ProxyUpstreams).To(Equal([]string{
+			"10.0.0.10:443",
+			"api.example.com=10.0.0.11:443",
+			"api.internal.example.com=10.0.0.11:443",
+			"10.0.0.12:443?authority=true&ca-file=%2Fvar%2Frun%2Fsecrets%2Fca.crt&health-check=https&proxy-protocol=v2&tlv=224%3Anode-1&token-file=%2Fvar%2Frun%2Fsecrets%2Ftoken",
+		}))
+		Expect(proxy.ParseUpstream(params.ProxyUpstreams[3])).To(Equal(proxy.Upstream{
+			Address: "10.0.0.12:443",
+			ProxyProtocol: proxy.ProxyProtocol{
+				Version:   proxy.ProxyProtocolV2,
+				Authority: true,
+				TLVs:      []proxy.TLV{{Type: 224, Value: []byte("node-1")}},
+			},
+			HealthCheck: proxy.HealthCheck{Mode: proxy.HealthCheckHTTPS, TokenFile: "/var/run/secrets/token", CAFile: "/var/run/secrets/ca.crt"},
+		}))
+		Expect(params.ProxyLoadBalancing).To(Equal(proxy.LoadBalancingLeastConnections))
+		Expect(params.ProxyUnhealthyThreshold).To(Equal(5))
+		Expect(params.ProxyDialTimeout).To(Equal(time.Second))
+		Expect(params.ProxyHealthCheckInterval).To(Equal(10 * time.Second))
+		Expect(params.ProxyDrainTimeout).To(Equal(time.Minute))
+	})
+
+	It("should map the shutdown configuration onto the parameters", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+cleanup: true
+shutdown:
+  gracePeriod: 20s
+  timeout: 25s
+`)
+		Expect(fs.Parse(nil)).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.ShutdownGracePeriod).To(Equal(20 * time.Second))
+		Expect(params.ShutdownTimeout).To(Equal(25 * time.Second))
+		Expect(validateParams(params)).To(Succeed())
+	})
+
+	It("should map the conntrack configuration onto the parameters", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+conntrack:
+  flush: true
+`)
+		Expect(fs.Parse(nil)).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.ConntrackFlush).To(BeTrue())
+	})
+
+	It("should not allow a grace period exceeding the shutdown timeout", func() {
+		Expect(fs.Parse([]string{"--ip-address=10.0.0.1", "--shutdown-grace-period=30s", "--shutdown-timeout=25s"})).To(Succeed())
+		Expect(validateParams(params)).To(MatchError(ContainSubstring("must not exceed --shutdown-timeout")))
+
+		Expect(fs.Set("shutdown-timeout", "0s")).To(Succeed())
+		Expect(validateParams(params)).To(Succeed())
+	})
+
+	It("should let explicitly set flags take precedence", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+interface: eth0
+daemon: false
+stateFile: ""
+`)
+		Expect(fs.Parse([]string{"--ip-address=10.0.0.2", "--daemon=true"})).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.IPAddresses).To(Equal([]string{"10.0.0.2"}))
+		Expect(params.Daemon).To(BeTrue())
+		Expect(params.Interface).To(Equal("eth0"))
+		Expect(params.StateFile).To(BeEmpty())
+	})
+
+	It("should reload the configuration without losing explicitly set flags", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+`)
+		Expect(fs.Parse([]string{"--interface=eth0"})).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.2
+interface: eth1
+`)
+
+		reloaded, err := reloadParams(fs, path, params)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reloaded.IPAddresses).To(Equal([]string{"10.0.0.2"}))
+		Expect(reloaded.Interface).To(Equal("eth0"))
+		Expect(params.IPAddresses).To(Equal([]string{"10.0.0.1"}))
+	})
+
+	It("should map the IP address source onto the parameters", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddressSource:
+  nodeAnnotation:
+    key: example.com/address
+nodeName: node-1
+`)
+		Expect(fs.Parse(nil)).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.IPAddressSource).To(Equal("node-annotation:example.com/address"))
+		Expect(params.NodeName).To(Equal("node-1"))
+		Expect(validateParams(params)).To(Succeed())
+	})
+
+	It("should not allow an IP address source together with addresses", func() {
+		Expect(fs.Parse([]string{"--ip-address=10.0.0.1", "--ip-address-source=service:kube-system/apiserver-proxy"})).To(Succeed())
+
+		Expect(validateParams(params)).To(MatchError(ContainSubstring("mutually exclusive")))
+	})
+
+	It("should map the reporting configuration onto the parameters", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddresses:
+- 10.0.0.1
+reporting:
+  nodeCondition: true
+  events: true
+`)
+		Expect(fs.Parse([]string{"--record-events=false"})).To(Succeed())
+
+		cfg, err := loadConfigFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		applyConfig(fs, cfg, params)
+
+		Expect(params.NodeCondition).To(BeTrue())
+		Expect(params.RecordEvents).To(BeFalse())
+	})
+
+	It("should require the node name for reporting", func() {
+		Expect(fs.Parse([]string{"--ip-address=10.0.0.1", "--node-condition"})).To(Succeed())
+		Expect(validateParams(params)).To(MatchError(ContainSubstring("--node-name is required")))
+
+		Expect(fs.Set("node-name", "node-1")).To(Succeed())
+		Expect(validateParams(params)).To(Succeed())
+	})
+
+	It("should fail for unknown fields", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+ipAddress: 10.0.0.1
+`)
+
+		_, err := loadConfigFile(path)
+		Expect(err).To(MatchError(ContainSubstring("could not decode")))
+	})
+
+	It("should fail for an invalid configuration", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: ApiserverProxySidecarConfiguration
+rules:
+  backend: ebpf
+`)
+
+		_, err := loadConfigFile(path)
+		Expect(err).To(MatchError(ContainSubstring("rules.backend")))
+	})
+
+	It("should fail for an unknown kind", func() {
+		writeConfig(`apiVersion: apiserverproxy.config.gardener.cloud/v1alpha1
+kind: Foo
+`)
+
+		_, err := loadConfigFile(path)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("printPlan", func() {
+
+	actions := []netif.Action{
+		{Op: netif.ActionLinkAdd, Interface: "proxy0", Type: "dummy"},
+		{Op: netif.ActionAddrAdd, Interface: "proxy0", Address: "10.0.0.1/32"},
+	}
+
+	It("should print the plan as text", func() {
+		var out bytes.Buffer
+		Expect(printPlan(&out, outputText, actions)).To(Succeed())
+
+		Expect(out.String()).To(Equal("Dry run, planned changes:\n" +
+			"  1. add dummy interface proxy0\n" +
+			"  2. add address 10.0.0.1/32 to interface proxy0\n"))
+	})
+
+	It("should print the plan as json", func() {
+		var out bytes.Buffer
+		Expect(printPlan(&out, outputJSON, actions)).To(Succeed())
+
+		Expect(out.String()).To(MatchJSON(`{"actions": [
+  {"op": "LinkAdd", "interface": "proxy0", "type": "dummy"},
+  {"op": "AddrAdd", "interface": "proxy0", "address": "10.0.0.1/32"}
+]}`))
+	})
+
+	It("should print an empty plan", func() {
+		var out bytes.Buffer
+		Expect(printPlan(&out, outputJSON, nil)).To(Succeed())
+		Expect(out.String()).To(MatchJSON(`{"actions": []}`))
+
+		out.Reset()
+		Expect(printPlan(&out, outputText, nil)).To(Succeed())
+		Expect(out.String()).To(Equal("Dry run, no changes planned.\n"))
+	})
+})
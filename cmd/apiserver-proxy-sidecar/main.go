@@ -4,57 +4,285 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	goflag "flag"
 	"fmt"
+	"io"
 	"os"
-	"time"
 
-	flag "github.com/spf13/pflag"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 
 	"github.com/gardener/apiserver-proxy/internal/app"
+	"github.com/gardener/apiserver-proxy/internal/netif"
 	"github.com/gardener/apiserver-proxy/internal/version"
 )
 
-func parseAndValidateFlags() *app.ConfigParams {
-	params := &app.ConfigParams{}
+const (
+	// exitCodeUnhealthy is the exit code of the status command if the resources are not in the desired state.
+	exitCodeUnhealthy = 2
 
-	cmd := goflag.CommandLine
-	klog.InitFlags(cmd)
-	flag.CommandLine.AddGoFlagSet(cmd)
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// exitError makes the command exit with the given code without printing an error.
+type exitError struct {
+	code int
+}
 
-	flag.StringVar(&params.Interface, "interface", "lo", "[optional] name of the interface to add address to.")
-	flag.DurationVar(&params.Interval, "sync-interval", time.Minute, "[optional] interval to check for the added interface.")
-	flag.BoolVar(&params.Cleanup, "cleanup", false,
-		"[optional] indicates whether created interface should be removed on exit.")
-	flag.BoolVar(&params.Daemon, "daemon", true,
-		"[optional] indicates if the sidecar should run as a daemon")
-	flag.StringVar(&params.IPAddress, "ip-address", "", "ip-address on which the proxy is listening (e.g. 1.2.3.4).")
-	flag.StringVar(&params.LocalPort, "port", "9443", "[optional] port on which the proxy is listening.")
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s (%s):\n", os.Args[0], version.Version())
-		flag.PrintDefaults()
+func newCommand() *cobra.Command {
+	var (
+		params     = &app.ConfigParams{}
+		configFile string
+		planOutput string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apiserver-proxy-sidecar",
+		Short: "Adds the address of the apiserver-proxy to an interface of the node",
+		Long: "Adds the address of the apiserver-proxy to an interface of the node. Without a command, it runs " +
+			"as configured by the --daemon and --cleanup flags.",
+		Version:       version.Version(),
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
 
-	flag.Parse()
+	fs := cmd.PersistentFlags()
+	klog.InitFlags(goflag.CommandLine)
+	fs.AddGoFlagSet(goflag.CommandLine)
+	fs.StringVar(&configFile, "config", "",
+		"[optional] path of an ApiserverProxySidecarConfiguration file, explicitly set flags take precedence over its values.")
+	fs.StringVar(&planOutput, "plan-output", outputText, "[optional] output format of the plan printed with --dry-run (text or json).")
+	addFlags(fs, params)
 
-	if params.IPAddress == "" {
-		klog.Errorln("--ip-address is required")
-		os.Exit(1)
+	newApp := func() (*app.SidecarApp, error) {
+		if planOutput != outputText && planOutput != outputJSON {
+			return nil, xerrors.Errorf("unknown plan output format %q, must be one of %q or %q", planOutput, outputText, outputJSON)
+		}
+
+		if configFile != "" {
+			cfg, err := loadConfigFile(configFile)
+			if err != nil {
+				return nil, err
+			}
+
+			applyConfig(fs, cfg, params)
+		}
+
+		if err := validateParams(params); err != nil {
+			return nil, err
+		}
+
+		sidecar, err := app.NewSidecarApp(params)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to create sidecar application, err %v", err)
+		}
+
+		if configFile != "" {
+			sidecar.EnableReload(configFile, func() (*app.ConfigParams, error) {
+				return reloadParams(fs, configFile, params)
+			})
+		}
+
+		return sidecar, nil
+	}
+
+	// plan prints the changes recorded in dry-run mode after the given error, so that the plan is also
+	// printed if applying it would have failed.
+	plan := func(cmd *cobra.Command, sidecar *app.SidecarApp, err error) error {
+		if !params.DryRun {
+			return err
+		}
+
+		return errors.Join(err, printPlan(cmd.OutOrStdout(), planOutput, sidecar.Plan()))
 	}
 
-	return params
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		sidecar, err := newApp()
+		if err != nil {
+			return err
+		}
+		defer sidecar.Close()
+
+		sidecar.RunApp(signals.SetupSignalHandler())
+
+		return plan(cmd, sidecar, nil)
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "setup",
+			Short: "Ensures the address and rules once and exits, e.g. in an init container",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				sidecar, err := newApp()
+				if err != nil {
+					return err
+				}
+				defer sidecar.Close()
+
+				return plan(cmd, sidecar, sidecar.Setup(signals.SetupSignalHandler()))
+			},
+		},
+		&cobra.Command{
+			Use:   "run",
+			Short: "Runs as a daemon, removing the address and rules on exit if --cleanup is set",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				// Set the flag instead of the parameter, so that it takes precedence over the config file.
+				if err := cmd.Flags().Set("daemon", "true"); err != nil {
+					return err
+				}
+
+				sidecar, err := newApp()
+				if err != nil {
+					return err
+				}
+				defer sidecar.Close()
+
+				sidecar.RunApp(signals.SetupSignalHandler())
+
+				return plan(cmd, sidecar, nil)
+			},
+		},
+		&cobra.Command{
+			Use:   "teardown",
+			Short: "Removes the address, rules and the created interface, e.g. in a preStop hook",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				sidecar, err := newApp()
+				if err != nil {
+					return err
+				}
+				defer sidecar.Close()
+
+				return plan(cmd, sidecar, sidecar.Teardown())
+			},
+		},
+		newStatusCommand(newApp),
+		&cobra.Command{
+			Use:   "version",
+			Short: "Prints the version",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, _ []string) {
+				fmt.Fprintln(cmd.OutOrStdout(), version.Version())
+			},
+		},
+	)
+
+	return cmd
 }
 
-func main() {
-	params := parseAndValidateFlags()
+func newStatusCommand(newApp func() (*app.SidecarApp, error)) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Prints the observed state of the interface, addresses and rules",
+		Long: fmt.Sprintf("Prints the observed state of the interface, addresses and rules. "+
+			"Exits with %d if they are not in the desired state and with 1 if the state could not be observed.", exitCodeUnhealthy),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if output != outputText && output != outputJSON {
+				return xerrors.Errorf("unknown output format %q, must be one of %q or %q", output, outputText, outputJSON)
+			}
+
+			sidecar, err := newApp()
+			if err != nil {
+				return err
+			}
+			defer sidecar.Close()
 
-	app, err := app.NewSidecarApp(params)
-	if err != nil {
-		klog.Fatalf("Failed to create sidecar application, err %v", err)
+			st, err := sidecar.Status()
+			if err != nil {
+				return err
+			}
+
+			if output == outputJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(st); err != nil {
+					return err
+				}
+			} else {
+				printStatus(cmd.OutOrStdout(), st)
+			}
+
+			if !st.Healthy {
+				return &exitError{code: exitCodeUnhealthy}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "output format (text or json).")
+
+	return cmd
+}
+
+// printStatus prints the status in a human readable format.
+func printStatus(w io.Writer, st *app.Status) {
+	network := st.Network
+
+	fmt.Fprintf(w, "Interface: %s (exists: %t, up: %t, owned: %t)\n", network.Interface, network.Exists, network.Up, network.Owned)
+
+	for _, addr := range network.Addresses {
+		fmt.Fprintf(w, "Address:   %s (present: %t", addr.Address, addr.Present)
+		if len(addr.Duplicates) > 0 {
+			fmt.Fprintf(w, ", duplicates: %v", addr.Duplicates)
+		}
+		fmt.Fprintln(w, ")")
 	}
 
-	app.RunApp(signals.SetupSignalHandler())
+	if st.Rules != nil {
+		fmt.Fprintf(w, "Rules:     %s (present: %t)\n", st.Rules.Backend, st.Rules.Present)
+	}
+
+	fmt.Fprintf(w, "Healthy:   %t\n", st.Healthy)
+}
+
+// printPlan prints the changes recorded in dry-run mode in the given output format.
+func printPlan(w io.Writer, output string, actions []netif.Action) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(struct {
+			Actions []netif.Action `json:"actions"`
+		}{Actions: append([]netif.Action{}, actions...)})
+	}
+
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "Dry run, no changes planned.")
+		return nil
+	}
+
+	fmt.Fprintln(w, "Dry run, planned changes:")
+	for i, action := range actions {
+		fmt.Fprintf(w, "%3d. %s\n", i+1, action)
+	}
+
+	return nil
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+
+		klog.Errorln(err)
+		os.Exit(1)
+	}
 }
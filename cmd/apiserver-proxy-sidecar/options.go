@@ -0,0 +1,313 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	configv1alpha1 "github.com/gardener/apiserver-proxy/internal/apis/config/v1alpha1"
+	"github.com/gardener/apiserver-proxy/internal/apis/config/v1alpha1/validation"
+	"github.com/gardener/apiserver-proxy/internal/app"
+	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/probe"
+	"github.com/gardener/apiserver-proxy/internal/proxy"
+	"github.com/gardener/apiserver-proxy/internal/report"
+	"github.com/gardener/apiserver-proxy/internal/rules"
+	"github.com/gardener/apiserver-proxy/internal/source"
+	"github.com/gardener/apiserver-proxy/internal/state"
+)
+
+var (
+	configScheme  = runtime.NewScheme()
+	configDecoder runtime.Decoder
+)
+
+func init() {
+	utilruntime.Must(configv1alpha1.AddToScheme(configScheme))
+	configDecoder = serializer.NewCodecFactory(configScheme, serializer.EnableStrict).UniversalDecoder()
+}
+
+// addFlags adds the flags configuring the sidecar to the given flag set.
+func addFlags(fs *flag.FlagSet, params *app.ConfigParams) {
+	fs.StringVar(&params.Interface, "interface", "lo", "[optional] name of the interface to add address to.")
+	fs.StringVar(&params.InterfaceMode, "interface-mode", "",
+		"[optional] how the interface is provided (create-dummy, existing or loopback), derived from --interface if empty: "+
+			"loopback for lo, create-dummy otherwise.")
+	fs.DurationVar(&params.Interval, "sync-interval", time.Minute, "[optional] interval to check for the added interface.")
+	fs.BoolVar(&params.Cleanup, "cleanup", false,
+		"[optional] indicates whether created interface should be removed on exit.")
+	fs.BoolVar(&params.Daemon, "daemon", true,
+		"[optional] indicates if the sidecar should run as a daemon")
+	fs.StringVar(&params.HealthBindAddress, "health-bind-address", "",
+		"[optional] address on which the /healthz, /readyz and /livez endpoints are served in daemon mode (e.g. :8080).")
+	fs.StringVar(&params.StateFile, "state-file", state.DefaultPath,
+		"[optional] path of the file recording the managed resources to remove stale ones after a restart, disabled if empty.")
+	fs.StringVar(&params.HandoverFile, "handover-file", "",
+		"[optional] path of the lock file recording the instance owning the ip-addresses, so that an instance does not remove them "+
+			"on exit after a newer one took them over, e.g. during a rolling update, disabled if empty.")
+	fs.StringVar(&params.InstanceID, "instance-id", "",
+		"[optional] ID of the instance in the --handover-file (e.g. the UID of the pod), required for the setup and teardown commands "+
+			"to be the same instance as the daemon of their pod, random if empty.")
+	fs.StringVar(&params.MetricsBindAddress, "metrics-bind-address", "",
+		"[optional] address on which the /metrics endpoint is served in daemon mode (e.g. :8081).")
+	fs.StringSliceVar(&params.IPAddresses, "ip-address", nil,
+		"ip-addresses on which the proxy is listening (e.g. 1.2.3.4 or 1.2.3.4,fd00::1), at most one per IP family.")
+	fs.StringVar(&params.IPAddressSource, "ip-address-source", "",
+		"[optional] object in the cluster to derive the ip-addresses from instead of --ip-address, one of "+
+			"service:<namespace>/<name>, configmap:<namespace>/<name>/<key> or node-annotation:<key>.")
+	fs.StringVar(&params.NodeName, "node-name", "",
+		"[optional] name of the node the sidecar runs on, required for --ip-address-source=node-annotation:<key>, "+
+			"--node-condition and --record-events.")
+	fs.StringVar(&params.DuplicatePolicy, "duplicate-policy", netif.DuplicatePolicyRemove,
+		"[optional] how duplicates of the ip-addresses on other interfaces are handled (remove, warn or fail).")
+	fs.StringSliceVar(&params.DuplicateInclude, "duplicate-include", nil,
+		"[optional] patterns of the interfaces which are checked for duplicates of the ip-addresses (e.g. eth*), all if empty.")
+	fs.StringSliceVar(&params.DuplicateExclude, "duplicate-exclude", nil,
+		"[optional] patterns of the interfaces which are never checked for duplicates of the ip-addresses (e.g. kube-ipvs0).")
+	fs.IntVar(&params.ConflictThreshold, "conflict-threshold", 3,
+		"[optional] number of repairs within --conflict-window from which on a conflict with another agent changing the ip-addresses is detected, disabled if zero.")
+	fs.DurationVar(&params.ConflictWindow, "conflict-window", 10*time.Minute,
+		"[optional] time window in which the repairs are counted.")
+	fs.DurationVar(&params.ConflictBackoff, "conflict-backoff", 0,
+		"[optional] maximum delay between repairs while in conflict, which is doubled on every repair, disabled if zero.")
+	fs.BoolVar(&params.ConntrackFlush, "conntrack-flush", false,
+		"[optional] indicates whether the conntrack entries of the ip-addresses and port should be flushed after the ip-addresses were (re)added.")
+	fs.BoolVar(&params.NodeCondition, "node-condition", false,
+		"[optional] indicates whether the "+string(report.ConditionType)+" condition of the node should be maintained.")
+	fs.BoolVar(&params.RecordEvents, "record-events", false,
+		"[optional] indicates whether events should be recorded on the node when the state of the ip-addresses changes.")
+	fs.StringVar(&params.NetNS, "netns", "",
+		"[optional] path (e.g. /var/run/netns/<name>) or PID of a process of the network namespace to manage, the one of the sidecar if empty.")
+	fs.BoolVar(&params.DryRun, "dry-run", false,
+		"[optional] only print the planned changes of the interfaces and addresses instead of applying them, does not run as a daemon.")
+	fs.StringSliceVar(&params.ProxyUpstreams, "proxy-upstream", nil,
+		"[optional] kube-apiserver endpoints ([<server-name>=]<host>:<port>[?<options>]) the embedded proxy forwards the connections to "+
+			"in daemon mode, disabled if empty. The URL-encoded options configure the PROXY protocol (e.g. proxy-protocol=v2&authority=true&tlv=0xe0:<value>) "+
+			"and the health check (e.g. health-check=https&token-file=<path>&ca-file=<path>).")
+	fs.StringVar(&params.ProxyLoadBalancing, "proxy-load-balancing", proxy.LoadBalancingRoundRobin,
+		"[optional] how the embedded proxy distributes the connections over the healthy upstreams (round-robin, least-connections "+
+			"or failover in the order of --proxy-upstream).")
+	fs.DurationVar(&params.ProxyDialTimeout, "proxy-dial-timeout", 5*time.Second,
+		"[optional] timeout of the embedded proxy for connecting to an upstream, reading the TLS ClientHello of a client and the health checks.")
+	fs.DurationVar(&params.ProxyHealthCheckInterval, "proxy-health-check-interval", 10*time.Second,
+		"[optional] interval in which the embedded proxy checks the upstreams.")
+	fs.IntVar(&params.ProxyUnhealthyThreshold, "proxy-unhealthy-threshold", 3,
+		"[optional] number of consecutive failed health checks or connection attempts after which the embedded proxy ejects an upstream.")
+	fs.DurationVar(&params.ProxyDrainTimeout, "proxy-drain-timeout", 30*time.Second,
+		"[optional] how long the embedded proxy drains the active connections on exit before closing them.")
+	fs.DurationVar(&params.ShutdownGracePeriod, "shutdown-grace-period", 0,
+		"[optional] how long the established connections to the ip-addresses and port are waited for on exit with --cleanup "+
+			"after reporting not ready and before removing the ip-addresses, ending early once there are none left, disabled if zero.")
+	fs.DurationVar(&params.ShutdownTimeout, "shutdown-timeout", 0,
+		"[optional] deadline for the grace period and draining the connections of the embedded proxy on exit, "+
+			"which should fit into the terminationGracePeriodSeconds of the pod, unbounded if zero.")
+	fs.StringVar(&params.LocalPort, "port", "9443", "[optional] port on which the proxy is listening.")
+	fs.BoolVar(&params.SetupIptables, "setup-iptables", false,
+		"[optional] indicates whether rules for the ip-address and port should be set up.")
+	fs.StringVar(&params.RulesBackend, "rules-backend", rules.BackendIPTables,
+		"[optional] backend used to set up the rules (iptables or nftables). The nftables rules are kept in a separate table, so they do not override drop rules of other tables.")
+	fs.StringVar(&params.ProbeMode, "probe", probe.ModeNone,
+		"[optional] how to probe that the proxy is listening on ip-address and port (none, tcp or tls).")
+	fs.DurationVar(&params.ProbeTimeout, "probe-timeout", 5*time.Second, "[optional] timeout for probing the proxy.")
+}
+
+// loadConfigFile reads the configuration file at the given path and returns the defaulted and validated configuration.
+func loadConfigFile(path string) (*configv1alpha1.ApiserverProxySidecarConfiguration, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the path is given by the operator
+	if err != nil {
+		return nil, xerrors.Errorf("could not read config file %s: %v", path, err)
+	}
+
+	cfg := &configv1alpha1.ApiserverProxySidecarConfiguration{}
+	if err := runtime.DecodeInto(configDecoder, data, cfg); err != nil {
+		return nil, xerrors.Errorf("could not decode config file %s: %v", path, err)
+	}
+
+	if errs := validation.ValidateApiserverProxySidecarConfiguration(cfg); len(errs) > 0 {
+		return nil, xerrors.Errorf("invalid config file %s: %v", path, errs.ToAggregate())
+	}
+
+	return cfg, nil
+}
+
+// applyConfig sets the parameters from the configuration, except for those whose flag was set explicitly.
+func applyConfig(fs *flag.FlagSet, cfg *configv1alpha1.ApiserverProxySidecarConfiguration, params *app.ConfigParams) {
+	apply := func(name string, set func()) {
+		// Lookup the flag instead of using fs.Changed, as the flags of a subcommand are parsed by its own flag set.
+		if f := fs.Lookup(name); f == nil || !f.Changed {
+			set()
+		}
+	}
+
+	apply("ip-address", func() { params.IPAddresses = cfg.IPAddresses })
+	apply("ip-address-source", func() { params.IPAddressSource = ipAddressSource(cfg.IPAddressSource) })
+	apply("node-name", func() { params.NodeName = cfg.NodeName })
+	apply("netns", func() { params.NetNS = cfg.NetNS })
+	apply("port", func() { params.LocalPort = strconv.Itoa(int(*cfg.Port)) })
+	apply("interface", func() { params.Interface = cfg.Interface })
+	apply("interface-mode", func() { params.InterfaceMode = cfg.InterfaceMode })
+	apply("sync-interval", func() { params.Interval = cfg.SyncInterval.Duration })
+	apply("daemon", func() { params.Daemon = *cfg.Daemon })
+	apply("cleanup", func() { params.Cleanup = cfg.Cleanup })
+	apply("state-file", func() { params.StateFile = *cfg.StateFile })
+	apply("handover-file", func() { params.HandoverFile = cfg.HandoverFile })
+	apply("instance-id", func() { params.InstanceID = cfg.InstanceID })
+	apply("setup-iptables", func() { params.SetupIptables = cfg.Rules.Enabled })
+	apply("rules-backend", func() { params.RulesBackend = cfg.Rules.Backend })
+	apply("probe", func() { params.ProbeMode = cfg.Probe.Mode })
+	apply("probe-timeout", func() { params.ProbeTimeout = cfg.Probe.Timeout.Duration })
+	apply("health-bind-address", func() { params.HealthBindAddress = cfg.Server.HealthBindAddress })
+	apply("metrics-bind-address", func() { params.MetricsBindAddress = cfg.Server.MetricsBindAddress })
+	apply("duplicate-policy", func() { params.DuplicatePolicy = cfg.Duplicates.Policy })
+	apply("duplicate-include", func() { params.DuplicateInclude = cfg.Duplicates.Include })
+	apply("duplicate-exclude", func() { params.DuplicateExclude = cfg.Duplicates.Exclude })
+	apply("conflict-threshold", func() { params.ConflictThreshold = int(*cfg.Conflicts.Threshold) })
+	apply("conflict-window", func() { params.ConflictWindow = cfg.Conflicts.Window.Duration })
+	apply("conflict-backoff", func() {
+		params.ConflictBackoff = 0
+		if cfg.Conflicts.MaxBackoff != nil {
+			params.ConflictBackoff = cfg.Conflicts.MaxBackoff.Duration
+		}
+	})
+	apply("conntrack-flush", func() { params.ConntrackFlush = cfg.Conntrack.Flush })
+	apply("node-condition", func() { params.NodeCondition = cfg.Reporting.NodeCondition })
+	apply("record-events", func() { params.RecordEvents = cfg.Reporting.Events })
+	apply("proxy-upstream", func() { params.ProxyUpstreams = proxyUpstreams(cfg.Proxy.Upstreams) })
+	apply("proxy-load-balancing", func() { params.ProxyLoadBalancing = cfg.Proxy.LoadBalancing })
+	apply("proxy-unhealthy-threshold", func() { params.ProxyUnhealthyThreshold = int(*cfg.Proxy.UnhealthyThreshold) })
+	apply("proxy-dial-timeout", func() { params.ProxyDialTimeout = cfg.Proxy.DialTimeout.Duration })
+	apply("proxy-health-check-interval", func() { params.ProxyHealthCheckInterval = cfg.Proxy.HealthCheckInterval.Duration })
+	apply("proxy-drain-timeout", func() { params.ProxyDrainTimeout = cfg.Proxy.DrainTimeout.Duration })
+	apply("shutdown-grace-period", func() {
+		params.ShutdownGracePeriod = 0
+		if cfg.Shutdown.GracePeriod != nil {
+			params.ShutdownGracePeriod = cfg.Shutdown.GracePeriod.Duration
+		}
+	})
+	apply("shutdown-timeout", func() {
+		params.ShutdownTimeout = 0
+		if cfg.Shutdown.Timeout != nil {
+			params.ShutdownTimeout = cfg.Shutdown.Timeout.Duration
+		}
+	})
+}
+
+// ipAddressSource returns the given source in the format of the --ip-address-source flag.
+func ipAddressSource(src *configv1alpha1.IPAddressSource) string {
+	var ref *source.Reference
+
+	switch {
+	case src == nil:
+		return ""
+	case src.Service != nil:
+		ref = &source.Reference{Kind: source.KindService, Namespace: src.Service.Namespace, Name: src.Service.Name}
+	case src.ConfigMap != nil:
+		ref = &source.Reference{Kind: source.KindConfigMap, Namespace: src.ConfigMap.Namespace, Name: src.ConfigMap.Name, Key: src.ConfigMap.Key}
+	case src.NodeAnnotation != nil:
+		ref = &source.Reference{Kind: source.KindNodeAnnotation, Key: src.NodeAnnotation.Key}
+	default:
+		return ""
+	}
+
+	return ref.String()
+}
+
+// proxyUpstreams returns the given upstreams in the format of the --proxy-upstream flag.
+func proxyUpstreams(upstreams []configv1alpha1.ProxyUpstream) []string {
+	var result []string
+
+	for _, u := range upstreams {
+		address := u.Address + upstreamOptions(u)
+		if len(u.ServerNames) == 0 {
+			result = append(result, address)
+			continue
+		}
+
+		for _, serverName := range u.ServerNames {
+			result = append(result, serverName+"="+address)
+		}
+	}
+
+	return result
+}
+
+// upstreamOptions returns the PROXY protocol and health check configuration of the given upstream as options
+// of the --proxy-upstream flag.
+func upstreamOptions(u configv1alpha1.ProxyUpstream) string {
+	options := url.Values{}
+
+	if pp := u.ProxyProtocol; pp != nil {
+		options.Set("proxy-protocol", pp.Version)
+		if pp.Authority {
+			options.Set("authority", "true")
+		}
+		for _, tlv := range pp.TLVs {
+			options.Add("tlv", strconv.Itoa(int(tlv.Type))+":"+tlv.Value)
+		}
+	}
+
+	if hc := u.HealthCheck; hc != nil {
+		options.Set("health-check", hc.Mode)
+		if hc.TokenFile != "" {
+			options.Set("token-file", hc.TokenFile)
+		}
+		if hc.CAFile != "" {
+			options.Set("ca-file", hc.CAFile)
+		}
+	}
+
+	if len(options) == 0 {
+		return ""
+	}
+
+	return "?" + options.Encode()
+}
+
+// reloadParams returns a copy of the given parameters updated with the values of the configuration file,
+// so that explicitly set flags keep taking precedence.
+func reloadParams(fs *flag.FlagSet, path string, params *app.ConfigParams) (*app.ConfigParams, error) {
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reloaded := *params
+	applyConfig(fs, cfg, &reloaded)
+
+	if err := validateParams(&reloaded); err != nil {
+		return nil, err
+	}
+
+	return &reloaded, nil
+}
+
+// validateParams validates the parameters which are required by all commands managing the resources.
+func validateParams(params *app.ConfigParams) error {
+	if len(params.IPAddresses) == 0 && params.IPAddressSource == "" {
+		return xerrors.New("--ip-address or --ip-address-source is required")
+	}
+
+	if len(params.IPAddresses) > 0 && params.IPAddressSource != "" {
+		return xerrors.New("--ip-address and --ip-address-source are mutually exclusive")
+	}
+
+	if (params.NodeCondition || params.RecordEvents) && params.NodeName == "" {
+		return xerrors.New("--node-name is required for --node-condition and --record-events")
+	}
+
+	if params.ShutdownTimeout > 0 && params.ShutdownGracePeriod > params.ShutdownTimeout {
+		return xerrors.New("--shutdown-grace-period must not exceed --shutdown-timeout")
+	}
+
+	return nil
+}
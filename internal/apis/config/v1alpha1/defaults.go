@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// SetDefaults_ApiserverProxySidecarConfiguration sets defaults for the ApiserverProxySidecarConfiguration.
+func SetDefaults_ApiserverProxySidecarConfiguration(obj *ApiserverProxySidecarConfiguration) {
+	if obj.Port == nil {
+		obj.Port = ptr.To[int32](9443)
+	}
+	if len(obj.Interface) == 0 {
+		obj.Interface = "lo"
+	}
+	if obj.SyncInterval == nil {
+		obj.SyncInterval = &metav1.Duration{Duration: time.Minute}
+	}
+	if obj.Daemon == nil {
+		obj.Daemon = ptr.To(true)
+	}
+	if obj.StateFile == nil {
+		obj.StateFile = ptr.To("/run/apiserver-proxy/state.json")
+	}
+}
+
+// SetDefaults_RulesConfiguration sets defaults for the RulesConfiguration.
+func SetDefaults_RulesConfiguration(obj *RulesConfiguration) {
+	if len(obj.Backend) == 0 {
+		obj.Backend = RulesBackendIPTables
+	}
+}
+
+// SetDefaults_ProbeConfiguration sets defaults for the ProbeConfiguration.
+func SetDefaults_ProbeConfiguration(obj *ProbeConfiguration) {
+	if len(obj.Mode) == 0 {
+		obj.Mode = ProbeModeNone
+	}
+	if obj.Timeout == nil {
+		obj.Timeout = &metav1.Duration{Duration: 5 * time.Second}
+	}
+}
+
+// SetDefaults_DuplicatesConfiguration sets defaults for the DuplicatesConfiguration.
+func SetDefaults_DuplicatesConfiguration(obj *DuplicatesConfiguration) {
+	if len(obj.Policy) == 0 {
+		obj.Policy = DuplicatePolicyRemove
+	}
+}
+
+// SetDefaults_ConflictsConfiguration sets defaults for the ConflictsConfiguration.
+func SetDefaults_ConflictsConfiguration(obj *ConflictsConfiguration) {
+	if obj.Threshold == nil {
+		obj.Threshold = ptr.To[int32](3)
+	}
+	if obj.Window == nil {
+		obj.Window = &metav1.Duration{Duration: 10 * time.Minute}
+	}
+}
+
+// SetDefaults_ProxyConfiguration sets defaults for the ProxyConfiguration.
+func SetDefaults_ProxyConfiguration(obj *ProxyConfiguration) {
+	if obj.DialTimeout == nil {
+		obj.DialTimeout = &metav1.Duration{Duration: 5 * time.Second}
+	}
+	if obj.HealthCheckInterval == nil {
+		obj.HealthCheckInterval = &metav1.Duration{Duration: 10 * time.Second}
+	}
+	if obj.DrainTimeout == nil {
+		obj.DrainTimeout = &metav1.Duration{Duration: 30 * time.Second}
+	}
+	if obj.LoadBalancing == "" {
+		obj.LoadBalancing = LoadBalancingRoundRobin
+	}
+	if obj.UnhealthyThreshold == nil {
+		obj.UnhealthyThreshold = ptr.To[int32](3)
+	}
+}
@@ -0,0 +1,11 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// +k8s:deepcopy-gen=package
+// +k8s:defaulter-gen=TypeMeta
+// +groupName=apiserverproxy.config.gardener.cloud
+
+//go:generate bash ../../../../hack/update-codegen.sh
+
+// Package v1alpha1 contains the configuration API of the apiserver-proxy-sidecar.
+package v1alpha1 // import "github.com/gardener/apiserver-proxy/internal/apis/config/v1alpha1"
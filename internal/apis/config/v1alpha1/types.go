@@ -0,0 +1,348 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RulesBackendIPTables sets up the rules with iptables and ip6tables.
+	RulesBackendIPTables = "iptables"
+	// RulesBackendNFTables sets up the rules with nft.
+	RulesBackendNFTables = "nftables"
+
+	// ProbeModeNone disables probing the proxy.
+	ProbeModeNone = "none"
+	// ProbeModeTCP probes the proxy by opening a TCP connection.
+	ProbeModeTCP = "tcp"
+	// ProbeModeTLS probes the proxy by completing a TLS handshake.
+	ProbeModeTLS = "tls"
+
+	// DuplicatePolicyRemove removes duplicates of the IP addresses from other interfaces.
+	DuplicatePolicyRemove = "remove"
+	// DuplicatePolicyWarn keeps duplicates of the IP addresses on other interfaces and warns about them.
+	DuplicatePolicyWarn = "warn"
+	// DuplicatePolicyFail keeps duplicates of the IP addresses on other interfaces and fails ensuring the addresses.
+	DuplicatePolicyFail = "fail"
+
+	// InterfaceModeCreateDummy creates a dummy interface owned by the sidecar if the interface does not exist.
+	InterfaceModeCreateDummy = "create-dummy"
+	// InterfaceModeExisting uses an existing interface of any type.
+	InterfaceModeExisting = "existing"
+	// InterfaceModeLoopback uses the loopback interface.
+	InterfaceModeLoopback = "loopback"
+
+	// ProxyProtocolV1 sends the human-readable version 1 of the PROXY protocol.
+	ProxyProtocolV1 = "v1"
+	// ProxyProtocolV2 sends the binary version 2 of the PROXY protocol, which supports TLVs.
+	ProxyProtocolV2 = "v2"
+
+	// LoadBalancingRoundRobin distributes the connections evenly over the upstreams.
+	LoadBalancingRoundRobin = "round-robin"
+	// LoadBalancingLeastConnections forwards the connections to the upstream with the fewest active connections.
+	LoadBalancingLeastConnections = "least-connections"
+	// LoadBalancingFailover forwards the connections to the first upstream, the others are only used if it fails.
+	LoadBalancingFailover = "failover"
+
+	// HealthCheckTCP checks an upstream by establishing a TCP connection.
+	HealthCheckTCP = "tcp"
+	// HealthCheckTLS checks an upstream by establishing a TCP connection and performing a TLS handshake.
+	HealthCheckTLS = "tls"
+	// HealthCheckHTTPS checks an upstream by requesting its /readyz endpoint.
+	HealthCheckHTTPS = "https"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ApiserverProxySidecarConfiguration defines the configuration of the apiserver-proxy-sidecar.
+type ApiserverProxySidecarConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// IPAddresses are the addresses on which the proxy is listening, at most one per IP family.
+	// +optional
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+	// IPAddressSource defines an object in the cluster the IP addresses are derived from instead of IPAddresses.
+	// +optional
+	IPAddressSource *IPAddressSource `json:"ipAddressSource,omitempty"`
+	// NodeName is the name of the node the sidecar runs on, required for deriving the IP addresses from an
+	// annotation of the node.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// NetNS is the path, e.g. /var/run/netns/<name>, or the PID of a process of the network namespace to manage.
+	// Defaults to the network namespace of the sidecar.
+	// +optional
+	NetNS string `json:"netns,omitempty"`
+	// Port is the port on which the proxy is listening. Defaults to 9443.
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+	// Interface is the name of the interface to add the addresses to. Defaults to "lo".
+	// +optional
+	Interface string `json:"interface,omitempty"`
+	// InterfaceMode is how the interface is provided, one of [create-dummy,existing,loopback]. The type of an
+	// existing interface must match the mode. If empty, it is derived from the interface in effect, which may be
+	// overridden by the --interface flag: "loopback" for "lo" and "create-dummy" otherwise.
+	// +optional
+	InterfaceMode string `json:"interfaceMode,omitempty"`
+	// SyncInterval is the interval in which the addresses and rules are reconciled. Defaults to 1m.
+	// +optional
+	SyncInterval *metav1.Duration `json:"syncInterval,omitempty"`
+	// Daemon indicates whether the sidecar keeps running after the initial reconciliation. Defaults to true.
+	// +optional
+	Daemon *bool `json:"daemon,omitempty"`
+	// Cleanup indicates whether the addresses, rules and created interface are removed on exit.
+	// +optional
+	Cleanup bool `json:"cleanup,omitempty"`
+	// StateFile is the path of the file recording the managed resources. Defaults to
+	// "/run/apiserver-proxy/state.json", an empty value disables it.
+	// +optional
+	StateFile *string `json:"stateFile,omitempty"`
+	// HandoverFile is the path of the lock file recording the instance owning the addresses, so that an instance does
+	// not remove them on exit after a newer one took them over, e.g. during a rolling update. The directory should be
+	// mounted from the host, e.g. /run/apiserver-proxy/owner.lock next to the state file. Disabled if empty.
+	// +optional
+	HandoverFile string `json:"handoverFile,omitempty"`
+	// InstanceID is the ID of the instance in the handover file, e.g. the UID of the pod from the downward API, so that
+	// the setup and teardown commands are the same instance as the daemon of their pod. Random if empty.
+	// +optional
+	InstanceID string `json:"instanceID,omitempty"`
+	// Rules defines the configuration of the rules for the addresses and port.
+	// +optional
+	Rules RulesConfiguration `json:"rules"`
+	// Probe defines the configuration of probing the proxy.
+	// +optional
+	Probe ProbeConfiguration `json:"probe"`
+	// Server defines the configuration of the HTTP servers.
+	// +optional
+	Server ServerConfiguration `json:"server"`
+	// Duplicates defines how duplicates of the IP addresses on other interfaces are handled.
+	// +optional
+	Duplicates DuplicatesConfiguration `json:"duplicates"`
+	// Conflicts defines the detection of conflicts with other agents which keep changing the IP addresses.
+	// +optional
+	Conflicts ConflictsConfiguration `json:"conflicts"`
+	// Conntrack defines the handling of the conntrack entries of the IP addresses.
+	// +optional
+	Conntrack ConntrackConfiguration `json:"conntrack"`
+	// Reporting defines the configuration of reporting the state of the IP addresses on the node.
+	// +optional
+	Reporting ReportingConfiguration `json:"reporting"`
+	// Proxy defines the configuration of the embedded proxy forwarding the connections to the kube-apiservers.
+	// +optional
+	Proxy ProxyConfiguration `json:"proxy"`
+	// Shutdown defines the sequence on exit before the addresses are removed.
+	// +optional
+	Shutdown ShutdownConfiguration `json:"shutdown"`
+}
+
+// IPAddressSource defines the object in the cluster the IP addresses are derived from. Exactly one of the
+// fields must be set.
+type IPAddressSource struct {
+	// Service derives the IP addresses from the ClusterIPs of a Service.
+	// +optional
+	Service *ServiceReference `json:"service,omitempty"`
+	// ConfigMap derives the IP addresses from a comma separated list in a key of a ConfigMap.
+	// +optional
+	ConfigMap *ConfigMapKeyReference `json:"configMap,omitempty"`
+	// NodeAnnotation derives the IP addresses from a comma separated list in an annotation of the node.
+	// +optional
+	NodeAnnotation *NodeAnnotationReference `json:"nodeAnnotation,omitempty"`
+}
+
+// ServiceReference references a Service.
+type ServiceReference struct {
+	// Namespace is the namespace of the Service.
+	Namespace string `json:"namespace"`
+	// Name is the name of the Service.
+	Name string `json:"name"`
+}
+
+// ConfigMapKeyReference references a key of a ConfigMap.
+type ConfigMapKeyReference struct {
+	// Namespace is the namespace of the ConfigMap.
+	Namespace string `json:"namespace"`
+	// Name is the name of the ConfigMap.
+	Name string `json:"name"`
+	// Key is the key of the ConfigMap.
+	Key string `json:"key"`
+}
+
+// NodeAnnotationReference references an annotation of the node the sidecar runs on.
+type NodeAnnotationReference struct {
+	// Key is the key of the annotation.
+	Key string `json:"key"`
+}
+
+// RulesConfiguration contains the configuration of the rules for the addresses and port.
+type RulesConfiguration struct {
+	// Enabled indicates whether the rules are set up.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// Backend is the backend used to set up the rules, one of [iptables,nftables]. Defaults to "iptables". The
+	// nftables rules are kept in a separate table, so they do not override drop rules of other tables.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+}
+
+// ProbeConfiguration contains the configuration of probing the proxy.
+type ProbeConfiguration struct {
+	// Mode is how the proxy is probed, one of [none,tcp,tls]. Defaults to "none".
+	// +optional
+	Mode string `json:"mode,omitempty"`
+	// Timeout is the timeout for probing the proxy. Defaults to 5s.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ServerConfiguration contains the configuration of the HTTP servers served in daemon mode.
+type ServerConfiguration struct {
+	// HealthBindAddress is the address on which the /healthz, /readyz and /livez endpoints are served.
+	// An empty value disables them.
+	// +optional
+	HealthBindAddress string `json:"healthBindAddress,omitempty"`
+	// MetricsBindAddress is the address on which the /metrics endpoint is served. An empty value disables it.
+	// +optional
+	MetricsBindAddress string `json:"metricsBindAddress,omitempty"`
+}
+
+// DuplicatesConfiguration contains the configuration of handling duplicates of the IP addresses on other interfaces.
+type DuplicatesConfiguration struct {
+	// Policy is how duplicates are handled, one of [fail,remove,warn]. Defaults to "remove".
+	// +optional
+	Policy string `json:"policy,omitempty"`
+	// Include are the patterns of the interfaces which are checked for duplicates, e.g. "eth*". All interfaces
+	// are checked if empty.
+	// +optional
+	Include []string `json:"include,omitempty"`
+	// Exclude are the patterns of the interfaces which are never checked for duplicates, e.g. "kube-ipvs0".
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ConflictsConfiguration contains the configuration of detecting conflicts with other agents, e.g. kube-proxy or a
+// CNI plugin, which keep removing the IP addresses or adding them to other interfaces.
+type ConflictsConfiguration struct {
+	// Threshold is the number of repairs within the window from which on a conflict is detected. Defaults to 3,
+	// zero disables the detection.
+	// +optional
+	Threshold *int32 `json:"threshold,omitempty"`
+	// Window is the time window in which the repairs are counted. Defaults to 10m.
+	// +optional
+	Window *metav1.Duration `json:"window,omitempty"`
+	// MaxBackoff is the maximum delay between repairs while in conflict, which is doubled on every repair.
+	// Repairs are not delayed if unset or zero.
+	// +optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+}
+
+// ConntrackConfiguration contains the configuration of the handling of the conntrack entries of the IP addresses.
+type ConntrackConfiguration struct {
+	// Flush indicates whether the conntrack entries of the IP addresses and port are flushed after the addresses were
+	// (re)added, so that connections tracked while they were missing do not keep failing.
+	// +optional
+	Flush bool `json:"flush,omitempty"`
+}
+
+// ReportingConfiguration contains the configuration of reporting the state of the IP addresses on the node.
+// It requires the node name.
+type ReportingConfiguration struct {
+	// NodeCondition indicates whether the APIServerProxyAddressReady condition of the node is maintained.
+	// +optional
+	NodeCondition bool `json:"nodeCondition,omitempty"`
+	// Events indicates whether events are recorded on the node when the state of the IP addresses changes.
+	// +optional
+	Events bool `json:"events,omitempty"`
+}
+
+// ProxyConfiguration contains the configuration of the embedded proxy, which listens on the IP addresses and port
+// in daemon mode and forwards the connections to the kube-apiservers without terminating them.
+type ProxyConfiguration struct {
+	// Upstreams are the kube-apiserver endpoints, for the failover load balancing in the order they are preferred.
+	// The embedded proxy is disabled if empty.
+	// +optional
+	Upstreams []ProxyUpstream `json:"upstreams,omitempty"`
+	// LoadBalancing is how the connections are distributed over the healthy upstreams, one of
+	// [round-robin,least-connections,failover]. Defaults to round-robin.
+	// +optional
+	LoadBalancing string `json:"loadBalancing,omitempty"`
+	// DialTimeout is the timeout for connecting to an upstream, for reading the TLS ClientHello of a client and for
+	// the health checks. Defaults to 5s.
+	// +optional
+	DialTimeout *metav1.Duration `json:"dialTimeout,omitempty"`
+	// HealthCheckInterval is the interval in which the upstreams are checked. Defaults to 10s.
+	// +optional
+	HealthCheckInterval *metav1.Duration `json:"healthCheckInterval,omitempty"`
+	// UnhealthyThreshold is the number of consecutive failed health checks or connection attempts after which an
+	// upstream is ejected until the next successful health check. Defaults to 3.
+	// +optional
+	UnhealthyThreshold *int32 `json:"unhealthyThreshold,omitempty"`
+	// DrainTimeout is how long the active connections are drained on exit before they are closed. Defaults to 30s.
+	// +optional
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+}
+
+// ShutdownConfiguration contains the configuration of the sequence on exit in daemon mode: the sidecar is reported not
+// ready, the connections are given the grace period to finish and the embedded proxy is drained before the addresses
+// and the created interface are removed. The timeout should fit into the terminationGracePeriodSeconds of the pod.
+type ShutdownConfiguration struct {
+	// GracePeriod is how long the established connections to the addresses and port are waited for before the
+	// addresses are removed, ending early once there are none left. Requires cleanup. Disabled if unset or zero.
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+	// Timeout is the deadline for the grace period and draining the connections of the embedded proxy. Unbounded if
+	// unset or zero.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ProxyUpstream is a kube-apiserver endpoint of the embedded proxy.
+type ProxyUpstream struct {
+	// Address is the host and port of the endpoint.
+	Address string `json:"address"`
+	// ServerNames are the TLS server names (SNI) the connections are forwarded to this endpoint for. If empty, it
+	// gets all connections with a server name which is not served by another endpoint.
+	// +optional
+	ServerNames []string `json:"serverNames,omitempty"`
+	// ProxyProtocol configures the PROXY protocol header sent to the endpoint before the data of every connection,
+	// so that it gets the address of the client. No header is sent if nil.
+	// +optional
+	ProxyProtocol *ProxyProtocolConfiguration `json:"proxyProtocol,omitempty"`
+	// HealthCheck configures how the endpoint is checked. It is checked by establishing a TCP connection if nil.
+	// +optional
+	HealthCheck *ProxyHealthCheck `json:"healthCheck,omitempty"`
+}
+
+// ProxyHealthCheck contains the configuration of the health check of an upstream.
+type ProxyHealthCheck struct {
+	// Mode is how the upstream is checked, one of [tcp,tls,https]. https requests the /readyz endpoint.
+	Mode string `json:"mode"`
+	// TokenFile is the path of a file containing the bearer token sent to the /readyz endpoint, e.g. of a
+	// projected service account token. It is read for every check. Requires mode https and the CAFile.
+	// +optional
+	TokenFile string `json:"tokenFile,omitempty"`
+	// CAFile is the path of a file containing the CA certificates the upstream is verified with. The certificate
+	// of the upstream is not verified if empty. Requires mode tls or https.
+	// +optional
+	CAFile string `json:"caFile,omitempty"`
+}
+
+// ProxyProtocolConfiguration contains the configuration of the PROXY protocol.
+type ProxyProtocolConfiguration struct {
+	// Version is the version of the PROXY protocol, one of [v1,v2].
+	Version string `json:"version"`
+	// Authority indicates whether the TLS server name of the connection is sent as TLV. Requires version v2.
+	// +optional
+	Authority bool `json:"authority,omitempty"`
+	// TLVs are additional TLVs sent with every header. Requires version v2.
+	// +optional
+	TLVs []ProxyProtocolTLV `json:"tlvs,omitempty"`
+}
+
+// ProxyProtocolTLV is a type-length-value field of a header of version 2 of the PROXY protocol.
+type ProxyProtocolTLV struct {
+	// Type is the type of the TLV from 0 to 255, 224 (0xE0) to 239 (0xEF) are reserved for custom ones.
+	Type int32 `json:"type"`
+	// Value is the value of the TLV.
+	Value string `json:"value"`
+}
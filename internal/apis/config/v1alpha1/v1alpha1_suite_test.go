@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestV1alpha1(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config V1alpha1 Suite")
+}
+
+var _ = Describe("Defaulting", func() {
+
+	var obj *ApiserverProxySidecarConfiguration
+
+	BeforeEach(func() {
+		obj = &ApiserverProxySidecarConfiguration{}
+	})
+
+	It("should default the configuration", func() {
+		SetObjectDefaults_ApiserverProxySidecarConfiguration(obj)
+
+		Expect(obj).To(Equal(&ApiserverProxySidecarConfiguration{
+			Port:         ptr.To[int32](9443),
+			Interface:    "lo",
+			SyncInterval: &metav1.Duration{Duration: time.Minute},
+			Daemon:       ptr.To(true),
+			StateFile:    ptr.To("/run/apiserver-proxy/state.json"),
+			Rules:        RulesConfiguration{Backend: RulesBackendIPTables},
+			Probe:        ProbeConfiguration{Mode: ProbeModeNone, Timeout: &metav1.Duration{Duration: 5 * time.Second}},
+			Duplicates:   DuplicatesConfiguration{Policy: DuplicatePolicyRemove},
+			Conflicts:    ConflictsConfiguration{Threshold: ptr.To[int32](3), Window: &metav1.Duration{Duration: 10 * time.Minute}},
+			Proxy: ProxyConfiguration{
+				LoadBalancing:       LoadBalancingRoundRobin,
+				DialTimeout:         &metav1.Duration{Duration: 5 * time.Second},
+				HealthCheckInterval: &metav1.Duration{Duration: 10 * time.Second},
+				UnhealthyThreshold:  ptr.To[int32](3),
+				DrainTimeout:        &metav1.Duration{Duration: 30 * time.Second},
+			},
+		}))
+	})
+
+	It("should not default the interface mode, so that it is derived from the interface in effect", func() {
+		obj.Interface = "apiserver-proxy"
+
+		SetObjectDefaults_ApiserverProxySidecarConfiguration(obj)
+
+		Expect(obj.InterfaceMode).To(BeEmpty())
+	})
+
+	It("should not overwrite already set values", func() {
+		obj = &ApiserverProxySidecarConfiguration{
+			Port:          ptr.To[int32](443),
+			Interface:     "eth0",
+			InterfaceMode: InterfaceModeExisting,
+			SyncInterval:  &metav1.Duration{Duration: time.Second},
+			Daemon:        ptr.To(false),
+			StateFile:     ptr.To(""),
+			HandoverFile:  "/run/apiserver-proxy/owner.lock",
+			Rules:         RulesConfiguration{Backend: RulesBackendNFTables},
+			Probe:         ProbeConfiguration{Mode: ProbeModeTLS, Timeout: &metav1.Duration{Duration: time.Second}},
+			Duplicates:    DuplicatesConfiguration{Policy: DuplicatePolicyWarn},
+			Conflicts:     ConflictsConfiguration{Threshold: ptr.To[int32](0), Window: &metav1.Duration{Duration: time.Minute}},
+			Proxy: ProxyConfiguration{
+				LoadBalancing:       LoadBalancingFailover,
+				DialTimeout:         &metav1.Duration{Duration: time.Second},
+				HealthCheckInterval: &metav1.Duration{Duration: time.Minute},
+				UnhealthyThreshold:  ptr.To[int32](1),
+				DrainTimeout:        &metav1.Duration{},
+			},
+		}
+		expected := obj.DeepCopy()
+
+		SetObjectDefaults_ApiserverProxySidecarConfiguration(obj)
+
+		Expect(obj).To(Equal(expected))
+	})
+})
@@ -0,0 +1,307 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package validation
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	configv1alpha1 "github.com/gardener/apiserver-proxy/internal/apis/config/v1alpha1"
+)
+
+var (
+	availableRulesBackends     = sets.New(configv1alpha1.RulesBackendIPTables, configv1alpha1.RulesBackendNFTables)
+	availableProbeModes        = sets.New(configv1alpha1.ProbeModeNone, configv1alpha1.ProbeModeTCP, configv1alpha1.ProbeModeTLS)
+	availableDuplicatePolicies = sets.New(configv1alpha1.DuplicatePolicyRemove, configv1alpha1.DuplicatePolicyWarn,
+		configv1alpha1.DuplicatePolicyFail)
+	availableInterfaceModes = sets.New(configv1alpha1.InterfaceModeCreateDummy, configv1alpha1.InterfaceModeExisting,
+		configv1alpha1.InterfaceModeLoopback)
+	availableProxyProtocolVersions = sets.New(configv1alpha1.ProxyProtocolV1, configv1alpha1.ProxyProtocolV2)
+	availableLoadBalancings        = sets.New(configv1alpha1.LoadBalancingRoundRobin, configv1alpha1.LoadBalancingLeastConnections,
+		configv1alpha1.LoadBalancingFailover)
+	availableHealthChecks = sets.New(configv1alpha1.HealthCheckTCP, configv1alpha1.HealthCheckTLS, configv1alpha1.HealthCheckHTTPS)
+)
+
+// ValidateApiserverProxySidecarConfiguration validates the given `ApiserverProxySidecarConfiguration`.
+// The IP addresses are not required, as they may also be given on the command line.
+func ValidateApiserverProxySidecarConfiguration(conf *configv1alpha1.ApiserverProxySidecarConfiguration) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	allErrs = append(allErrs, validateIPAddresses(conf.IPAddresses, field.NewPath("ipAddresses"))...)
+
+	if conf.IPAddressSource != nil {
+		if len(conf.IPAddresses) > 0 {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("ipAddressSource"), "must not be set together with ipAddresses"))
+		}
+
+		allErrs = append(allErrs, validateIPAddressSource(conf.IPAddressSource, field.NewPath("ipAddressSource"))...)
+	}
+
+	if conf.Port != nil && (*conf.Port < 1 || *conf.Port > 65535) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("port"), *conf.Port, "must be between 1 and 65535"))
+	}
+
+	if len(conf.Interface) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("interface"), "must provide an interface"))
+	}
+
+	if len(conf.InterfaceMode) > 0 && !availableInterfaceModes.Has(conf.InterfaceMode) {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("interfaceMode"), conf.InterfaceMode, sets.List(availableInterfaceModes)))
+	} else if conf.InterfaceMode == configv1alpha1.InterfaceModeLoopback && conf.Interface != "lo" {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("interfaceMode"), conf.InterfaceMode, "requires the interface lo"))
+	}
+
+	if conf.SyncInterval != nil && conf.SyncInterval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("syncInterval"), conf.SyncInterval.Duration, "must be positive"))
+	}
+
+	allErrs = append(allErrs, validateRulesConfiguration(conf.Rules, field.NewPath("rules"))...)
+	allErrs = append(allErrs, validateProbeConfiguration(conf.Probe, field.NewPath("probe"))...)
+	allErrs = append(allErrs, validateDuplicatesConfiguration(conf.Duplicates, field.NewPath("duplicates"))...)
+	allErrs = append(allErrs, validateConflictsConfiguration(conf.Conflicts, field.NewPath("conflicts"))...)
+	allErrs = append(allErrs, validateProxyConfiguration(conf.Proxy, field.NewPath("proxy"))...)
+	allErrs = append(allErrs, validateShutdownConfiguration(conf.Shutdown, field.NewPath("shutdown"))...)
+
+	return allErrs
+}
+
+func validateIPAddresses(addresses []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	families := map[bool]bool{}
+
+	for i, address := range addresses {
+		ip, err := netip.ParseAddr(address)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), address, "must be a valid IP address"))
+			continue
+		}
+
+		if families[ip.Is4()] {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), address, "must not contain more than one address per IP family"))
+		}
+		families[ip.Is4()] = true
+	}
+
+	return allErrs
+}
+
+func validateIPAddressSource(src *configv1alpha1.IPAddressSource, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	required := func(value string, fldPath *field.Path) {
+		if len(value) == 0 {
+			allErrs = append(allErrs, field.Required(fldPath, "must not be empty"))
+		}
+	}
+
+	sources := 0
+
+	if src.Service != nil {
+		sources++
+		required(src.Service.Namespace, fldPath.Child("service", "namespace"))
+		required(src.Service.Name, fldPath.Child("service", "name"))
+	}
+
+	if src.ConfigMap != nil {
+		sources++
+		required(src.ConfigMap.Namespace, fldPath.Child("configMap", "namespace"))
+		required(src.ConfigMap.Name, fldPath.Child("configMap", "name"))
+		required(src.ConfigMap.Key, fldPath.Child("configMap", "key"))
+	}
+
+	if src.NodeAnnotation != nil {
+		sources++
+		required(src.NodeAnnotation.Key, fldPath.Child("nodeAnnotation", "key"))
+	}
+
+	if sources != 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath, sources, "exactly one of service, configMap or nodeAnnotation must be set"))
+	}
+
+	return allErrs
+}
+
+func validateRulesConfiguration(conf configv1alpha1.RulesConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !availableRulesBackends.Has(conf.Backend) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("backend"), conf.Backend, sets.List(availableRulesBackends)))
+	}
+
+	return allErrs
+}
+
+func validateProbeConfiguration(conf configv1alpha1.ProbeConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !availableProbeModes.Has(conf.Mode) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("mode"), conf.Mode, sets.List(availableProbeModes)))
+	}
+
+	if conf.Timeout != nil && conf.Timeout.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("timeout"), conf.Timeout.Duration, "must be positive"))
+	}
+
+	return allErrs
+}
+
+func validateDuplicatesConfiguration(conf configv1alpha1.DuplicatesConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !availableDuplicatePolicies.Has(conf.Policy) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("policy"), conf.Policy, sets.List(availableDuplicatePolicies)))
+	}
+
+	allErrs = append(allErrs, validateInterfacePatterns(conf.Include, fldPath.Child("include"))...)
+	allErrs = append(allErrs, validateInterfacePatterns(conf.Exclude, fldPath.Child("exclude"))...)
+
+	return allErrs
+}
+
+func validateInterfacePatterns(patterns []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for i, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), pattern, "must be a valid pattern"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateConflictsConfiguration(conf configv1alpha1.ConflictsConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if conf.Threshold != nil && *conf.Threshold < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("threshold"), *conf.Threshold, "must not be negative"))
+	}
+
+	if conf.Window != nil && conf.Window.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("window"), conf.Window.Duration, "must be positive"))
+	}
+
+	if conf.MaxBackoff != nil && conf.MaxBackoff.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxBackoff"), conf.MaxBackoff.Duration, "must not be negative"))
+	}
+
+	return allErrs
+}
+
+func validateProxyConfiguration(conf configv1alpha1.ProxyConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for i, upstream := range conf.Upstreams {
+		idxPath := fldPath.Child("upstreams").Index(i)
+
+		if _, _, err := net.SplitHostPort(upstream.Address); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("address"), upstream.Address, "must be a host and port"))
+		}
+
+		for j, serverName := range upstream.ServerNames {
+			if len(serverName) == 0 {
+				allErrs = append(allErrs, field.Required(idxPath.Child("serverNames").Index(j), "must not be empty"))
+			}
+		}
+
+		if upstream.ProxyProtocol != nil {
+			allErrs = append(allErrs, validateProxyProtocolConfiguration(*upstream.ProxyProtocol, idxPath.Child("proxyProtocol"))...)
+		}
+
+		if upstream.HealthCheck != nil {
+			allErrs = append(allErrs, validateProxyHealthCheck(*upstream.HealthCheck, idxPath.Child("healthCheck"))...)
+		}
+	}
+
+	if !availableLoadBalancings.Has(conf.LoadBalancing) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("loadBalancing"), conf.LoadBalancing, sets.List(availableLoadBalancings)))
+	}
+
+	if conf.DialTimeout != nil && conf.DialTimeout.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("dialTimeout"), conf.DialTimeout.Duration, "must be positive"))
+	}
+
+	if conf.HealthCheckInterval != nil && conf.HealthCheckInterval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("healthCheckInterval"), conf.HealthCheckInterval.Duration, "must be positive"))
+	}
+
+	if conf.UnhealthyThreshold != nil && *conf.UnhealthyThreshold <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("unhealthyThreshold"), *conf.UnhealthyThreshold, "must be positive"))
+	}
+
+	if conf.DrainTimeout != nil && conf.DrainTimeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("drainTimeout"), conf.DrainTimeout.Duration, "must not be negative"))
+	}
+
+	return allErrs
+}
+
+func validateShutdownConfiguration(conf configv1alpha1.ShutdownConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if conf.GracePeriod != nil && conf.GracePeriod.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("gracePeriod"), conf.GracePeriod.Duration, "must not be negative"))
+	}
+
+	if conf.Timeout != nil && conf.Timeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("timeout"), conf.Timeout.Duration, "must not be negative"))
+	}
+
+	if conf.GracePeriod != nil && conf.Timeout != nil && conf.Timeout.Duration > 0 && conf.GracePeriod.Duration > conf.Timeout.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("gracePeriod"), conf.GracePeriod.Duration, "must not exceed the timeout"))
+	}
+
+	return allErrs
+}
+
+func validateProxyProtocolConfiguration(conf configv1alpha1.ProxyProtocolConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !availableProxyProtocolVersions.Has(conf.Version) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("version"), conf.Version, sets.List(availableProxyProtocolVersions)))
+	}
+
+	if conf.Version != configv1alpha1.ProxyProtocolV2 {
+		if conf.Authority {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("authority"), "requires version "+configv1alpha1.ProxyProtocolV2))
+		}
+
+		if len(conf.TLVs) > 0 {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("tlvs"), "requires version "+configv1alpha1.ProxyProtocolV2))
+		}
+	}
+
+	for i, tlv := range conf.TLVs {
+		if tlv.Type < 0 || tlv.Type > 255 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("tlvs").Index(i).Child("type"), tlv.Type, "must be between 0 and 255"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateProxyHealthCheck(conf configv1alpha1.ProxyHealthCheck, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !availableHealthChecks.Has(conf.Mode) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("mode"), conf.Mode, sets.List(availableHealthChecks)))
+	}
+
+	if conf.TokenFile != "" && conf.Mode != configv1alpha1.HealthCheckHTTPS {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("tokenFile"), "requires mode "+configv1alpha1.HealthCheckHTTPS))
+	}
+
+	if conf.TokenFile != "" && conf.CAFile == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("caFile"), "is required to verify the upstream the token is sent to"))
+	}
+
+	if conf.CAFile != "" && conf.Mode == configv1alpha1.HealthCheckTCP {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("caFile"), "requires mode "+configv1alpha1.HealthCheckTLS+" or "+configv1alpha1.HealthCheckHTTPS))
+	}
+
+	return allErrs
+}
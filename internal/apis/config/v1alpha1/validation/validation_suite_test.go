@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package validation
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+
+	configv1alpha1 "github.com/gardener/apiserver-proxy/internal/apis/config/v1alpha1"
+)
+
+func TestValidation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config V1alpha1 Validation Suite")
+}
+
+var _ = Describe("ValidateApiserverProxySidecarConfiguration", func() {
+
+	var conf *configv1alpha1.ApiserverProxySidecarConfiguration
+
+	BeforeEach(func() {
+		conf = &configv1alpha1.ApiserverProxySidecarConfiguration{
+			IPAddresses: []string{"10.0.0.1", "fd00::1"},
+		}
+		configv1alpha1.SetObjectDefaults_ApiserverProxySidecarConfiguration(conf)
+	})
+
+	It("should allow a valid configuration", func() {
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(BeEmpty())
+	})
+
+	It("should allow a configuration without addresses", func() {
+		conf.IPAddresses = nil
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(BeEmpty())
+	})
+
+	It("should forbid invalid addresses and more than one address per family", func() {
+		conf.IPAddresses = []string{"10.0.0.1", "foo", "10.0.0.2"}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("ipAddresses[1]"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("ipAddresses[2]"),
+			})),
+		))
+	})
+
+	It("should allow an IP address source", func() {
+		conf.IPAddresses = nil
+		conf.IPAddressSource = &configv1alpha1.IPAddressSource{
+			Service: &configv1alpha1.ServiceReference{Namespace: "kube-system", Name: "apiserver-proxy"},
+		}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(BeEmpty())
+	})
+
+	It("should forbid an IP address source together with addresses", func() {
+		conf.IPAddressSource = &configv1alpha1.IPAddressSource{
+			NodeAnnotation: &configv1alpha1.NodeAnnotationReference{Key: "example.com/address"},
+		}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeForbidden),
+				"Field": Equal("ipAddressSource"),
+			})),
+		))
+	})
+
+	It("should require exactly one complete IP address source", func() {
+		conf.IPAddresses = nil
+		conf.IPAddressSource = &configv1alpha1.IPAddressSource{
+			Service:   &configv1alpha1.ServiceReference{Namespace: "kube-system", Name: "apiserver-proxy"},
+			ConfigMap: &configv1alpha1.ConfigMapKeyReference{Namespace: "kube-system", Name: "apiserver-proxy"},
+		}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeRequired),
+				"Field": Equal("ipAddressSource.configMap.key"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("ipAddressSource"),
+			})),
+		))
+	})
+
+	It("should forbid invalid values", func() {
+		conf.Port = ptr.To[int32](0)
+		conf.Interface = ""
+		conf.InterfaceMode = "veth"
+		conf.SyncInterval = &metav1.Duration{}
+		conf.Rules.Backend = "foo"
+		conf.Probe.Mode = "bar"
+		conf.Probe.Timeout = &metav1.Duration{Duration: -1}
+		conf.Duplicates.Policy = "ignore"
+		conf.Duplicates.Exclude = []string{"kube-ipvs0", "eth["}
+		conf.Conflicts.Window = &metav1.Duration{}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("port"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeRequired),
+				"Field": Equal("interface"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("interfaceMode"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("syncInterval"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("rules.backend"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("probe.mode"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("probe.timeout"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("duplicates.policy"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("duplicates.exclude[1]"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("conflicts.window"),
+			})),
+		))
+	})
+
+	It("should forbid the loopback interface mode with another interface", func() {
+		conf.Interface = "eth0"
+		conf.InterfaceMode = configv1alpha1.InterfaceModeLoopback
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("interfaceMode"),
+			})),
+		))
+	})
+
+	It("should allow proxy upstreams", func() {
+		conf.Proxy.Upstreams = []configv1alpha1.ProxyUpstream{
+			{Address: "10.0.0.10:443"},
+			{Address: "[fd00::10]:443", ServerNames: []string{"api.example.com"}},
+			{Address: "10.0.0.11:443", ProxyProtocol: &configv1alpha1.ProxyProtocolConfiguration{
+				Version:   configv1alpha1.ProxyProtocolV2,
+				Authority: true,
+				TLVs:      []configv1alpha1.ProxyProtocolTLV{{Type: 0xe0, Value: "node-1"}},
+			}},
+			{Address: "10.0.0.12:443", HealthCheck: &configv1alpha1.ProxyHealthCheck{
+				Mode:      configv1alpha1.HealthCheckHTTPS,
+				TokenFile: "/var/run/secrets/token",
+				CAFile:    "/var/run/secrets/ca.crt",
+			}},
+		}
+		conf.Proxy.LoadBalancing = configv1alpha1.LoadBalancingLeastConnections
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(BeEmpty())
+	})
+
+	It("should forbid invalid proxy values", func() {
+		conf.Proxy.Upstreams = []configv1alpha1.ProxyUpstream{
+			{Address: "10.0.0.10"},
+			{Address: "10.0.0.11:443", ServerNames: []string{""}},
+			{Address: "10.0.0.12:443", ProxyProtocol: &configv1alpha1.ProxyProtocolConfiguration{Version: "v3"}},
+			{Address: "10.0.0.13:443", ProxyProtocol: &configv1alpha1.ProxyProtocolConfiguration{
+				Version: configv1alpha1.ProxyProtocolV1,
+				TLVs:    []configv1alpha1.ProxyProtocolTLV{{Type: 256}},
+			}},
+			{Address: "10.0.0.14:443", HealthCheck: &configv1alpha1.ProxyHealthCheck{Mode: "http"}},
+			{Address: "10.0.0.15:443", HealthCheck: &configv1alpha1.ProxyHealthCheck{
+				Mode:      configv1alpha1.HealthCheckTCP,
+				TokenFile: "/var/run/secrets/token",
+				CAFile:    "/var/run/secrets/ca.crt",
+			}},
+			{Address: "10.0.0.16:443", HealthCheck: &configv1alpha1.ProxyHealthCheck{
+				Mode:      configv1alpha1.HealthCheckHTTPS,
+				TokenFile: "/var/run/secrets/token",
+			}},
+		}
+		conf.Proxy.LoadBalancing = "random"
+		conf.Proxy.UnhealthyThreshold = ptr.To[int32](0)
+		conf.Proxy.DialTimeout = &metav1.Duration{}
+		conf.Proxy.DrainTimeout = &metav1.Duration{Duration: -1}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("proxy.upstreams[0].address"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeRequired),
+				"Field": Equal("proxy.upstreams[1].serverNames[0]"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("proxy.upstreams[2].proxyProtocol.version"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeForbidden),
+				"Field": Equal("proxy.upstreams[3].proxyProtocol.tlvs"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("proxy.upstreams[3].proxyProtocol.tlvs[0].type"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("proxy.upstreams[4].healthCheck.mode"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeForbidden),
+				"Field": Equal("proxy.upstreams[5].healthCheck.tokenFile"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeForbidden),
+				"Field": Equal("proxy.upstreams[5].healthCheck.caFile"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeRequired),
+				"Field": Equal("proxy.upstreams[6].healthCheck.caFile"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeNotSupported),
+				"Field": Equal("proxy.loadBalancing"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("proxy.unhealthyThreshold"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("proxy.dialTimeout"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("proxy.drainTimeout"),
+			})),
+		))
+	})
+	It("should forbid invalid shutdown values", func() {
+		conf.Shutdown.GracePeriod = &metav1.Duration{Duration: -1}
+		conf.Shutdown.Timeout = &metav1.Duration{Duration: -1}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("shutdown.gracePeriod"),
+			})),
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":  Equal(field.ErrorTypeInvalid),
+				"Field": Equal("shutdown.timeout"),
+			})),
+		))
+	})
+
+	It("should forbid a grace period exceeding the shutdown timeout", func() {
+		conf.Shutdown.GracePeriod = &metav1.Duration{Duration: time.Minute}
+		conf.Shutdown.Timeout = &metav1.Duration{Duration: 25 * time.Second}
+
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(ConsistOf(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Type":     Equal(field.ErrorTypeInvalid),
+				"Field":    Equal("shutdown.gracePeriod"),
+				"BadValue": Equal(time.Minute),
+			})),
+		))
+
+		conf.Shutdown.Timeout = &metav1.Duration{}
+		Expect(ValidateApiserverProxySidecarConfiguration(conf)).To(BeEmpty())
+	})
+})
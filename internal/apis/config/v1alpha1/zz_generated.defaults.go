@@ -0,0 +1,32 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Code generated by defaulter-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// RegisterDefaults adds defaulters functions to the given scheme.
+// Public to allow building arbitrary schemes.
+// All generated defaulters are covering - they call all nested defaulters.
+func RegisterDefaults(scheme *runtime.Scheme) error {
+	scheme.AddTypeDefaultingFunc(&ApiserverProxySidecarConfiguration{}, func(obj interface{}) {
+		SetObjectDefaults_ApiserverProxySidecarConfiguration(obj.(*ApiserverProxySidecarConfiguration))
+	})
+	return nil
+}
+
+func SetObjectDefaults_ApiserverProxySidecarConfiguration(in *ApiserverProxySidecarConfiguration) {
+	SetDefaults_ApiserverProxySidecarConfiguration(in)
+	SetDefaults_RulesConfiguration(&in.Rules)
+	SetDefaults_ProbeConfiguration(&in.Probe)
+	SetDefaults_DuplicatesConfiguration(&in.Duplicates)
+	SetDefaults_ConflictsConfiguration(&in.Conflicts)
+	SetDefaults_ProxyConfiguration(&in.Proxy)
+}
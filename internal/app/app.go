@@ -5,96 +5,631 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/netip"
+	"strconv"
 	"time"
 
 	"github.com/vishvananda/netlink"
 	"golang.org/x/xerrors"
 	"k8s.io/klog/v2"
 
+	"github.com/gardener/apiserver-proxy/internal/handover"
+	"github.com/gardener/apiserver-proxy/internal/metrics"
 	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/netns"
+	"github.com/gardener/apiserver-proxy/internal/probe"
+	"github.com/gardener/apiserver-proxy/internal/proxy"
+	"github.com/gardener/apiserver-proxy/internal/rules"
+	"github.com/gardener/apiserver-proxy/internal/source"
+	"github.com/gardener/apiserver-proxy/internal/state"
+)
+
+const (
+	metricsEndpoint = "/metrics"
+
+	// connectionsPollInterval is the interval in which the connections are counted during the shutdown grace period.
+	connectionsPollInterval = time.Second
 )
 
 // NewSidecarApp returns a new instance of SidecarApp by applying the specified config params.
 func NewSidecarApp(params *ConfigParams) (*SidecarApp, error) {
-	c := &SidecarApp{params: params}
+	ns, err := netns.Open(params.NetNS)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newSidecarAppIn(params, ns)
+	if err != nil {
+		_ = ns.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newSidecarAppIn returns a new instance of SidecarApp managing the given network namespace, including the
+// clients for the cluster if they are needed.
+func newSidecarAppIn(params *ConfigParams, ns *netns.Namespace) (*SidecarApp, error) {
+	if ns != nil {
+		klog.Infof("Using network namespace %q", ns)
+	}
+
+	handle, err := netif.NewHandleIn(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing is reported in dry-run mode, as the changes are not applied
+	report := (params.NodeCondition || params.RecordEvents) && !params.DryRun
+
+	if params.IPAddressSource == "" && !report {
+		c, err := newSidecarApp(params, handle, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.proxy, err = newProxy(params, ns); err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	restConfig, kubeClient, err := newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// the given parameters are not modified, as they are reloaded with the resolved addresses
+	resolved := *params
+
+	var src *source.Source
+	if params.IPAddressSource != "" {
+		if src, err = newSource(kubeClient, params); err != nil {
+			return nil, err
+		}
+
+		if resolved.IPAddresses, err = initialAddresses(src, params.StateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := newSidecarApp(&resolved, handle, ns)
+	if err != nil {
+		return nil, err
+	}
+	c.source = src
+
+	if c.proxy, err = newProxy(params, ns); err != nil {
+		return nil, err
+	}
+
+	if report {
+		if c.reporter, c.stopEvents, err = newReporter(restConfig, kubeClient, params); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// newSidecarApp returns a new instance of SidecarApp managing the devices and addresses with the given handle
+// and the rules in the given network namespace, the current one if nil.
+func newSidecarApp(params *ConfigParams, handle netif.Handle, ns *netns.Namespace) (*SidecarApp, error) {
+	c := &SidecarApp{params: params, handle: handle, ns: ns, health: newHealthStatus(params.Interval)}
+	c.connections = func(ips []netip.Addr, port uint16) (int, error) {
+		return probe.Connections(ns, ips, port)
+	}
+
+	if c.params.DryRun {
+		c.dryRun = netif.NewDryRunHandle(handle)
+		c.handle = c.dryRun
+	}
+
+	if len(c.params.IPAddresses) == 0 {
+		return nil, xerrors.Errorf("at least one IP address is required")
+	}
+
+	var ips []netip.Addr
+	for _, ipAddress := range c.params.IPAddresses {
+		ip, err := netip.ParseAddr(ipAddress)
+		if err != nil {
+			return nil, xerrors.Errorf("unable to parse IP address %q - %v", ipAddress, err)
+		}
+
+		for _, other := range ips {
+			if other.Is4() == ip.Is4() {
+				return nil, xerrors.Errorf("only one IP address per IP family is allowed, got %q and %q", other, ip)
+			}
+		}
+
+		addr, err := netlink.ParseAddr(fmt.Sprintf("%s/%d", ip, ip.BitLen()))
+		if err != nil || addr == nil {
+			return nil, xerrors.Errorf("unable to parse IP address %q - %v", ipAddress, err)
+		}
+
+		ips = append(ips, ip)
+		c.localIPs = append(c.localIPs, addr)
 
-	ip, err := netip.ParseAddr(c.params.IPAddress)
+		klog.Infof("Using IP address %q", ipAddress)
+	}
+
+	port, err := strconv.ParseUint(c.params.LocalPort, 10, 16)
 	if err != nil {
-		return nil, xerrors.Errorf("unable to parse IP address %q - %v", c.params.IPAddress, err)
+		return nil, xerrors.Errorf("unable to parse port %q - %v", c.params.LocalPort, err)
+	}
+
+	c.ips = ips
+	c.port = uint16(port)
+
+	if c.params.StateFile != "" {
+		c.stateStore = state.NewStore(c.params.StateFile)
 	}
 
-	addr, err := netlink.ParseAddr(fmt.Sprintf("%s/%d", c.params.IPAddress, ip.BitLen()))
-	if err != nil || addr == nil {
-		return nil, xerrors.Errorf("unable to parse IP address %q - %v", c.params.IPAddress, err)
+	// the addresses are not handed over in dry-run mode, as they are not changed
+	if c.params.HandoverFile != "" && c.dryRun == nil {
+		c.handover = handover.NewLock(c.params.HandoverFile, c.params.InstanceID)
 	}
 
-	c.localIP = addr
+	if c.params.InterfaceMode == netif.InterfaceModeLoopback && c.params.Interface != "lo" {
+		return nil, xerrors.Errorf("interface mode %s requires the interface lo, got %q", netif.InterfaceModeLoopback, c.params.Interface)
+	}
 
-	klog.Infof("Using IP address %q", params.IPAddress)
+	opts := netif.Options{
+		Mode: c.params.InterfaceMode,
+		Duplicates: netif.Duplicates{
+			Policy:      c.params.DuplicatePolicy,
+			Include:     c.params.DuplicateInclude,
+			Exclude:     c.params.DuplicateExclude,
+			OnDuplicate: c.recordDuplicate,
+		},
+		Conflicts: netif.Conflicts{
+			Threshold:  c.params.ConflictThreshold,
+			Window:     c.params.ConflictWindow,
+			MaxBackoff: c.params.ConflictBackoff,
+		},
+		Conntrack: netif.Conntrack{
+			Flush: c.params.ConntrackFlush,
+			Port:  c.port,
+		},
+		Namespace: ns,
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	c.netManager = netif.NewNetifManagerWithHandle(c.handle, c.localIPs, c.params.Interface, opts)
+
+	if c.params.SetupIptables && c.dryRun != nil {
+		klog.Warningf("Rules are not part of the plan in dry-run mode, skipping them")
+	} else if c.params.SetupIptables {
+		c.rulesManager, err = rules.NewRulesManager(c.params.RulesBackend, ns, ips, uint16(port))
+		if err != nil {
+			return nil, err
+		}
+
+		klog.Infof("Setting up rules for port %d with %s", port, c.params.RulesBackend)
+	}
+
+	c.prober, err = probe.NewProber(c.params.ProbeMode, ns, ips, uint16(port), c.params.ProbeTimeout, recordProbe)
+	if err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
 
-// TeardownNetworking removes the network interface added by apiserver-proxy
+// TeardownNetworking removes the network interface and rules added by apiserver-proxy including
+// the stale ones recorded in the state file
 func (c *SidecarApp) TeardownNetworking() error {
 	klog.Infof("Cleaning up")
+
+	if c.rulesManager != nil {
+		if err := c.rulesManager.CleanupRules(); err != nil {
+			return err
+		}
+	}
+
 	err := c.netManager.RemoveIPAddress()
 	if err != nil {
 		return err
 	}
-	return c.netManager.CleanupDevice()
+
+	if err := c.netManager.CleanupDevice(); err != nil {
+		return err
+	}
+
+	for _, prev := range c.prevStates {
+		if err := c.removeStale(prev); err != nil {
+			return err
+		}
+	}
+	c.prevStates = nil
+
+	c.reportRemoved()
+
+	if c.stateStore == nil || c.dryRun != nil {
+		return nil
+	}
+
+	return c.stateStore.Remove()
 }
 
-func (c *SidecarApp) runPeriodic(ctx context.Context) {
+func (c *SidecarApp) runPeriodic(ctx context.Context, reloads <-chan struct{}) {
 	tick := time.NewTicker(c.params.Interval)
 	defer tick.Stop()
 
+	watchDone := make(chan struct{})
+	defer func() { close(watchDone) }()
+
+	events := c.watch(watchDone)
+	sourceEvents := c.watchSource(ctx)
+
+	reload := func() {
+		if !c.reload(ctx) {
+			return
+		}
+
+		// the interface or addresses may have changed, so the subscription of the replaced manager is closed
+		close(watchDone)
+		watchDone = make(chan struct{})
+		events = c.watch(watchDone)
+		tick.Reset(c.params.Interval)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			klog.Warningf("Exiting interface check goroutine")
 
 			return
+		case <-reloads:
+			reload()
+		case _, ok := <-sourceEvents:
+			if !ok {
+				klog.Warningf("Watch of address source closed, relying on periodic checks until rewatched")
+				sourceEvents = nil
+
+				continue
+			}
+
+			klog.V(2).Infoln("Address source changed")
+			reload()
 		case <-tick.C:
-			c.runChecks()
+			_ = c.runChecks(ctx)
+
+			if events == nil {
+				events = c.watch(watchDone)
+			}
+
+			if c.source != nil {
+				if sourceEvents == nil {
+					sourceEvents = c.watchSource(ctx)
+				}
+
+				// changes of the source may have been missed while it was not watched
+				reload()
+			}
+		case _, ok := <-events:
+			if !ok {
+				klog.Warningf("Netlink subscription closed, relying on periodic checks until resubscribed")
+				events = nil
+
+				continue
+			}
+
+			klog.V(2).Infoln("Interface or address changed")
+			_ = c.runChecks(ctx)
 		}
 	}
 }
 
-func (c *SidecarApp) runChecks() {
+// watch subscribes to interface and address changes. It returns nil if the subscription fails,
+// in which case only the periodic checks are run.
+func (c *SidecarApp) watch(done <-chan struct{}) <-chan struct{} {
+	events, err := c.netManager.Watch(done)
+	if err != nil {
+		klog.Warningf("Unable to watch interface changes, relying on periodic checks: %v", err)
+		return nil
+	}
+
+	return events
+}
+
+// runChecks ensures the ip addresses and rules and probes the proxy. It returns the errors
+// ensuring the ip addresses and rules, which are also logged.
+func (c *SidecarApp) runChecks(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds())
+	}()
 
 	klog.V(2).Infoln("Ensuring ip address")
 
-	if err := c.netManager.EnsureIPAddress(); err != nil {
+	err := c.netManager.EnsureIPAddress()
+	if netif.ReasonOf(err) == netif.ReasonDuplicate && len(c.prevStates) > 0 {
+		// the addresses are in place, but the duplicates may be the stale ones of a previous run or
+		// configuration, e.g. after moving them to another interface, which are removed before checking again
+		klog.Infof("Found duplicate addresses, removing stale resources before ensuring the ip address again: %v", err)
+		c.syncState()
+		err = c.netManager.EnsureIPAddress()
+	}
+
+	if err != nil {
 		klog.Errorf("Error ensuring ip address: %v", err)
+		metrics.EnsureIPAddressFailures.WithLabelValues(string(netif.ReasonOf(err))).Inc()
+	} else {
+		metrics.EnsureIPAddressSuccesses.Inc()
 	}
 
+	c.health.recordIPAddress(err)
+
 	klog.V(2).Infoln("Ensured ip address")
+
+	var rulesErr error
+	if c.rulesManager != nil {
+		klog.V(2).Infoln("Ensuring rules")
+
+		if rulesErr = c.rulesManager.EnsureRules(); rulesErr != nil {
+			klog.Errorf("Error ensuring rules: %v", rulesErr)
+		}
+
+		klog.V(2).Infoln("Ensured rules")
+	}
+
+	// stale resources are only removed once the current ones are in place
+	if err == nil {
+		c.syncState()
+	}
+
+	if c.proxy != nil {
+		// listening is retried by every check, e.g. if the port was in use
+		if proxyErr := c.proxy.Listen(c.proxyAddresses()); proxyErr != nil {
+			klog.Errorf("Error listening with embedded proxy: %v", proxyErr)
+		}
+	}
+
+	var proxyErr error
+	if c.prober != nil {
+		klog.V(2).Infoln("Probing proxy")
+
+		if proxyErr = c.prober.Probe(ctx); proxyErr != nil {
+			klog.Errorf("Error probing proxy: %v", proxyErr)
+		}
+
+		c.health.recordProxy(proxyErr)
+
+		klog.V(2).Infoln("Probed proxy")
+	}
+
+	c.report(ctx, err, proxyErr)
+
+	return errors.Join(err, rulesErr)
+}
+
+// recordProbe records the result of probing the proxy on a single address in the metrics.
+func recordProbe(address string, err error) {
+	if err != nil {
+		metrics.ProxyReachable.WithLabelValues(address).Set(0)
+		metrics.ProbeFailures.WithLabelValues(address).Inc()
+
+		return
+	}
+
+	metrics.ProxyReachable.WithLabelValues(address).Set(1)
+}
+
+// Setup takes over and ensures the ip addresses and rules once and returns an error if that failed.
+func (c *SidecarApp) Setup(ctx context.Context) error {
+	if err := c.requireInstanceID("setup"); err != nil {
+		return err
+	}
+
+	c.takeOver()
+	c.loadState()
+
+	return c.runChecks(ctx)
+}
+
+// Teardown removes the network interface and rules including the stale ones of previous runs, unless a newer instance
+// took over the ip addresses.
+func (c *SidecarApp) Teardown() error {
+	if err := c.requireInstanceID("teardown"); err != nil {
+		return err
+	}
+
+	c.loadState()
+	_, err := c.releaseNetworking()
+
+	return err
 }
 
 // RunApp invokes the background checks and runs coreDNS as a cache
 func (c *SidecarApp) RunApp(ctx context.Context) {
-	c.netManager = netif.NewNetifManager(c.localIP, c.params.Interface)
+	// the servers outlive ctx, so that the sidecar is reported not ready while shutting down
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
 
 	if c.params.Cleanup {
 		defer func() {
-			if err := c.TeardownNetworking(); err != nil {
+			handedOver, err := c.releaseNetworking()
+			if err != nil {
 				klog.Fatalf("Failed to clean up - %v", err)
 			}
 
-			klog.Infoln("Successfully cleaned up everything. Bye!")
+			if !handedOver {
+				klog.Infoln("Successfully cleaned up everything. Bye!")
+			}
 		}()
 	}
 
-	c.runChecks()
+	c.takeOver()
+	c.loadState()
+	_ = c.runChecks(ctx)
 
-	if c.params.Daemon {
+	if c.params.Daemon && c.dryRun != nil {
+		klog.Infoln("Not running as a daemon in dry-run mode")
+	} else if c.params.Daemon {
 		klog.Infoln("Running as a daemon")
+
+		if c.params.HealthBindAddress != "" {
+			go serve(serveCtx, "health", c.params.HealthBindAddress, c.newHealthHandler())
+		}
+
+		if c.params.MetricsBindAddress != "" {
+			mux := http.NewServeMux()
+			mux.Handle(metricsEndpoint, metrics.Handler())
+			go serve(serveCtx, "metrics", c.params.MetricsBindAddress, mux)
+		}
+
+		if c.proxy != nil {
+			go c.proxy.Run(ctx)
+		}
+
+		var reloads <-chan struct{}
+		if c.configFile != "" {
+			reloads = c.watchConfig(ctx)
+		}
+
 		// run periodic blocks
-		c.runPeriodic(ctx)
+		c.runPeriodic(ctx, reloads)
+
+		c.shutdown()
 	}
 
 	klog.Infoln("Exiting... Bye!")
 }
+
+// shutdown prepares the removal of the addresses once the daemon was stopped: the sidecar is reported not ready,
+// the connections to the addresses are given the grace period to finish and the embedded proxy is drained, all
+// within the shutdown timeout.
+func (c *SidecarApp) shutdown() {
+	ctx := context.Background()
+	if c.params.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.params.ShutdownTimeout)
+		defer cancel()
+	}
+
+	klog.Infoln("Shutting down")
+	c.health.markShuttingDown()
+
+	// the connections only need to finish if the addresses are removed afterwards
+	if c.params.Cleanup && c.params.ShutdownGracePeriod > 0 && !c.handedOver() {
+		c.awaitConnections(ctx)
+	}
+
+	if c.proxy != nil {
+		c.shutdownProxy(ctx)
+	}
+}
+
+// awaitConnections waits until there are no established connections to the port on the addresses anymore, at most
+// for the grace period. It waits for the whole grace period if the connections cannot be counted.
+func (c *SidecarApp) awaitConnections(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.params.ShutdownGracePeriod)
+	defer cancel()
+
+	klog.Infof("Waiting up to %v for the connections to %v to finish", c.params.ShutdownGracePeriod, c.proxyAddresses())
+
+	tick := time.NewTicker(connectionsPollInterval)
+	defer tick.Stop()
+
+	count := c.connections
+	for {
+		if count != nil {
+			n, err := count(c.ips, c.port)
+			switch {
+			case err != nil:
+				klog.Warningf("Unable to count the connections, waiting for the whole grace period: %v", err)
+				count = nil
+			case n == 0:
+				klog.Infoln("No connections left")
+				return
+			default:
+				klog.V(2).Infof("Waiting for %d connections to finish", n)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			klog.Infoln("Grace period is over")
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Plan returns the changes of the interfaces and addresses recorded in dry-run mode in the order they would
+// have been applied, or nil if not running in dry-run mode.
+func (c *SidecarApp) Plan() []netif.Action {
+	if c.dryRun == nil {
+		return nil
+	}
+
+	return c.dryRun.Actions()
+}
+
+// Close releases the resources of the sidecar, e.g. flushes the recorded events.
+func (c *SidecarApp) Close() {
+	if c.stopEvents != nil {
+		c.stopEvents()
+	}
+
+	if err := c.ns.Close(); err != nil {
+		klog.Warningf("Error closing network namespace: %v", err)
+	}
+}
+
+// newProxy returns the embedded proxy if upstreams are configured and the sidecar runs as a daemon, otherwise nil.
+func newProxy(params *ConfigParams, ns *netns.Namespace) (*proxy.Proxy, error) {
+	if len(params.ProxyUpstreams) == 0 || !params.Daemon || params.DryRun {
+		return nil, nil
+	}
+
+	cfg := proxy.Config{
+		LoadBalancing:       params.ProxyLoadBalancing,
+		DialTimeout:         params.ProxyDialTimeout,
+		HealthCheckInterval: params.ProxyHealthCheckInterval,
+		UnhealthyThreshold:  params.ProxyUnhealthyThreshold,
+	}
+	for _, s := range params.ProxyUpstreams {
+		u, err := proxy.ParseUpstream(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Upstreams = append(cfg.Upstreams, u)
+	}
+
+	klog.Infof("Using embedded proxy forwarding to %v", params.ProxyUpstreams)
+
+	return proxy.New(cfg, ns)
+}
+
+// proxyAddresses returns the addresses the embedded proxy listens on.
+func (c *SidecarApp) proxyAddresses() []string {
+	var addresses []string
+	for _, ip := range c.ips {
+		addresses = append(addresses, net.JoinHostPort(ip.String(), strconv.Itoa(int(c.port))))
+	}
+
+	return addresses
+}
+
+// shutdownProxy stops the embedded proxy listening and drains its connections, so that the address can be removed
+// afterwards without breaking them.
+func (c *SidecarApp) shutdownProxy(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.params.ProxyDrainTimeout)
+	defer cancel()
+
+	if err := c.proxy.Shutdown(ctx); err != nil {
+		klog.Warningf("Error draining connections of embedded proxy: %v", err)
+		return
+	}
+
+	klog.Infoln("Drained connections of embedded proxy")
+}
@@ -0,0 +1,1008 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/gardener/apiserver-proxy/internal/handover"
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/netif/fake"
+	"github.com/gardener/apiserver-proxy/internal/probe"
+	"github.com/gardener/apiserver-proxy/internal/report"
+	"github.com/gardener/apiserver-proxy/internal/rules"
+	"github.com/gardener/apiserver-proxy/internal/source"
+	"github.com/gardener/apiserver-proxy/internal/state"
+)
+
+func TestApp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "App Suite")
+}
+
+type proberFunc func(ctx context.Context) error
+
+func (f proberFunc) Probe(ctx context.Context) error {
+	return f(ctx)
+}
+
+// linkAddresses returns the addresses of the given link of the fake handle in CIDR notation.
+func linkAddresses(handle *fake.Handle, name string) []string {
+	var addrs []string
+	for _, addr := range handle.Addrs(name) {
+		addrs = append(addrs, addr.IPNet.String())
+	}
+	return addrs
+}
+
+var _ = Describe("Health endpoints", func() {
+
+	var (
+		c       *SidecarApp
+		now     time.Time
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		c = &SidecarApp{
+			params: &ConfigParams{Interval: time.Minute},
+			health: newHealthStatus(time.Minute),
+		}
+		c.health.now = func() time.Time { return now }
+		handler = c.newHealthHandler()
+	})
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	It("should not be ready before the first check", func() {
+		Expect(get("/readyz")).To(Equal(http.StatusInternalServerError))
+		Expect(get("/livez")).To(Equal(http.StatusOK))
+		Expect(get("/healthz")).To(Equal(http.StatusInternalServerError))
+	})
+
+	It("should be ready when ensuring the ip address succeeded", func() {
+		c.health.recordIPAddress(nil)
+
+		Expect(get("/readyz")).To(Equal(http.StatusOK))
+		Expect(get("/readyz/ip-address")).To(Equal(http.StatusOK))
+		Expect(get("/healthz")).To(Equal(http.StatusOK))
+	})
+
+	It("should not be ready when ensuring the ip address failed", func() {
+		c.health.recordIPAddress(nil)
+		c.health.recordIPAddress(fmt.Errorf("err"))
+
+		Expect(get("/readyz")).To(Equal(http.StatusInternalServerError))
+		Expect(get("/livez")).To(Equal(http.StatusOK))
+	})
+
+	Context("proxy is probed", func() {
+		BeforeEach(func() {
+			c.prober = proberFunc(func(context.Context) error { return nil })
+			handler = c.newHealthHandler()
+			c.health.recordIPAddress(nil)
+		})
+
+		It("should be ready when probing the proxy succeeded", func() {
+			c.health.recordProxy(nil)
+
+			Expect(get("/readyz")).To(Equal(http.StatusOK))
+			Expect(get("/readyz/proxy")).To(Equal(http.StatusOK))
+		})
+
+		It("should not be ready when probing the proxy failed", func() {
+			c.health.recordProxy(fmt.Errorf("err"))
+
+			Expect(get("/readyz")).To(Equal(http.StatusInternalServerError))
+			Expect(get("/readyz/ip-address")).To(Equal(http.StatusOK))
+		})
+	})
+
+	It("should not be ready when shutting down", func() {
+		c.health.recordIPAddress(nil)
+		c.health.markShuttingDown()
+
+		Expect(get("/readyz")).To(Equal(http.StatusInternalServerError))
+		Expect(get("/readyz/shutdown")).To(Equal(http.StatusInternalServerError))
+		Expect(get("/livez")).To(Equal(http.StatusOK))
+	})
+
+	It("should not be live when the periodic loop stopped", func() {
+		c.health.recordIPAddress(nil)
+		now = now.Add(livenessIntervals*time.Minute + time.Second)
+
+		Expect(get("/livez")).To(Equal(http.StatusInternalServerError))
+		Expect(get("/readyz")).To(Equal(http.StatusOK))
+		Expect(get("/healthz")).To(Equal(http.StatusInternalServerError))
+	})
+})
+
+var _ = Describe("staleState", func() {
+
+	var prev, cur *state.State
+
+	BeforeEach(func() {
+		prev = &state.State{
+			Interface:    "foo",
+			Addresses:    []string{"192.168.0.3/32", "fd00::3/128"},
+			CreatedLinks: []string{"foo"},
+			Rules:        &state.Rules{Backend: rules.BackendIPTables, Addresses: []string{"192.168.0.3", "fd00::3"}, Port: 443},
+		}
+		cur = &state.State{
+			Interface:    "foo",
+			Addresses:    []string{"192.168.0.3/32", "fd00::3/128"},
+			CreatedLinks: []string{"foo"},
+			Rules:        &state.Rules{Backend: rules.BackendIPTables, Addresses: []string{"192.168.0.3", "fd00::3"}, Port: 443},
+		}
+	})
+
+	It("should return nothing if nothing changed", func() {
+		Expect(staleState(prev, cur)).To(Equal(&state.State{Interface: "foo"}))
+	})
+
+	It("should return the removed addresses", func() {
+		cur.Addresses = []string{"192.168.0.4/32", "fd00::3/128"}
+		cur.Rules.Addresses = []string{"192.168.0.4", "fd00::3"}
+
+		stale := staleState(prev, cur)
+		Expect(stale.Addresses).To(Equal([]string{"192.168.0.3/32"}))
+		Expect(stale.CreatedLinks).To(BeEmpty())
+		Expect(stale.Rules).To(Equal(&state.Rules{Backend: rules.BackendIPTables, Addresses: []string{"192.168.0.3"}, Port: 443}))
+	})
+
+	It("should return all addresses and the created link if the interface changed", func() {
+		cur.Interface = "bar"
+		cur.CreatedLinks = []string{"bar"}
+
+		stale := staleState(prev, cur)
+		Expect(stale.Interface).To(Equal("foo"))
+		Expect(stale.Addresses).To(Equal(prev.Addresses))
+		Expect(stale.CreatedLinks).To(Equal([]string{"foo"}))
+		Expect(stale.Rules).To(BeNil())
+	})
+
+	It("should return all rules if the backend changed", func() {
+		cur.Rules.Backend = rules.BackendNFTables
+
+		Expect(staleState(prev, cur).Rules).To(Equal(prev.Rules))
+	})
+
+	It("should return all rules if the port changed", func() {
+		cur.Rules.Port = 9443
+
+		Expect(staleState(prev, cur).Rules).To(Equal(prev.Rules))
+	})
+
+	It("should return all rules if rules are not managed anymore", func() {
+		cur.Rules = nil
+
+		Expect(staleState(prev, cur).Rules).To(Equal(prev.Rules))
+	})
+
+	It("should not return nftables rules which are replaced anyway", func() {
+		prev.Rules.Backend = rules.BackendNFTables
+		cur.Rules = &state.Rules{Backend: rules.BackendNFTables, Addresses: []string{"192.168.0.4"}, Port: 9443}
+
+		Expect(staleState(prev, cur).Rules).To(BeNil())
+	})
+})
+
+var _ = Describe("Reload", func() {
+
+	var (
+		ctx      context.Context
+		handle   *fake.Handle
+		c        *SidecarApp
+		params   *ConfigParams
+		loadErr  error
+		updates  chan netlink.AddrUpdate
+		stopSubs chan struct{}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		handle = fake.NewHandle()
+		loadErr = nil
+
+		var err error
+		c, err = newSidecarApp(&ConfigParams{
+			IPAddresses: []string{"192.168.0.3"},
+			LocalPort:   "443",
+			Interface:   "foo",
+			Interval:    time.Minute,
+			ProbeMode:   probe.ModeNone,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+		c.EnableReload("config.yaml", func() (*ConfigParams, error) {
+			return params, loadErr
+		})
+		Expect(c.runChecks(ctx)).To(Succeed())
+
+		p := *c.params
+		params = &p
+
+		updates = make(chan netlink.AddrUpdate, 64)
+		stopSubs = make(chan struct{})
+		Expect(handle.AddrSubscribe(updates, stopSubs)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		close(stopSubs)
+	})
+
+	received := func() []string {
+		var events []string
+		for {
+			select {
+			case u := <-updates:
+				op := "del"
+				if u.NewAddr {
+					op = "add"
+				}
+				events = append(events, op+" "+u.LinkAddress.String())
+			default:
+				return events
+			}
+		}
+	}
+
+	It("should not replace the configuration if it did not change", func() {
+		Expect(c.reload(ctx)).To(BeFalse())
+		Expect(received()).To(BeEmpty())
+	})
+
+	It("should keep the configuration if it cannot be loaded", func() {
+		params.IPAddresses = []string{"192.168.0.4"}
+		loadErr = fmt.Errorf("invalid")
+
+		Expect(c.reload(ctx)).To(BeFalse())
+		Expect(c.params.IPAddresses).To(Equal([]string{"192.168.0.3"}))
+	})
+
+	It("should add the new address before removing the old one", func() {
+		params.IPAddresses = []string{"192.168.0.4"}
+
+		Expect(c.reload(ctx)).To(BeTrue())
+
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.4/32"))
+		Expect(received()).To(Equal([]string{"add 192.168.0.4/32", "del 192.168.0.3/32"}))
+		Expect(c.prevStates).To(BeEmpty())
+	})
+
+	It("should move the address to a renamed interface", func() {
+		params.Interface = "bar"
+
+		Expect(c.reload(ctx)).To(BeTrue())
+
+		Expect(handle.Link("foo")).To(BeNil())
+		Expect(linkAddresses(handle, "bar")).To(ConsistOf("192.168.0.3/32"))
+		Expect(received()).To(Equal([]string{"add 192.168.0.3/32", "del 192.168.0.3/32"}))
+	})
+
+	It("should move the address to a renamed interface with the duplicate policy fail", func() {
+		params.Interface = "bar"
+		params.DuplicatePolicy = netif.DuplicatePolicyFail
+
+		Expect(c.reload(ctx)).To(BeTrue())
+
+		Expect(handle.Link("foo")).To(BeNil())
+		Expect(linkAddresses(handle, "bar")).To(ConsistOf("192.168.0.3/32"))
+		Expect(c.prevStates).To(BeEmpty())
+		Expect(c.runChecks(ctx)).To(Succeed())
+	})
+
+	It("should keep the old address until the new one was added", func() {
+		params.IPAddresses = []string{"192.168.0.4"}
+		handle.InjectError(fake.OpAddrAdd, syscall.EPERM, 1)
+
+		Expect(c.reload(ctx)).To(BeTrue())
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+
+		Expect(c.runChecks(ctx)).To(Succeed())
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.4/32"))
+	})
+
+	It("should keep the parameters which are only evaluated on startup", func() {
+		params.HealthBindAddress = ":8080"
+		params.Daemon = true
+		params.Interval = time.Second
+
+		Expect(c.reload(ctx)).To(BeTrue())
+		Expect(c.params.HealthBindAddress).To(BeEmpty())
+		Expect(c.params.Daemon).To(BeFalse())
+		Expect(c.health.interval).To(Equal(time.Second))
+	})
+})
+
+var _ = Describe("Address source", func() {
+
+	var (
+		ctx         context.Context
+		handle      *fake.Handle
+		cm          *corev1.ConfigMap
+		ref         source.Reference
+		unreachable bool
+		cl          client.WithWatch
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		handle = fake.NewHandle()
+		unreachable = false
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "apiserver-proxy"},
+			Data:       map[string]string{"address": "192.168.0.3"},
+		}
+		ref = source.Reference{Kind: source.KindConfigMap, Namespace: "kube-system", Name: "apiserver-proxy", Key: "address"}
+		cl = fakeclient.NewClientBuilder().WithObjects(cm).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if unreachable {
+					return fmt.Errorf("connection refused")
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).Build()
+	})
+
+	Describe("reload", func() {
+
+		var c *SidecarApp
+
+		BeforeEach(func() {
+			var err error
+			c, err = newSidecarApp(&ConfigParams{
+				IPAddresses:     []string{"192.168.0.3"},
+				IPAddressSource: ref.String(),
+				LocalPort:       "443",
+				Interface:       "foo",
+				Interval:        time.Minute,
+				ProbeMode:       probe.ModeNone,
+			}, handle, nil)
+			Expect(err).ToNot(HaveOccurred())
+			c.source = source.NewSource(cl, ref)
+			Expect(c.runChecks(ctx)).To(Succeed())
+		})
+
+		It("should not replace the configuration if the source did not change", func() {
+			Expect(c.reload(ctx)).To(BeFalse())
+		})
+
+		It("should move to the changed address of the source", func() {
+			cm.Data["address"] = "192.168.0.4"
+			Expect(cl.Update(ctx, cm)).To(Succeed())
+
+			Expect(c.reload(ctx)).To(BeTrue())
+			Expect(c.params.IPAddresses).To(Equal([]string{"192.168.0.4"}))
+			Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.4/32"))
+		})
+
+		It("should keep the last-known address if the source is unreachable", func() {
+			unreachable = true
+
+			Expect(c.reload(ctx)).To(BeFalse())
+			Expect(c.params.IPAddresses).To(Equal([]string{"192.168.0.3"}))
+			Expect(handle.Addrs("foo")).To(HaveLen(1))
+		})
+
+		It("should keep the current address if the source contains an invalid one", func() {
+			cm.Data["address"] = "foo"
+			Expect(cl.Update(ctx, cm)).To(Succeed())
+
+			Expect(c.reload(ctx)).To(BeFalse())
+			Expect(c.params.IPAddresses).To(Equal([]string{"192.168.0.3"}))
+		})
+	})
+
+	Describe("initialAddresses", func() {
+
+		var (
+			dir  string
+			path string
+			src  *source.Source
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "state")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "state.json")
+			src = source.NewSource(cl, ref)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("should return the addresses of the source", func() {
+			Expect(initialAddresses(src, path)).To(Equal([]string{"192.168.0.3"}))
+		})
+
+		It("should fall back to the last-known addresses if the source is unreachable", func() {
+			unreachable = true
+			Expect(state.NewStore(path).Save(&state.State{Interface: "foo", Addresses: []string{"192.168.0.2/32", "fd00::2/128"}})).To(Succeed())
+
+			Expect(initialAddresses(src, path)).To(Equal([]string{"192.168.0.2", "fd00::2"}))
+		})
+
+		It("should fail if the source is unreachable and no addresses are recorded", func() {
+			unreachable = true
+
+			_, err := initialAddresses(src, path)
+			Expect(err).To(MatchError(ContainSubstring("no last-known addresses")))
+		})
+	})
+})
+
+var _ = Describe("Reporting", func() {
+
+	var (
+		ctx      context.Context
+		handle   *fake.Handle
+		cl       client.Client
+		recorder *record.FakeRecorder
+		c        *SidecarApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		handle = fake.NewHandle()
+		cl = fakeclient.NewClientBuilder().WithObjects(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}).Build()
+		recorder = record.NewFakeRecorder(10)
+
+		var err error
+		c, err = newSidecarApp(&ConfigParams{
+			IPAddresses: []string{"192.168.0.3"},
+			LocalPort:   "443",
+			Interface:   "foo",
+			Interval:    time.Minute,
+			ProbeMode:   probe.ModeNone,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+		c.reporter = report.NewNodeReporter(cl, recorder, "node", true)
+	})
+
+	condition := func() *corev1.NodeCondition {
+		node := &corev1.Node{}
+		Expect(cl.Get(ctx, client.ObjectKey{Name: "node"}, node)).To(Succeed())
+		for i := range node.Status.Conditions {
+			if node.Status.Conditions[i].Type == report.ConditionType {
+				return &node.Status.Conditions[i]
+			}
+		}
+		return nil
+	}
+
+	It("should report the address as ready after ensuring it", func() {
+		Expect(c.runChecks(ctx)).To(Succeed())
+
+		Expect(condition()).To(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Status": Equal(corev1.ConditionTrue),
+			"Reason": Equal(report.ReasonAddressReady),
+		})))
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal " + report.ReasonAddressReady)))
+	})
+
+	It("should report the address as not ready if ensuring it failed", func() {
+		handle.InjectError(fake.OpAddrAdd, syscall.EPERM, -1)
+
+		Expect(c.runChecks(ctx)).ToNot(Succeed())
+
+		Expect(condition()).To(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Status":  Equal(corev1.ConditionFalse),
+			"Reason":  Equal(report.ReasonAddressNotReady),
+			"Message": ContainSubstring("operation not permitted"),
+		})))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning " + report.ReasonAddressNotReady)))
+	})
+
+	It("should report the proxy as not reachable if probing it failed", func() {
+		c.prober = proberFunc(func(context.Context) error { return fmt.Errorf("connection refused") })
+
+		Expect(c.runChecks(ctx)).To(Succeed())
+
+		Expect(condition().Reason).To(Equal(report.ReasonProxyNotReachable))
+	})
+
+	It("should report a conflict with another agent", func() {
+		var err error
+		c, err = newSidecarApp(&ConfigParams{
+			IPAddresses:       []string{"192.168.0.3"},
+			LocalPort:         "443",
+			Interface:         "foo",
+			Interval:          time.Minute,
+			ProbeMode:         probe.ModeNone,
+			ConflictThreshold: 1,
+			ConflictWindow:    time.Minute,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+		c.reporter = report.NewNodeReporter(cl, recorder, "node", true)
+
+		Expect(c.runChecks(ctx)).To(Succeed())
+		Expect(condition().Reason).To(Equal(report.ReasonAddressReady))
+
+		addr, _ := netlink.ParseAddr("192.168.0.3/32")
+		Expect(handle.AddrDel(handle.Link("foo"), addr)).To(Succeed())
+		Expect(c.runChecks(ctx)).To(Succeed())
+
+		Expect(condition()).To(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Status":  Equal(corev1.ConditionFalse),
+			"Reason":  Equal(report.ReasonAddressConflict),
+			"Message": ContainSubstring("repaired repeatedly"),
+		})))
+
+		// further repairs do not change the condition
+		Expect(handle.AddrDel(handle.Link("foo"), addr)).To(Succeed())
+		Expect(c.runChecks(ctx)).To(Succeed())
+		Expect(recorder.Events).To(HaveLen(2))
+	})
+
+	It("should record an event for a removed duplicate", func() {
+		dup, _ := netlink.ParseAddr("192.168.0.3/32")
+		handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}, *dup)
+
+		Expect(c.runChecks(ctx)).To(Succeed())
+
+		Expect(recorder.Events).To(Receive(Equal("Warning " + report.ReasonDuplicateAddressRemoved +
+			" Removed duplicate of IP address 192.168.0.3/32 from interface eth0")))
+	})
+
+	It("should report the address as removed on teardown", func() {
+		Expect(c.runChecks(ctx)).To(Succeed())
+		Expect(c.TeardownNetworking()).To(Succeed())
+
+		Expect(condition()).To(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Status": Equal(corev1.ConditionFalse),
+			"Reason": Equal(report.ReasonAddressRemoved),
+		})))
+	})
+})
+
+var _ = Describe("Dry run", func() {
+
+	var (
+		ctx       context.Context
+		handle    *fake.Handle
+		c         *SidecarApp
+		dir       string
+		stateFile string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		handle = fake.NewHandle()
+
+		var err error
+		dir, err = os.MkdirTemp("", "state")
+		Expect(err).ToNot(HaveOccurred())
+		stateFile = filepath.Join(dir, "state.json")
+
+		c, err = newSidecarApp(&ConfigParams{
+			IPAddresses:   []string{"192.168.0.3"},
+			LocalPort:     "443",
+			Interface:     "foo",
+			Interval:      time.Minute,
+			ProbeMode:     probe.ModeNone,
+			SetupIptables: true,
+			RulesBackend:  rules.BackendIPTables,
+			StateFile:     stateFile,
+			DryRun:        true,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should record the changes of a setup without applying them", func() {
+		Expect(c.Setup(ctx)).To(Succeed())
+
+		Expect(c.Plan()).To(Equal([]netif.Action{
+			{Op: netif.ActionLinkAdd, Interface: "foo", Type: "dummy"},
+			{Op: netif.ActionLinkSetUp, Interface: "foo"},
+			{Op: netif.ActionAddrAdd, Interface: "foo", Address: "192.168.0.3/32"},
+		}))
+		Expect(handle.Link("foo")).To(BeNil())
+		Expect(c.rulesManager).To(BeNil())
+		Expect(stateFile).ToNot(BeAnExistingFile())
+	})
+
+	It("should record the removal of stale addresses without forgetting them", func() {
+		prev := &state.State{Interface: "foo", Addresses: []string{"192.168.0.2/32"}, CreatedLinks: []string{"foo"}}
+		Expect(state.NewStore(stateFile).Save(prev)).To(Succeed())
+		stale, _ := netlink.ParseAddr("192.168.0.2/32")
+		handle.AddLink(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "foo", Alias: netif.LinkAlias, Flags: net.FlagUp}}, *stale)
+
+		Expect(c.Setup(ctx)).To(Succeed())
+
+		Expect(c.Plan()).To(Equal([]netif.Action{
+			{Op: netif.ActionAddrAdd, Interface: "foo", Address: "192.168.0.3/32"},
+			{Op: netif.ActionAddrDel, Interface: "foo", Address: "192.168.0.2/32"},
+		}))
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.2/32"))
+		Expect(state.NewStore(stateFile).Load()).To(Equal(prev))
+	})
+
+	It("should record the changes of a teardown without applying them", func() {
+		applied, err := newSidecarApp(&ConfigParams{
+			IPAddresses: []string{"192.168.0.3"},
+			LocalPort:   "443",
+			Interface:   "foo",
+			ProbeMode:   probe.ModeNone,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(applied.Setup(ctx)).To(Succeed())
+
+		Expect(c.Teardown()).To(Succeed())
+
+		Expect(c.Plan()).To(Equal([]netif.Action{
+			{Op: netif.ActionAddrDel, Interface: "foo", Address: "192.168.0.3/32"},
+			{Op: netif.ActionLinkDel, Interface: "foo"},
+		}))
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+	})
+})
+
+var _ = Describe("Interface mode", func() {
+
+	It("should require the interface lo for the loopback mode", func() {
+		_, err := newSidecarApp(&ConfigParams{
+			IPAddresses:   []string{"192.168.0.3"},
+			LocalPort:     "443",
+			Interface:     "foo",
+			InterfaceMode: netif.InterfaceModeLoopback,
+			ProbeMode:     probe.ModeNone,
+		}, fake.NewHandle(), nil)
+
+		Expect(err).To(MatchError(ContainSubstring("requires the interface lo")))
+	})
+
+	It("should not create a missing interface in the existing mode", func() {
+		handle := fake.NewHandle()
+		c, err := newSidecarApp(&ConfigParams{
+			IPAddresses:   []string{"192.168.0.3"},
+			LocalPort:     "443",
+			Interface:     "foo",
+			InterfaceMode: netif.InterfaceModeExisting,
+			ProbeMode:     probe.ModeNone,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(c.Setup(context.Background())).To(MatchError(ContainSubstring("must exist")))
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+})
+
+var _ = Describe("Shutdown", func() {
+
+	var (
+		handle *fake.Handle
+		c      *SidecarApp
+		// ctx is cancelled, so that the daemon shuts down right after the initial check
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		var err error
+		handle = fake.NewHandle()
+		c, err = newSidecarApp(&ConfigParams{
+			IPAddresses:         []string{"192.168.0.3"},
+			LocalPort:           "443",
+			Interface:           "foo",
+			Interval:            time.Minute,
+			ProbeMode:           probe.ModeNone,
+			Daemon:              true,
+			Cleanup:             true,
+			ShutdownGracePeriod: time.Minute,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+	})
+
+	It("should remove the address once the connections finished while not being ready", func() {
+		remaining := []int{2, 1, 0}
+		c.connections = func(ips []netip.Addr, port uint16) (int, error) {
+			Expect(ips).To(ConsistOf(netip.MustParseAddr("192.168.0.3")))
+			Expect(port).To(Equal(uint16(443)))
+			Expect(c.health.shutdownChecker(nil)).To(MatchError(errShuttingDown))
+			Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+
+			n := remaining[0]
+			remaining = remaining[1:]
+			return n, nil
+		}
+
+		start := time.Now()
+		c.RunApp(ctx)
+
+		Expect(remaining).To(BeEmpty())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Minute))
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+
+	It("should not wait longer than the shutdown timeout", func() {
+		c.params.ShutdownTimeout = 100 * time.Millisecond
+		c.connections = func([]netip.Addr, uint16) (int, error) { return 1, nil }
+
+		start := time.Now()
+		c.RunApp(ctx)
+
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+
+	It("should wait for the whole grace period if the connections cannot be counted", func() {
+		c.params.ShutdownGracePeriod = 100 * time.Millisecond
+		calls := 0
+		c.connections = func([]netip.Addr, uint16) (int, error) {
+			calls++
+			return 0, fmt.Errorf("err")
+		}
+
+		start := time.Now()
+		c.RunApp(ctx)
+
+		Expect(calls).To(Equal(1))
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+
+	It("should not wait for the connections if the address is kept", func() {
+		c.params.Cleanup = false
+		c.connections = func([]netip.Addr, uint16) (int, error) {
+			Fail("connections counted without cleanup")
+			return 0, nil
+		}
+
+		c.RunApp(ctx)
+
+		Expect(c.health.shutdownChecker(nil)).To(MatchError(errShuttingDown))
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+	})
+})
+
+var _ = Describe("Handover", func() {
+
+	var (
+		dir    string
+		handle *fake.Handle
+		// oldApp and newApp are the instances of the old and the new pod during a rolling update
+		oldApp *SidecarApp
+		newApp *SidecarApp
+	)
+
+	newInstance := func(id string) *SidecarApp {
+		c, err := newSidecarApp(&ConfigParams{
+			IPAddresses:  []string{"192.168.0.3"},
+			LocalPort:    "443",
+			Interface:    "foo",
+			Interval:     time.Minute,
+			ProbeMode:    probe.ModeNone,
+			Daemon:       true,
+			Cleanup:      true,
+			HandoverFile: filepath.Join(dir, "owner.lock"),
+			InstanceID:   id,
+		}, handle, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		return c
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "handover")
+		Expect(err).ToNot(HaveOccurred())
+		handle = fake.NewHandle()
+
+		oldApp, newApp = newInstance("old-pod"), newInstance("new-pod")
+		oldApp.takeOver()
+		Expect(oldApp.runChecks(context.Background())).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should not remove the address taken over by a newer instance", func() {
+		newApp.takeOver()
+		Expect(newApp.runChecks(context.Background())).To(Succeed())
+
+		Expect(oldApp.handedOver()).To(BeTrue())
+		Expect(oldApp.releaseNetworking()).To(BeTrue())
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+
+		Expect(newApp.handedOver()).To(BeFalse())
+		Expect(newApp.releaseNetworking()).To(BeFalse())
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+
+	It("should remove the address if no newer instance took it over", func() {
+		Expect(oldApp.handedOver()).To(BeFalse())
+		Expect(oldApp.releaseNetworking()).To(BeFalse())
+		Expect(handle.Link("foo")).To(BeNil())
+
+		// the next instance starts from scratch
+		newApp.takeOver()
+		Expect(newApp.runChecks(context.Background())).To(Succeed())
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+	})
+
+	It("should remove the address if the lock file cannot be used", func() {
+		// the lock file cannot be created below a regular file, like on a read-only file system
+		Expect(os.WriteFile(filepath.Join(dir, "file"), nil, 0o600)).To(Succeed())
+		oldApp.handover = handover.NewLock(filepath.Join(dir, "file", "owner.lock"), "old-pod")
+
+		Expect(oldApp.releaseNetworking()).To(BeFalse())
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+
+	It("should not remove the address taken over by the setup of a newer pod", func() {
+		takenOver := testutil.ToFloat64(metrics.Handovers.WithLabelValues(handoverTakenOver))
+
+		setup := newInstance("new-pod")
+		setup.params.Daemon = false
+		Expect(setup.Setup(context.Background())).To(Succeed())
+		Expect(testutil.ToFloat64(metrics.Handovers.WithLabelValues(handoverTakenOver))).To(Equal(takenOver + 1))
+
+		Expect(oldApp.releaseNetworking()).To(BeTrue())
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+
+		// the daemon of the same pod already owns the addresses
+		newApp.takeOver()
+		Expect(testutil.ToFloat64(metrics.Handovers.WithLabelValues(handoverTakenOver))).To(Equal(takenOver + 1))
+		Expect(newApp.handedOver()).To(BeFalse())
+	})
+
+	It("should not remove the address taken over by a newer pod on teardown", func() {
+		newApp.takeOver()
+
+		teardown := newInstance("old-pod")
+		teardown.params.Daemon = false
+		Expect(teardown.Teardown()).To(Succeed())
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+
+		teardown = newInstance("new-pod")
+		teardown.params.Daemon = false
+		Expect(teardown.Teardown()).To(Succeed())
+		Expect(handle.Link("foo")).To(BeNil())
+	})
+
+	It("should require an instance ID for setup and teardown", func() {
+		c := newInstance("")
+		c.params.Daemon = false
+
+		Expect(c.Setup(context.Background())).To(MatchError(ContainSubstring("requires an instance ID")))
+		Expect(c.Teardown()).To(MatchError(ContainSubstring("requires an instance ID")))
+		Expect(linkAddresses(handle, "foo")).To(ConsistOf("192.168.0.3/32"))
+	})
+
+	It("should not wait for the connections to an address taken over by a newer instance", func() {
+		newApp.takeOver()
+		oldApp.params.ShutdownGracePeriod = time.Minute
+		oldApp.connections = func([]netip.Addr, uint16) (int, error) {
+			Fail("connections counted after the handover")
+			return 0, nil
+		}
+
+		oldApp.shutdown()
+
+		Expect(oldApp.health.shutdownChecker(nil)).To(MatchError(errShuttingDown))
+	})
+})
+
+var _ = Describe("Embedded proxy", func() {
+
+	var (
+		upstream net.Listener
+		params   *ConfigParams
+	)
+
+	BeforeEach(func() {
+		var err error
+		upstream, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			for {
+				conn, err := upstream.Accept()
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte("hello\n"))
+				_ = conn.Close()
+			}
+		}()
+
+		// a free port for the proxy
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		Expect(l.Close()).To(Succeed())
+
+		params = &ConfigParams{
+			IPAddresses:              []string{"127.0.0.1"},
+			LocalPort:                port,
+			Interface:                "foo",
+			Interval:                 time.Minute,
+			ProbeMode:                probe.ModeNone,
+			Daemon:                   true,
+			ProxyUpstreams:           []string{upstream.Addr().String()},
+			ProxyDialTimeout:         time.Second,
+			ProxyHealthCheckInterval: time.Minute,
+			ProxyUnhealthyThreshold:  1,
+			ProxyDrainTimeout:        time.Second,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(upstream.Close()).To(Succeed())
+	})
+
+	It("should not be created without upstreams or outside of daemon mode", func() {
+		p, err := newProxy(&ConfigParams{Daemon: true}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(BeNil())
+
+		params.Daemon = false
+		p, err = newProxy(params, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(BeNil())
+	})
+
+	It("should fail for invalid upstreams", func() {
+		params.ProxyUpstreams = []string{"10.0.0.1"}
+
+		_, err := newProxy(params, nil)
+		Expect(err).To(MatchError(ContainSubstring("invalid upstream")))
+	})
+
+	It("should forward the connections to the address and port to the upstreams", func() {
+		c, err := newSidecarApp(params, fake.NewHandle(), nil)
+		Expect(err).ToNot(HaveOccurred())
+		c.proxy, err = newProxy(params, nil)
+		Expect(err).ToNot(HaveOccurred())
+		defer c.shutdownProxy(context.Background())
+
+		Expect(c.runChecks(context.Background())).To(Succeed())
+
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", params.LocalPort))
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		Expect(bufio.NewReader(conn).ReadString('\n')).To(Equal("hello\n"))
+	})
+})
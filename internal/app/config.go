@@ -4,34 +4,129 @@
 package app
 
 import (
+	"net/netip"
 	"time"
 
 	"github.com/vishvananda/netlink"
 
+	"github.com/gardener/apiserver-proxy/internal/handover"
 	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/netns"
+	"github.com/gardener/apiserver-proxy/internal/probe"
+	"github.com/gardener/apiserver-proxy/internal/proxy"
+	"github.com/gardener/apiserver-proxy/internal/report"
+	"github.com/gardener/apiserver-proxy/internal/rules"
+	"github.com/gardener/apiserver-proxy/internal/source"
+	"github.com/gardener/apiserver-proxy/internal/state"
 )
 
 // ConfigParams lists the configuration options that can be provided to sidecar proxy
 type ConfigParams struct {
-	// LocalPort specifies the port to listen for DNS requests
+	// LocalPort specifies the port on which the proxy is listening
 	LocalPort string
 	// Interface specifies the name of the interface to be created
 	Interface string
+	// InterfaceMode specifies how the interface is provided (create-dummy, existing or loopback), derived from
+	// its name if empty
+	InterfaceMode string
 	// Interval specifies how often to run iptables rules check
 	Interval time.Duration
 	// SetupIptables enables iptables setup
 	SetupIptables bool
+	// RulesBackend specifies whether the rules are set up with iptables or nftables
+	RulesBackend string
 	// Cleanup specifies whether to clean the created interface and iptables
 	Cleanup bool
 	// Daemon specifies whether to run as daemon
 	Daemon bool
-	// IPAddress specifies the IP address on which the proxy is listening
-	IPAddress string
+	// HealthBindAddress specifies the address on which the health endpoints are served, disabled if empty
+	HealthBindAddress string
+	// ProbeMode specifies how the proxy is probed (none, tcp or tls)
+	ProbeMode string
+	// ProbeTimeout specifies the timeout for probing the proxy
+	ProbeTimeout time.Duration
+	// StateFile specifies the path of the file recording the managed resources, disabled if empty
+	StateFile string
+	// HandoverFile specifies the path of the lock file recording the instance owning the addresses, disabled if empty
+	HandoverFile string
+	// InstanceID specifies the ID of the instance in the handover file, e.g. the UID of the pod, random if empty
+	InstanceID string
+	// MetricsBindAddress specifies the address on which the metrics endpoint is served, disabled if empty
+	MetricsBindAddress string
+	// IPAddresses specifies the IP addresses on which the proxy is listening, at most one per IP family
+	IPAddresses []string
+	// IPAddressSource specifies the object in the cluster the IP addresses are derived from instead, see source.ParseReference
+	IPAddressSource string
+	// NodeName specifies the name of the node the sidecar runs on
+	NodeName string
+	// NetNS specifies the path or PID of the network namespace to manage, the one of the sidecar if empty
+	NetNS string
+	// DuplicatePolicy specifies how duplicates of the ip addresses on other interfaces are handled
+	DuplicatePolicy string
+	// DuplicateInclude specifies the patterns of the interfaces which are checked for duplicates
+	DuplicateInclude []string
+	// DuplicateExclude specifies the patterns of the interfaces which are never checked for duplicates
+	DuplicateExclude []string
+	// ConflictThreshold specifies the number of repairs within the ConflictWindow from which on a conflict with
+	// another agent is detected, disabled if zero
+	ConflictThreshold int
+	// ConflictWindow specifies the time window in which the repairs are counted
+	ConflictWindow time.Duration
+	// ConflictBackoff specifies the maximum delay between repairs while in conflict, disabled if zero
+	ConflictBackoff time.Duration
+	// NodeCondition specifies whether the APIServerProxyAddressReady condition of the node is maintained
+	NodeCondition bool
+	// RecordEvents specifies whether events are recorded on the node
+	RecordEvents bool
+	// DryRun specifies whether the changes of the interfaces and addresses are only recorded as a plan instead of
+	// being applied
+	DryRun bool
+	// ProxyUpstreams specifies the kube-apiserver endpoints ([<server-name>=]<host>:<port>) the embedded proxy
+	// forwards the connections to in daemon mode, disabled if empty
+	ProxyUpstreams []string
+	// ProxyLoadBalancing specifies how the connections are distributed over the upstreams (round-robin,
+	// least-connections or failover)
+	ProxyLoadBalancing string
+	// ProxyDialTimeout specifies the timeout for connecting to an upstream
+	ProxyDialTimeout time.Duration
+	// ProxyHealthCheckInterval specifies the interval in which the upstreams are checked
+	ProxyHealthCheckInterval time.Duration
+	// ProxyUnhealthyThreshold specifies the number of consecutive failures after which an upstream is ejected
+	ProxyUnhealthyThreshold int
+	// ProxyDrainTimeout specifies how long the connections are drained on exit
+	ProxyDrainTimeout time.Duration
+	// ConntrackFlush indicates whether the conntrack entries of the addresses and port are flushed after the
+	// addresses were (re)added
+	ConntrackFlush bool
+	// ShutdownGracePeriod specifies how long the connections to the addresses are waited for on exit before they
+	// are removed, disabled if zero
+	ShutdownGracePeriod time.Duration
+	// ShutdownTimeout specifies the deadline for the shutdown including the grace period, unbounded if zero
+	ShutdownTimeout time.Duration
 }
 
 // SidecarApp contains all the config required to run sidecar proxy.
 type SidecarApp struct {
-	params     *ConfigParams
-	netManager netif.Manager
-	localIP    *netlink.Addr
+	params       *ConfigParams
+	handle       netif.Handle
+	dryRun       *netif.DryRunHandle
+	ns           *netns.Namespace
+	netManager   netif.Manager
+	rulesManager rules.Manager
+	prober       probe.Prober
+	proxy        *proxy.Proxy
+	source       *source.Source
+	reporter     *report.NodeReporter
+	stopEvents   func()
+	localIPs     []*netlink.Addr
+	ips          []netip.Addr
+	port         uint16
+	health       *healthStatus
+	stateStore   *state.Store
+	handover     *handover.Lock
+	prevStates   []*state.State
+	configFile   string
+	loadParams   func() (*ConfigParams, error)
+	// connections counts the established connections to the port on the addresses, see probe.Connections
+	connections func(ips []netip.Addr, port uint16) (int, error)
 }
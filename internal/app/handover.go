@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+	"github.com/gardener/apiserver-proxy/internal/report"
+)
+
+const (
+	handoverTakenOver  = "taken-over"
+	handoverHandedOver = "handed-over"
+)
+
+// takeOver records the sidecar as the owner of the addresses, so that a previous instance which is still shutting
+// down, e.g. during a rolling update, does not remove them anymore.
+func (c *SidecarApp) takeOver() {
+	if c.handover == nil {
+		return
+	}
+
+	prev, err := c.handover.TakeOver()
+	if err != nil {
+		klog.Errorf("Error taking over the addresses, a previous instance may remove them on exit: %v", err)
+		return
+	}
+
+	if prev == nil || prev.ID == c.handover.ID() {
+		klog.Infof("Owning the addresses as instance %s", c.handover.ID())
+		return
+	}
+
+	klog.Infof("Took over the addresses as instance %s from instance %s owning them since %s", c.handover.ID(), prev.ID, prev.Since)
+	metrics.Handovers.WithLabelValues(handoverTakenOver).Inc()
+
+	if c.reporter != nil {
+		c.reporter.Event(corev1.EventTypeNormal, report.ReasonAddressTakenOver,
+			fmt.Sprintf("IP addresses %v were taken over from a previous instance of the sidecar", c.params.IPAddresses))
+	}
+}
+
+// releaseNetworking removes the network interface and rules like TeardownNetworking unless a newer instance took over
+// the addresses in the meantime. It reports whether they were handed over instead of being removed. If the lock file
+// cannot be used, e.g. on a read-only file system, they are removed like without handover.
+func (c *SidecarApp) releaseNetworking() (bool, error) {
+	if c.handover == nil {
+		return false, c.TeardownNetworking()
+	}
+
+	removed := false
+	other, err := c.handover.Release(func() error {
+		removed = true
+		return c.TeardownNetworking()
+	})
+	if err != nil && !removed {
+		klog.Errorf("Error checking the owner of the addresses, removing them anyway: %v", err)
+		return false, c.TeardownNetworking()
+	}
+
+	if err != nil || other == nil {
+		return false, err
+	}
+
+	klog.Infof("Instance %s took over the addresses since %s, skipping clean up", other.ID, other.Since)
+	metrics.Handovers.WithLabelValues(handoverHandedOver).Inc()
+
+	return true, nil
+}
+
+// requireInstanceID returns an error if the handover is enabled for the given one-off command without an instance ID,
+// as the command would not be the same instance as the daemon of its pod otherwise.
+func (c *SidecarApp) requireInstanceID(command string) error {
+	if c.handover != nil && c.params.InstanceID == "" {
+		return xerrors.Errorf("the %s command requires an instance ID identifying the pod with a handover file", command)
+	}
+
+	return nil
+}
+
+// handedOver reports whether a newer instance took over the addresses.
+func (c *SidecarApp) handedOver() bool {
+	if c.handover == nil {
+		return false
+	}
+
+	owner, err := c.handover.Owner()
+	if err != nil {
+		klog.Warningf("Error getting the owner of the addresses: %v", err)
+		return false
+	}
+
+	return owner != nil && owner.ID != c.handover.ID()
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	healthzEndpoint = "/healthz"
+	readyzEndpoint  = "/readyz"
+	livezEndpoint   = "/livez"
+
+	// livenessIntervals is the number of sync intervals after which the periodic loop is considered stuck.
+	livenessIntervals = 3
+)
+
+var (
+	errNotChecked   = errors.New("not checked yet")
+	errShuttingDown = errors.New("shutting down")
+)
+
+// healthStatus records the results of the periodic checks for the health endpoints.
+type healthStatus struct {
+	mu           sync.RWMutex
+	ipAddressErr error
+	proxyErr     error
+	shuttingDown bool
+	lastCheck    time.Time
+	interval     time.Duration
+	now          func() time.Time
+}
+
+func newHealthStatus(interval time.Duration) *healthStatus {
+	return &healthStatus{
+		ipAddressErr: errNotChecked,
+		proxyErr:     errNotChecked,
+		lastCheck:    time.Now(),
+		interval:     interval,
+		now:          time.Now,
+	}
+}
+
+// setInterval sets the interval of the periodic loop, e.g. after the configuration was reloaded.
+func (s *healthStatus) setInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interval = interval
+}
+
+// recordIPAddress records the result of the most recent EnsureIPAddress call.
+func (s *healthStatus) recordIPAddress(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ipAddressErr = err
+	s.lastCheck = s.now()
+}
+
+// ipAddressChecker reports whether the most recent EnsureIPAddress call succeeded.
+func (s *healthStatus) ipAddressChecker(_ *http.Request) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ipAddressErr
+}
+
+// recordProxy records the result of the most recent probe of the proxy.
+func (s *healthStatus) recordProxy(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.proxyErr = err
+}
+
+// proxyChecker reports whether the most recent probe of the proxy succeeded.
+func (s *healthStatus) proxyChecker(_ *http.Request) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.proxyErr
+}
+
+// markShuttingDown records that the sidecar is shutting down, so that it is not ready anymore.
+func (s *healthStatus) markShuttingDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shuttingDown = true
+}
+
+// shutdownChecker reports whether the sidecar is shutting down.
+func (s *healthStatus) shutdownChecker(_ *http.Request) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.shuttingDown {
+		return errShuttingDown
+	}
+
+	return nil
+}
+
+// loopChecker reports whether the periodic loop ran within the given number of intervals.
+func (s *healthStatus) loopChecker(_ *http.Request) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if since := s.now().Sub(s.lastCheck); since > livenessIntervals*s.interval {
+		return xerrors.Errorf("last check ran %s ago", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// newHealthHandler returns the handler serving the health, readiness and liveness endpoints.
+func (c *SidecarApp) newHealthHandler() http.Handler {
+	ready := map[string]healthz.Checker{
+		"ip-address": c.health.ipAddressChecker,
+		"shutdown":   c.health.shutdownChecker,
+	}
+	if c.prober != nil {
+		ready["proxy"] = c.health.proxyChecker
+	}
+	live := map[string]healthz.Checker{
+		"periodic-loop": c.health.loopChecker,
+	}
+	all := map[string]healthz.Checker{}
+	for name, check := range ready {
+		all[name] = check
+	}
+	for name, check := range live {
+		all[name] = check
+	}
+
+	mux := http.NewServeMux()
+	for endpoint, checks := range map[string]map[string]healthz.Checker{
+		healthzEndpoint: all,
+		readyzEndpoint:  ready,
+		livezEndpoint:   live,
+	} {
+		handler := &healthz.Handler{Checks: checks}
+		mux.Handle(endpoint, http.StripPrefix(endpoint, handler))
+		// Append '/' suffix to handle subpaths
+		mux.Handle(endpoint+"/", http.StripPrefix(endpoint, handler))
+	}
+
+	return mux
+}
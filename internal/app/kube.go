@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"golang.org/x/xerrors"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// newKubeClient returns a client for the cluster using the kubeconfig given by the --kubeconfig flag
+// or the in-cluster configuration.
+func newKubeClient() (*rest.Config, client.WithWatch, error) {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("could not get kubeconfig: %v", err)
+	}
+
+	c, err := client.NewWithWatch(restConfig, client.Options{})
+	if err != nil {
+		return nil, nil, xerrors.Errorf("could not create client: %v", err)
+	}
+
+	return restConfig, c, nil
+}
@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+)
+
+const (
+	reloadSuccess = "success"
+	reloadFailure = "failure"
+)
+
+// EnableReload makes the daemon reload its parameters with load on SIGHUP or when the given
+// configuration file changes.
+func (c *SidecarApp) EnableReload(configFile string, load func() (*ConfigParams, error)) {
+	c.configFile = configFile
+	c.loadParams = load
+}
+
+// watchConfig returns a channel receiving an event on SIGHUP or when the configuration file changes.
+// Subsequent events are coalesced until the previous one was received.
+func (c *SidecarApp) watchConfig(ctx context.Context) <-chan struct{} {
+	reloads := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var (
+		fileEvents <-chan fsnotify.Event
+		fileErrors <-chan error
+	)
+
+	watcher, err := watchDir(filepath.Dir(c.configFile))
+	if err != nil {
+		klog.Warningf("Unable to watch config file %s, reloading only on SIGHUP: %v", c.configFile, err)
+	} else {
+		fileEvents, fileErrors = watcher.Events, watcher.Errors
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+		if watcher != nil {
+			defer func() { _ = watcher.Close() }()
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				klog.Infoln("Received SIGHUP, reloading configuration")
+				notify()
+			case event := <-fileEvents:
+				if !isConfigEvent(event, c.configFile) {
+					continue
+				}
+
+				klog.V(2).Infof("Config directory changed: %s", event)
+				notify()
+			case err := <-fileErrors:
+				klog.Warningf("Error watching config file %s: %v", c.configFile, err)
+			}
+		}
+	}()
+
+	return reloads
+}
+
+// watchDir returns a watcher for the given directory. The directory is watched instead of the file,
+// as a mounted ConfigMap is updated by replacing a symlink.
+func watchDir(dir string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// isConfigEvent reports whether the event may have changed the content of the configuration file,
+// either directly or by replacing the "..data" symlink of a mounted ConfigMap.
+func isConfigEvent(event fsnotify.Event, configFile string) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+
+	name := filepath.Base(event.Name)
+
+	return name == filepath.Base(configFile) || strings.HasPrefix(name, "..")
+}
+
+// reload loads the parameters and applies their difference to the current ones. The resources of
+// the new parameters are ensured before the ones which are not part of them anymore are removed, so
+// that the addresses stay available throughout. It reports whether the parameters were replaced.
+func (c *SidecarApp) reload(ctx context.Context) bool {
+	params, err := c.desiredParams(ctx)
+	if err != nil {
+		klog.Errorf("Error reloading configuration, keeping the current one: %v", err)
+		metrics.ConfigReloads.WithLabelValues(reloadFailure).Inc()
+
+		return false
+	}
+
+	c.keepStartupParams(params)
+
+	if reflect.DeepEqual(params, c.params) {
+		klog.V(2).Infoln("Configuration unchanged")
+		return false
+	}
+
+	next, err := newSidecarApp(params, c.handle, c.ns)
+	if err != nil {
+		klog.Errorf("Error applying reloaded configuration, keeping the current one: %v", err)
+		metrics.ConfigReloads.WithLabelValues(reloadFailure).Inc()
+
+		return false
+	}
+
+	// the reporter is only created on startup and used for the duplicates found by the next components
+	next.reporter = c.reporter
+
+	cur, err := c.currentState()
+	if err != nil {
+		klog.Errorf("Error getting current state, keeping the current configuration: %v", err)
+		metrics.ConfigReloads.WithLabelValues(reloadFailure).Inc()
+
+		return false
+	}
+
+	klog.Infof("Applying reloaded configuration: interface %q, addresses %v", params.Interface, params.IPAddresses)
+
+	// The resources of the current configuration are removed by the next successful check
+	// once the ones of the new configuration are in place.
+	c.prevStates = append(c.prevStates, cur)
+	c.params = next.params
+	c.netManager = next.netManager
+	c.rulesManager = next.rulesManager
+	c.prober = next.prober
+	c.localIPs = next.localIPs
+	c.ips = next.ips
+	c.port = next.port
+
+	c.health.setInterval(c.params.Interval)
+	if c.prober == nil {
+		c.health.recordProxy(nil)
+	}
+
+	metrics.ConfigReloads.WithLabelValues(reloadSuccess).Inc()
+
+	_ = c.runChecks(ctx)
+
+	return true
+}
+
+// desiredParams returns the reloaded parameters, or a copy of the current ones if reloading is not enabled,
+// with the addresses of the source if one is configured.
+func (c *SidecarApp) desiredParams(ctx context.Context) (*ConfigParams, error) {
+	params := new(ConfigParams)
+	*params = *c.params
+
+	if c.loadParams != nil {
+		var err error
+		if params, err = c.loadParams(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.source != nil {
+		params.IPAddresses = c.resolveAddresses(ctx)
+	}
+
+	return params, nil
+}
+
+// keepStartupParams overrides the parameters which are only evaluated on startup with the current ones,
+// warning if they were changed.
+func (c *SidecarApp) keepStartupParams(params *ConfigParams) {
+	for name, values := range map[string][2]*string{
+		"state file":           {&params.StateFile, &c.params.StateFile},
+		"handover file":        {&params.HandoverFile, &c.params.HandoverFile},
+		"instance id":          {&params.InstanceID, &c.params.InstanceID},
+		"health bind address":  {&params.HealthBindAddress, &c.params.HealthBindAddress},
+		"metrics bind address": {&params.MetricsBindAddress, &c.params.MetricsBindAddress},
+		"ip address source":    {&params.IPAddressSource, &c.params.IPAddressSource},
+		"node name":            {&params.NodeName, &c.params.NodeName},
+		"network namespace":    {&params.NetNS, &c.params.NetNS},
+		"proxy load balancing": {&params.ProxyLoadBalancing, &c.params.ProxyLoadBalancing},
+	} {
+		if *values[0] != *values[1] {
+			klog.Warningf("Changing the %s requires a restart, keeping %q", name, *values[1])
+			*values[0] = *values[1]
+		}
+	}
+
+	for name, values := range map[string][2]*time.Duration{
+		"proxy dial timeout":          {&params.ProxyDialTimeout, &c.params.ProxyDialTimeout},
+		"proxy health check interval": {&params.ProxyHealthCheckInterval, &c.params.ProxyHealthCheckInterval},
+		"proxy drain timeout":         {&params.ProxyDrainTimeout, &c.params.ProxyDrainTimeout},
+	} {
+		if *values[0] != *values[1] {
+			klog.Warningf("Changing the %s requires a restart, keeping %v", name, *values[1])
+			*values[0] = *values[1]
+		}
+	}
+
+	if params.ProxyUnhealthyThreshold != c.params.ProxyUnhealthyThreshold {
+		klog.Warningf("Changing the proxy unhealthy threshold requires a restart, keeping %d", c.params.ProxyUnhealthyThreshold)
+		params.ProxyUnhealthyThreshold = c.params.ProxyUnhealthyThreshold
+	}
+
+	if !slices.Equal(params.ProxyUpstreams, c.params.ProxyUpstreams) {
+		klog.Warningf("Changing the proxy upstreams requires a restart, keeping %v", c.params.ProxyUpstreams)
+		params.ProxyUpstreams = c.params.ProxyUpstreams
+	}
+
+	for name, values := range map[string][2]*bool{
+		"daemon mode":         {&params.Daemon, &c.params.Daemon},
+		"node condition":      {&params.NodeCondition, &c.params.NodeCondition},
+		"recording of events": {&params.RecordEvents, &c.params.RecordEvents},
+	} {
+		if *values[0] != *values[1] {
+			klog.Warningf("Changing the %s requires a restart, keeping %t", name, *values[1])
+			*values[0] = *values[1]
+		}
+	}
+}
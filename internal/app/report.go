@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/gardener/apiserver-proxy/internal/report"
+)
+
+const (
+	// eventComponent is the component recorded as the source of the events.
+	eventComponent = "apiserver-proxy-sidecar"
+	// reportTimeout is the timeout for updating the node condition.
+	reportTimeout = 5 * time.Second
+)
+
+// newReporter returns the reporter configured by the parameters and a function which stops recording events.
+func newReporter(restConfig *rest.Config, c client.Client, params *ConfigParams) (*report.NodeReporter, func(), error) {
+	var (
+		recorder record.EventRecorder
+		stop     func()
+	)
+
+	if params.RecordEvents {
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("could not create clientset for recording events: %v", err)
+		}
+
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+		recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent, Host: params.NodeName})
+		stop = broadcaster.Shutdown
+	}
+
+	return report.NewNodeReporter(c, recorder, params.NodeName, params.NodeCondition), stop, nil
+}
+
+// report reports the results of ensuring the ip addresses and probing the proxy.
+func (c *SidecarApp) report(ctx context.Context, ensureErr, proxyErr error) {
+	if c.reporter == nil {
+		return
+	}
+
+	res := report.Result{
+		Ready:   true,
+		Reason:  report.ReasonAddressReady,
+		Message: fmt.Sprintf("IP addresses %v are present on interface %s", c.params.IPAddresses, c.params.Interface),
+	}
+	if c.prober != nil {
+		res.Message += fmt.Sprintf(" and the proxy is reachable on port %d", c.port)
+	}
+
+	switch conflict := c.netManager.Conflict(); {
+	case ensureErr != nil:
+		res = report.Result{Reason: report.ReasonAddressNotReady, Message: ensureErr.Error()}
+	case conflict != nil:
+		res = report.Result{Reason: report.ReasonAddressConflict, Message: fmt.Sprintf(
+			"IP addresses %v on interface %s are repaired repeatedly since %s, another agent keeps changing them",
+			c.params.IPAddresses, c.params.Interface, conflict.Since.Format(time.RFC3339))}
+	case proxyErr != nil:
+		res = report.Result{Reason: report.ReasonProxyNotReachable, Message: proxyErr.Error()}
+	}
+
+	c.doReport(ctx, res)
+}
+
+// reportRemoved reports that the ip addresses were removed.
+func (c *SidecarApp) reportRemoved() {
+	if c.reporter == nil {
+		return
+	}
+
+	c.doReport(context.Background(), report.Result{
+		Reason:  report.ReasonAddressRemoved,
+		Message: fmt.Sprintf("IP addresses %v were removed from interface %s", c.params.IPAddresses, c.params.Interface),
+	})
+}
+
+// recordDuplicate records an event for a duplicate of the ip addresses found on another interface.
+func (c *SidecarApp) recordDuplicate(addr, devName string, removed bool) {
+	if c.reporter == nil {
+		return
+	}
+
+	if removed {
+		c.reporter.Event(corev1.EventTypeWarning, report.ReasonDuplicateAddressRemoved,
+			fmt.Sprintf("Removed duplicate of IP address %s from interface %s", addr, devName))
+		return
+	}
+
+	c.reporter.Event(corev1.EventTypeWarning, report.ReasonDuplicateAddressFound,
+		fmt.Sprintf("Found duplicate of IP address %s on interface %s, keeping it due to duplicate policy %q",
+			addr, devName, c.params.DuplicatePolicy))
+}
+
+func (c *SidecarApp) doReport(ctx context.Context, res report.Result) {
+	ctx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+
+	if err := c.reporter.Report(ctx, res); err != nil {
+		klog.Warningf("Error reporting %s: %v", res.Reason, err)
+	}
+}
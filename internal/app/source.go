@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"time"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+	"github.com/gardener/apiserver-proxy/internal/source"
+	"github.com/gardener/apiserver-proxy/internal/state"
+)
+
+// sourceTimeout is the timeout for reading the addresses from the source.
+const sourceTimeout = 10 * time.Second
+
+// newSource returns the source of the addresses configured by the parameters.
+func newSource(c client.WithWatch, params *ConfigParams) (*source.Source, error) {
+	ref, err := source.ParseReference(params.IPAddressSource, params.NodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Deriving IP addresses from %s", ref)
+
+	return source.NewSource(c, *ref), nil
+}
+
+// initialAddresses returns the addresses of the source. If the source cannot be read, e.g. because
+// the apiserver is not reachable, the last-known addresses recorded in the state file are returned.
+func initialAddresses(src *source.Source, stateFile string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sourceTimeout)
+	defer cancel()
+
+	addrs, err := src.Addresses(ctx)
+	if err == nil {
+		return addrs, nil
+	}
+
+	metrics.AddressSourceFailures.Inc()
+
+	if stateFile == "" {
+		return nil, xerrors.Errorf("could not read addresses from %s and no state file is configured: %v", src, err)
+	}
+
+	st, loadErr := state.NewStore(stateFile).Load()
+	if loadErr != nil || st == nil || len(st.Addresses) == 0 {
+		return nil, xerrors.Errorf("could not read addresses from %s and no last-known addresses are recorded: %v", src, err)
+	}
+
+	addrs = make([]string, 0, len(st.Addresses))
+	for _, cidr := range st.Addresses {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, xerrors.Errorf("unable to parse recorded IP address %q - %v", cidr, err)
+		}
+		addrs = append(addrs, prefix.Addr().String())
+	}
+
+	klog.Warningf("Could not read addresses from %s, using the last-known addresses %v: %v", src, addrs, err)
+
+	return addrs, nil
+}
+
+// resolveAddresses returns the addresses of the source or, if it cannot be read, the current ones.
+func (c *SidecarApp) resolveAddresses(ctx context.Context) []string {
+	ctx, cancel := context.WithTimeout(ctx, sourceTimeout)
+	defer cancel()
+
+	addrs, err := c.source.Addresses(ctx)
+	if err != nil {
+		klog.Warningf("Could not read addresses from %s, keeping %v: %v", c.source, c.params.IPAddresses, err)
+		metrics.AddressSourceFailures.Inc()
+
+		return slices.Clone(c.params.IPAddresses)
+	}
+
+	return addrs
+}
+
+// watchSource watches the source for changes. It returns nil if no source is configured or watching
+// fails, in which case the source is only read by the periodic checks.
+func (c *SidecarApp) watchSource(ctx context.Context) <-chan struct{} {
+	if c.source == nil {
+		return nil
+	}
+
+	events, err := c.source.Watch(ctx)
+	if err != nil {
+		klog.Warningf("Unable to watch address source, relying on periodic checks: %v", err)
+		return nil
+	}
+
+	return events
+}
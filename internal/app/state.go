@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/rules"
+	"github.com/gardener/apiserver-proxy/internal/state"
+)
+
+// loadState loads the state recorded by a previous run, so that the resources which are not
+// managed anymore can be removed after the current ones have been ensured.
+func (c *SidecarApp) loadState() {
+	if c.stateStore == nil {
+		return
+	}
+
+	prev, err := c.stateStore.Load()
+	if err != nil {
+		klog.Errorf("Error loading state, stale resources of a previous run are not removed: %v", err)
+		return
+	}
+
+	if prev != nil {
+		klog.Infof("Loaded state of previous run: interface %q, addresses %v", prev.Interface, prev.Addresses)
+		c.prevStates = append(c.prevStates, prev)
+	}
+}
+
+// currentState returns the state of the resources managed with the current configuration.
+func (c *SidecarApp) currentState() (*state.State, error) {
+	st := &state.State{Interface: c.params.Interface}
+	for _, addr := range c.localIPs {
+		st.Addresses = append(st.Addresses, addr.IPNet.String())
+	}
+
+	owned, err := c.netManager.DeviceOwned()
+	if err != nil {
+		return nil, err
+	}
+	if owned {
+		st.CreatedLinks = []string{c.params.Interface}
+	}
+
+	if c.rulesManager != nil {
+		st.Rules = &state.Rules{Backend: c.params.RulesBackend, Port: c.port}
+		for _, ip := range c.ips {
+			st.Rules.Addresses = append(st.Rules.Addresses, ip.String())
+		}
+	}
+
+	return st, nil
+}
+
+// syncState removes the stale resources of a previous run or configuration and records the current
+// state afterwards. The current state is only recorded once all stale resources are removed, so they
+// are not forgotten if the sidecar crashes in between.
+func (c *SidecarApp) syncState() {
+	if c.stateStore == nil && len(c.prevStates) == 0 {
+		return
+	}
+
+	cur, err := c.currentState()
+	if err != nil {
+		klog.Errorf("Error getting current state: %v", err)
+		return
+	}
+
+	for len(c.prevStates) > 0 {
+		if err := c.removeStale(staleState(c.prevStates[0], cur)); err != nil {
+			klog.Errorf("Error removing stale resources of previous run or configuration: %v", err)
+			return
+		}
+
+		c.prevStates = c.prevStates[1:]
+	}
+
+	if c.stateStore == nil || c.dryRun != nil {
+		return
+	}
+
+	if err := c.stateStore.Save(cur); err != nil {
+		klog.Errorf("Error saving state: %v", err)
+	}
+}
+
+// staleState returns the resources recorded in prev which are not part of cur.
+func staleState(prev, cur *state.State) *state.State {
+	stale := &state.State{Interface: prev.Interface}
+
+	for _, addr := range prev.Addresses {
+		if prev.Interface != cur.Interface || !slices.Contains(cur.Addresses, addr) {
+			stale.Addresses = append(stale.Addresses, addr)
+		}
+	}
+
+	for _, link := range prev.CreatedLinks {
+		if link != cur.Interface {
+			stale.CreatedLinks = append(stale.CreatedLinks, link)
+		}
+	}
+
+	switch {
+	case prev.Rules == nil:
+	case cur.Rules == nil || cur.Rules.Backend != prev.Rules.Backend:
+		stale.Rules = prev.Rules
+	case prev.Rules.Backend == rules.BackendNFTables:
+		// the table is replaced as a whole when ensuring the current rules
+	case cur.Rules.Port != prev.Rules.Port:
+		stale.Rules = prev.Rules
+	default:
+		var addrs []string
+		for _, addr := range prev.Rules.Addresses {
+			if !slices.Contains(cur.Rules.Addresses, addr) {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) > 0 {
+			stale.Rules = &state.Rules{Backend: prev.Rules.Backend, Addresses: addrs, Port: prev.Rules.Port}
+		}
+	}
+
+	return stale
+}
+
+// removeStale removes the given resources.
+func (c *SidecarApp) removeStale(stale *state.State) error {
+	var errs []error
+
+	if stale.Rules != nil && c.dryRun != nil {
+		klog.Warningf("Rules are not part of the plan in dry-run mode, keeping stale %s rules for addresses %v and port %d",
+			stale.Rules.Backend, stale.Rules.Addresses, stale.Rules.Port)
+	} else if stale.Rules != nil {
+		klog.Infof("Removing stale %s rules for addresses %v and port %d", stale.Rules.Backend, stale.Rules.Addresses, stale.Rules.Port)
+
+		var ips []netip.Addr
+		for _, addr := range stale.Rules.Addresses {
+			ip, err := netip.ParseAddr(addr)
+			if err != nil {
+				return xerrors.Errorf("unable to parse IP address %q - %v", addr, err)
+			}
+			ips = append(ips, ip)
+		}
+
+		m, err := rules.NewRulesManager(stale.Rules.Backend, c.ns, ips, stale.Rules.Port)
+		if err == nil {
+			err = m.CleanupRules()
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(stale.Addresses) > 0 {
+		klog.Infof("Removing stale addresses %v from interface %q", stale.Addresses, stale.Interface)
+
+		var addrs []*netlink.Addr
+		for _, cidr := range stale.Addresses {
+			addr, err := netlink.ParseAddr(cidr)
+			if err != nil {
+				return xerrors.Errorf("unable to parse IP address %q - %v", cidr, err)
+			}
+			addrs = append(addrs, addr)
+		}
+
+		if err := netif.NewNetifManagerWithHandle(c.handle, addrs, stale.Interface, netif.Options{}).RemoveIPAddress(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, link := range stale.CreatedLinks {
+		klog.Infof("Removing stale interface %q", link)
+
+		if err := netif.NewNetifManagerWithHandle(c.handle, nil, link, netif.Options{}).CleanupDevice(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
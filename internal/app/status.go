@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package app
+
+import (
+	"github.com/gardener/apiserver-proxy/internal/netif"
+)
+
+// Status is the observed state of the resources managed by the sidecar.
+type Status struct {
+	// Network is the state of the interface and the addresses.
+	Network *netif.Status `json:"network"`
+	// Rules is the state of the rules, nil if no rules are managed.
+	Rules *RulesStatus `json:"rules,omitempty"`
+	// Healthy reports whether all managed resources are in the desired state.
+	Healthy bool `json:"healthy"`
+}
+
+// RulesStatus is the observed state of the rules.
+type RulesStatus struct {
+	// Backend is the backend the rules are managed with.
+	Backend string `json:"backend"`
+	// Present reports whether all rules are installed.
+	Present bool `json:"present"`
+}
+
+// Status returns the observed state of the resources managed by the sidecar without changing anything.
+func (c *SidecarApp) Status() (*Status, error) {
+	network, err := c.netManager.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Status{Network: network, Healthy: network.Healthy()}
+
+	if c.rulesManager != nil {
+		present, err := c.rulesManager.RulesPresent()
+		if err != nil {
+			return nil, err
+		}
+
+		st.Rules = &RulesStatus{Backend: c.params.RulesBackend, Present: present}
+		st.Healthy = st.Healthy && present
+	}
+
+	return st, nil
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package handover coordinates the sidecar instances managing the same addresses, e.g. the old and the new pod during
+// a rolling update of the DaemonSet, with a lock file on the host recording the instance owning the addresses.
+package handover
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// Owner is the sidecar instance owning the addresses.
+type Owner struct {
+	// ID is the random ID of the instance.
+	ID string `json:"id"`
+	// Since is the time the instance took over the addresses.
+	Since time.Time `json:"since"`
+}
+
+// Lock records the owner of the addresses in a lock file. The file is locked with flock while it is read or written
+// and while the addresses are removed, so that a newer instance cannot take over in between.
+type Lock struct {
+	path string
+	self Owner
+}
+
+// NewLock returns a Lock for the lock file at the given path on behalf of the instance with the given ID, e.g. the
+// UID of its pod, or of a new instance with a random ID if it is empty.
+func NewLock(path, id string) *Lock {
+	if id == "" {
+		id = rand.Text()
+	}
+
+	return &Lock{path: path, self: Owner{ID: id}}
+}
+
+// ID returns the ID of the instance.
+func (l *Lock) ID() string {
+	return l.self.ID
+}
+
+// TakeOver records the instance as the owner of the addresses. It returns the previous owner, or nil if there was
+// none, e.g. because it released the addresses. The previous owner may be the instance itself, e.g. if the addresses
+// were set up by an init container of the same pod.
+func (l *Lock) TakeOver() (*Owner, error) {
+	var prev *Owner
+
+	err := l.withLock(func(f *os.File) error {
+		var err error
+		if prev, err = read(f); err != nil {
+			return err
+		}
+
+		l.self.Since = time.Now().UTC().Truncate(time.Second)
+
+		return write(f, &l.self)
+	})
+
+	return prev, err
+}
+
+// Owner returns the owner of the addresses, or nil if there is none.
+func (l *Lock) Owner() (*Owner, error) {
+	var owner *Owner
+
+	err := l.withLock(func(f *os.File) error {
+		var err error
+		owner, err = read(f)
+		return err
+	})
+
+	return owner, err
+}
+
+// Release calls remove if the addresses are not owned by another instance and releases them afterwards, so that the
+// next instance does not take them over from this one. It returns the other owner if remove was not called.
+func (l *Lock) Release(remove func() error) (*Owner, error) {
+	var other *Owner
+
+	err := l.withLock(func(f *os.File) error {
+		owner, err := read(f)
+		if err != nil {
+			return err
+		}
+
+		// the addresses are removed if the instance did not record itself, e.g. because taking over failed
+		if owner != nil && owner.ID != l.self.ID {
+			other = owner
+			return nil
+		}
+
+		if err := remove(); err != nil {
+			return err
+		}
+
+		return write(f, nil)
+	})
+
+	return other, err
+}
+
+// withLock calls fn with the lock file locked exclusively, creating it if it does not exist. The file is never
+// removed, as another instance may wait for the lock of the removed file.
+func (l *Lock) withLock(fn func(f *os.File) error) error {
+	dir := filepath.Dir(l.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return xerrors.Errorf("could not create directory %s: %v", dir, err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return xerrors.Errorf("could not open lock file %s: %v", l.path, err)
+	}
+	// closing the file releases the lock
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil { // #nosec G115 -- file descriptors fit into an int
+		return xerrors.Errorf("could not lock %s: %v", l.path, err)
+	}
+
+	return fn(f)
+}
+
+// read returns the owner recorded in the lock file, or nil if it is empty.
+func read(f *os.File) (*Owner, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, xerrors.Errorf("could not read lock file %s: %v", f.Name(), err)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	owner := &Owner{}
+	if err := json.Unmarshal(data, owner); err != nil {
+		return nil, xerrors.Errorf("could not decode lock file %s: %v", f.Name(), err)
+	}
+
+	return owner, nil
+}
+
+// write records the owner in the lock file, which is emptied if nil.
+func write(f *os.File, owner *Owner) error {
+	var data []byte
+	if owner != nil {
+		var err error
+		if data, err = json.Marshal(owner); err != nil {
+			return xerrors.Errorf("could not encode owner: %v", err)
+		}
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return xerrors.Errorf("could not truncate lock file %s: %v", f.Name(), err)
+	}
+
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return xerrors.Errorf("could not write lock file %s: %v", f.Name(), err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return xerrors.Errorf("could not sync lock file %s: %v", f.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package handover
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHandover(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handover Suite")
+}
+
+var _ = Describe("Lock", func() {
+
+	var (
+		dir     string
+		path    string
+		oldLock *Lock
+		newLock *Lock
+		removed int
+		remove  func() error
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "handover")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "apiserver-proxy", "owner.lock")
+
+		oldLock, newLock = NewLock(path, ""), NewLock(path, "")
+		Expect(oldLock.ID()).ToNot(Equal(newLock.ID()))
+
+		removed = 0
+		remove = func() error {
+			removed++
+			return nil
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should take over the addresses if there is no owner", func() {
+		Expect(oldLock.TakeOver()).To(BeNil())
+
+		owner, err := newLock.Owner()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(owner.ID).To(Equal(oldLock.ID()))
+		Expect(owner.Since).ToNot(BeZero())
+	})
+
+	It("should take over the addresses from the previous owner", func() {
+		Expect(oldLock.TakeOver()).To(BeNil())
+
+		prev, err := newLock.TakeOver()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prev.ID).To(Equal(oldLock.ID()))
+		Expect(oldLock.Owner()).To(HaveField("ID", newLock.ID()))
+	})
+
+	It("should not remove the addresses taken over by a newer instance", func() {
+		Expect(oldLock.TakeOver()).To(BeNil())
+		Expect(newLock.TakeOver()).ToNot(BeNil())
+
+		other, err := oldLock.Release(remove)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.ID).To(Equal(newLock.ID()))
+		Expect(removed).To(BeZero())
+		Expect(newLock.Owner()).To(HaveField("ID", newLock.ID()))
+	})
+
+	It("should keep the given ID", func() {
+		Expect(NewLock(path, "pod-uid").ID()).To(Equal("pod-uid"))
+	})
+
+	It("should remove and release the owned addresses", func() {
+		Expect(oldLock.TakeOver()).To(BeNil())
+
+		Expect(oldLock.Release(remove)).To(BeNil())
+		Expect(removed).To(Equal(1))
+		Expect(newLock.Owner()).To(BeNil())
+		Expect(newLock.TakeOver()).To(BeNil())
+	})
+
+	It("should remove the addresses if no instance took them over", func() {
+		Expect(oldLock.Release(remove)).To(BeNil())
+		Expect(removed).To(Equal(1))
+	})
+
+	It("should keep the ownership if removing the addresses failed", func() {
+		Expect(oldLock.TakeOver()).To(BeNil())
+
+		_, err := oldLock.Release(func() error { return fmt.Errorf("err") })
+		Expect(err).To(MatchError("err"))
+		Expect(newLock.Owner()).To(HaveField("ID", oldLock.ID()))
+	})
+})
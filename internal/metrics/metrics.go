@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "apiserver_proxy_sidecar"
+
+var (
+	// Registry is the registry holding all metrics of the sidecar.
+	Registry = prometheus.NewRegistry()
+
+	// EnsureIPAddressSuccesses counts the successful attempts to ensure the ip addresses.
+	EnsureIPAddressSuccesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ensure_ip_address_successes_total",
+		Help:      "Number of successful attempts to ensure the ip addresses.",
+	})
+
+	// EnsureIPAddressFailures counts the failed attempts to ensure the ip addresses by the reason of the failure.
+	EnsureIPAddressFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ensure_ip_address_failures_total",
+		Help:      "Number of failed attempts to ensure the ip addresses by the reason of the failure.",
+	}, []string{"reason"})
+
+	// ReconcileDuration observes the duration of the periodic checks.
+	ReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of ensuring the ip addresses and rules in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// AddressPresent reports whether an ip address is present on the interface.
+	AddressPresent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "address_present",
+		Help:      "Whether the ip address is present on the interface (1) or not (0).",
+	}, []string{"address", "interface"})
+
+	// DuplicateAddressesRemoved counts the duplicates of the ip addresses removed from other interfaces.
+	DuplicateAddressesRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_addresses_removed_total",
+		Help:      "Number of duplicates of the ip addresses removed from other interfaces.",
+	}, []string{"interface"})
+
+	// DuplicateAddressesFound counts the duplicates of the ip addresses found and kept on other interfaces
+	// according to the duplicate policy.
+	DuplicateAddressesFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_addresses_found_total",
+		Help:      "Number of times a duplicate of the ip addresses was found and kept on another interface due to the duplicate policy.",
+	}, []string{"interface"})
+
+	// Repairs counts the repairs of the managed state after it was established, e.g. re-adding a removed ip address.
+	Repairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repairs_total",
+		Help:      "Number of repairs of the managed state after it was established by the kind of repair (address, link or duplicate).",
+	}, []string{"kind", "interface"})
+
+	// AddressConflict reports whether a conflict with another agent changing the ip addresses was detected.
+	AddressConflict = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "address_conflict",
+		Help:      "Whether another agent keeps changing the ip addresses on the interface (1) or not (0).",
+	}, []string{"interface"})
+
+	// ProxyReachable reports whether the proxy is reachable on an address.
+	ProxyReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_reachable",
+		Help:      "Whether the proxy is reachable on the address and port (1) or not (0).",
+	}, []string{"address"})
+
+	// ProbeFailures counts the failed probes of the proxy.
+	ProbeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_probe_failures_total",
+		Help:      "Number of failed probes of the proxy on the address and port.",
+	}, []string{"address"})
+
+	// ProxyConnectionsActive reports the number of connections the embedded proxy currently forwards.
+	ProxyConnectionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_connections_active",
+		Help:      "Number of connections currently forwarded by the embedded proxy.",
+	})
+
+	// ProxyConnections counts the connections accepted by the embedded proxy by the upstream they were forwarded to.
+	ProxyConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_connections_total",
+		Help:      "Number of connections accepted by the embedded proxy by the upstream they were forwarded to (none if no upstream was reachable).",
+	}, []string{"upstream"})
+
+	// ProxyUpstreamHealthy reports whether an upstream of the embedded proxy is healthy.
+	ProxyUpstreamHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_upstream_healthy",
+		Help:      "Whether the upstream of the embedded proxy is healthy (1) or not (0).",
+	}, []string{"upstream"})
+
+	// ProxyUpstreamFailures counts the failed health checks and connection attempts of an upstream of the embedded proxy.
+	ProxyUpstreamFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_upstream_failures_total",
+		Help:      "Number of failed health checks and connection attempts of the upstream of the embedded proxy.",
+	}, []string{"upstream"})
+
+	// ConfigReloads counts the attempts to reload the configuration by their result.
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of attempts to reload the configuration by their result (success or failure).",
+	}, []string{"result"})
+
+	// AddressSourceFailures counts the failed attempts to read the ip addresses from their source in the cluster.
+	AddressSourceFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "address_source_failures_total",
+		Help:      "Number of failed attempts to read the ip addresses from their source in the cluster.",
+	})
+
+	// Handovers counts the ip addresses taken over from a previous instance of the sidecar and the removals skipped as
+	// a newer one took them over, by their direction.
+	Handovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handovers_total",
+		Help:      "Number of handovers of the ip addresses between instances of the sidecar by direction (taken-over or handed-over).",
+	}, []string{"direction"})
+
+	// ConntrackFlushes counts the flushes of the conntrack entries of the ip addresses after they were (re)added, by
+	// their result.
+	ConntrackFlushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conntrack_flushes_total",
+		Help:      "Number of flushes of the conntrack entries of an ip address after it was (re)added by result (success or failure).",
+	}, []string{"address", "result"})
+
+	// ConntrackEntriesFlushed counts the conntrack entries flushed for the ip addresses.
+	ConntrackEntriesFlushed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conntrack_entries_flushed_total",
+		Help:      "Number of conntrack entries flushed for an ip address.",
+	}, []string{"address"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		EnsureIPAddressSuccesses,
+		EnsureIPAddressFailures,
+		ReconcileDuration,
+		AddressPresent,
+		DuplicateAddressesRemoved,
+		DuplicateAddressesFound,
+		Repairs,
+		AddressConflict,
+		ProxyReachable,
+		ProbeFailures,
+		ProxyConnectionsActive,
+		ProxyConnections,
+		ProxyUpstreamHealthy,
+		ProxyUpstreamFailures,
+		ConfigReloads,
+		AddressSourceFailures,
+		Handovers,
+		ConntrackFlushes,
+		ConntrackEntriesFlushed,
+	)
+}
+
+// Handler returns the handler serving the metrics of the Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
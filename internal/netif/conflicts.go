@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netif
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+)
+
+const (
+	// RepairAddress is the kind of repair re-adding a managed address which was removed.
+	RepairAddress = "address"
+	// RepairLink is the kind of repair re-creating the interface which was deleted.
+	RepairLink = "link"
+	// RepairDuplicate is the kind of repair removing a duplicate of a managed address from another interface.
+	RepairDuplicate = "duplicate"
+
+	// initialConflictBackoff is the first delay between repairs while in conflict, doubled on every repair.
+	initialConflictBackoff = 10 * time.Second
+)
+
+// Conflicts configures the detection of conflicts with other agents, e.g. kube-proxy or a CNI plugin, which keep
+// removing the managed addresses or adding them to other interfaces. Only repairs of a state which was established
+// before are taken into account.
+type Conflicts struct {
+	// Threshold is the number of repairs within Window from which on a conflict is detected, disabled if zero.
+	Threshold int
+	// Window is the time window in which the repairs are counted. A conflict is resolved once no repair was
+	// needed for a whole window.
+	Window time.Duration
+	// MaxBackoff is the maximum delay between repairs while in conflict. Repairs are not delayed if zero.
+	MaxBackoff time.Duration
+}
+
+// Validate validates the threshold, window and backoff.
+func (c *Conflicts) Validate() error {
+	if c.Threshold < 0 || c.MaxBackoff < 0 {
+		return xerrors.Errorf("conflict threshold and backoff must not be negative, got %d and %v", c.Threshold, c.MaxBackoff)
+	}
+
+	if c.Threshold > 0 && c.Window <= 0 {
+		return xerrors.Errorf("conflict window must be positive, got %v", c.Window)
+	}
+
+	return nil
+}
+
+// ConflictStatus is the state of a conflict with another agent.
+type ConflictStatus struct {
+	// Since is the time the conflict was detected.
+	Since time.Time `json:"since"`
+	// Repairs is the number of repairs within the window.
+	Repairs int `json:"repairs"`
+	// BackoffUntil is the time until which repairs are delayed, if they are.
+	BackoffUntil *time.Time `json:"backoffUntil,omitempty"`
+}
+
+// conflictTracker tracks the repairs of the managed state and detects conflicts.
+type conflictTracker struct {
+	Conflicts
+	devName string
+	now     func() time.Time
+
+	repairs    []time.Time
+	lastNeeded time.Time
+	since      time.Time
+	delay      time.Duration
+	nextRepair time.Time
+}
+
+func newConflictTracker(conflicts Conflicts, devName string) *conflictTracker {
+	return &conflictTracker{Conflicts: conflicts, devName: devName, now: time.Now}
+}
+
+// record records a repair of the given kind and detects a conflict if the threshold is reached.
+func (t *conflictTracker) record(kind string) {
+	metrics.Repairs.WithLabelValues(kind, t.devName).Inc()
+
+	if t.Threshold <= 0 {
+		return
+	}
+
+	now := t.now()
+	t.prune(now)
+	t.repairs = append(t.repairs, now)
+	t.lastNeeded = now
+
+	if len(t.repairs) < t.Threshold {
+		return
+	}
+
+	if t.since.IsZero() {
+		t.since = now
+		klog.Warningf("Detected a conflict on interface %q: repaired the managed state %d times within %v, "+
+			"another agent keeps removing the addresses or adding them to other interfaces", t.devName, len(t.repairs), t.Window)
+		metrics.AddressConflict.WithLabelValues(t.devName).Set(1)
+	}
+
+	if t.MaxBackoff > 0 {
+		t.delay = min(max(2*t.delay, initialConflictBackoff), t.MaxBackoff)
+		t.nextRepair = now.Add(t.delay)
+		klog.Warningf("Delaying the next repair on interface %q by %v due to the conflict", t.devName, t.delay)
+	}
+}
+
+// skipped records that a repair was needed but skipped due to the backoff.
+func (t *conflictTracker) skipped() {
+	t.lastNeeded = t.now()
+}
+
+// update forgets the repairs outside the window and resolves the conflict if no repair was needed for a whole window.
+func (t *conflictTracker) update() {
+	if t.Threshold <= 0 {
+		return
+	}
+
+	now := t.now()
+	t.prune(now)
+
+	if t.since.IsZero() || now.Sub(t.lastNeeded) < t.Window {
+		return
+	}
+
+	klog.Infof("Conflict on interface %q resolved, no repair was needed within %v", t.devName, t.Window)
+	metrics.AddressConflict.WithLabelValues(t.devName).Set(0)
+	t.since = time.Time{}
+	t.delay = 0
+	t.nextRepair = time.Time{}
+}
+
+// backoff returns the remaining delay until the next repair.
+func (t *conflictTracker) backoff() time.Duration {
+	if t.nextRepair.IsZero() {
+		return 0
+	}
+
+	return max(t.nextRepair.Sub(t.now()), 0)
+}
+
+func (t *conflictTracker) prune(now time.Time) {
+	i := 0
+	for i < len(t.repairs) && now.Sub(t.repairs[i]) >= t.Window {
+		i++
+	}
+	t.repairs = t.repairs[i:]
+}
+
+// status returns the state of the conflict or nil if there is none.
+func (t *conflictTracker) status() *ConflictStatus {
+	if t.since.IsZero() {
+		return nil
+	}
+
+	st := &ConflictStatus{Since: t.since, Repairs: len(t.repairs)}
+	if t.backoff() > 0 {
+		until := t.nextRepair
+		st.BackoffUntil = &until
+	}
+
+	return st
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netif
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+)
+
+const (
+	conntrackFlushSuccess = "success"
+	conntrackFlushFailure = "failure"
+)
+
+// Conntrack configures flushing the conntrack entries of the managed addresses after they were (re)added, like
+// kube-proxy does for changed services. Entries created while an address was missing may blackhole the traffic to
+// it, especially of UDP and NATed flows. The zero value does not flush any entries.
+type Conntrack struct {
+	// Flush indicates whether the conntrack entries are flushed.
+	Flush bool
+	// Port is the port whose entries are flushed, all ports if zero.
+	Port uint16
+}
+
+// conntrackFilter matches the conntrack entries of the TCP and UDP flows to the address and port, including the ones
+// which were DNATed to it, e.g. from a service IP.
+type conntrackFilter struct {
+	ip   net.IP
+	port uint16
+}
+
+// MatchConntrackFlow reports whether the flow is a TCP or UDP flow to the address and port of the filter.
+func (f conntrackFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	if flow.Forward.Protocol != unix.IPPROTO_TCP && flow.Forward.Protocol != unix.IPPROTO_UDP {
+		return false
+	}
+
+	return f.matches(flow.Forward.DstIP, flow.Forward.DstPort) || f.matches(flow.Reverse.SrcIP, flow.Reverse.SrcPort)
+}
+
+func (f conntrackFilter) matches(ip net.IP, port uint16) bool {
+	return f.ip.Equal(ip) && (f.port == 0 || f.port == port)
+}
+
+// flushConntrack flushes the conntrack entries of the given address if configured. Failures are only logged and
+// counted, as the address is present anyway.
+func (m *netifManagerDefault) flushConntrack(addr *netlink.Addr) {
+	if !m.conntrack.Flush {
+		return
+	}
+
+	family := netlink.InetFamily(unix.AF_INET6)
+	if addr.IP.To4() != nil {
+		family = unix.AF_INET
+	}
+
+	address := net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(m.conntrack.Port)))
+
+	flushed, err := m.ConntrackDeleteFilters(netlink.ConntrackTable, family, conntrackFilter{ip: addr.IP, port: m.conntrack.Port})
+	if err != nil {
+		klog.Errorf("Error flushing conntrack entries of %q, flushed %d: %v", address, flushed, err)
+		metrics.ConntrackFlushes.WithLabelValues(addr.IP.String(), conntrackFlushFailure).Inc()
+		metrics.ConntrackEntriesFlushed.WithLabelValues(addr.IP.String()).Add(float64(flushed))
+
+		return
+	}
+
+	klog.Infof("Flushed %d conntrack entries of %q", flushed, address)
+	metrics.ConntrackFlushes.WithLabelValues(addr.IP.String(), conntrackFlushSuccess).Inc()
+	metrics.ConntrackEntriesFlushed.WithLabelValues(addr.IP.String()).Add(float64(flushed))
+}
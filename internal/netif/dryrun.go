@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// ActionOp names the mutating method of the Handle which would have been called.
+type ActionOp string
+
+const (
+	ActionLinkAdd   ActionOp = "LinkAdd"
+	ActionLinkSetUp ActionOp = "LinkSetUp"
+	ActionLinkDel   ActionOp = "LinkDel"
+	ActionAddrAdd   ActionOp = "AddrAdd"
+	ActionAddrDel   ActionOp = "AddrDel"
+)
+
+// Action is a change of the interfaces or addresses recorded instead of being applied.
+type Action struct {
+	Op        ActionOp `json:"op"`
+	Interface string   `json:"interface"`
+	// Type is the type of the added interface.
+	Type string `json:"type,omitempty"`
+	// Address is the added or deleted address.
+	Address string `json:"address,omitempty"`
+}
+
+// String returns the human readable description of the action.
+func (a Action) String() string {
+	switch a.Op {
+	case ActionLinkAdd:
+		return fmt.Sprintf("add %s interface %s", a.Type, a.Interface)
+	case ActionLinkSetUp:
+		return fmt.Sprintf("set interface %s up", a.Interface)
+	case ActionLinkDel:
+		return fmt.Sprintf("delete interface %s", a.Interface)
+	case ActionAddrAdd:
+		return fmt.Sprintf("add address %s to interface %s", a.Address, a.Interface)
+	case ActionAddrDel:
+		return fmt.Sprintf("delete address %s from interface %s", a.Address, a.Interface)
+	default:
+		return fmt.Sprintf("%s %s %s", a.Op, a.Interface, a.Address)
+	}
+}
+
+// DryRunHandle records the mutations of the interfaces and addresses instead of applying them, while the
+// read-only calls and subscriptions are passed to the wrapped Handle. The mutations fail like the kernel
+// would fail them for the observed state, e.g. with EEXIST for an address which is already present, so that
+// only the changes which would actually be applied are recorded.
+type DryRunHandle struct {
+	Handle
+
+	mu      sync.Mutex
+	actions []Action
+	// links are the interfaces which would have been added by name
+	links map[string]netlink.Link
+	// up are the names of the interfaces which would have been set up
+	up map[string]bool
+}
+
+var _ Handle = &DryRunHandle{}
+
+// NewDryRunHandle returns a DryRunHandle which reads the state from the given handle.
+func NewDryRunHandle(handle Handle) *DryRunHandle {
+	return &DryRunHandle{Handle: handle, links: map[string]netlink.Link{}, up: map[string]bool{}}
+}
+
+// Actions returns the recorded actions in the order they would have been applied.
+func (h *DryRunHandle) Actions() []Action {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]Action{}, h.actions...)
+}
+
+func (h *DryRunHandle) record(action Action) {
+	h.actions = append(h.actions, action)
+}
+
+// LinkByName returns the interfaces which would have been added before looking them up with the wrapped handle.
+// The interfaces which would have been set up are returned as being up.
+func (h *DryRunHandle) LinkByName(name string) (netlink.Link, error) {
+	h.mu.Lock()
+	l, ok := h.links[name]
+	up := h.up[name]
+	h.mu.Unlock()
+
+	if !ok {
+		var err error
+		if l, err = h.Handle.LinkByName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if up {
+		l.Attrs().Flags |= net.FlagUp
+	}
+
+	return l, nil
+}
+
+// AddrList returns no addresses for the interfaces which would have been added, as their addresses are
+// only recorded.
+func (h *DryRunHandle) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	if h.planned(link) {
+		return nil, nil
+	}
+
+	return h.Handle.AddrList(link, family)
+}
+
+// LinkAdd records adding the interface unless it exists.
+func (h *DryRunHandle) LinkAdd(link netlink.Link) error {
+	_, err := h.LinkByName(link.Attrs().Name)
+	if err == nil {
+		return syscall.EEXIST
+	}
+
+	var linkNotFoundErr netlink.LinkNotFoundError
+	if !errors.As(err, &linkNotFoundErr) {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.links[link.Attrs().Name] = link
+	h.record(Action{Op: ActionLinkAdd, Interface: link.Attrs().Name, Type: link.Type()})
+
+	return nil
+}
+
+// LinkSetUp records setting the interface up unless it is up already.
+func (h *DryRunHandle) LinkSetUp(link netlink.Link) error {
+	if !h.planned(link) && link.Attrs().Flags&net.FlagUp != 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.up[link.Attrs().Name] {
+		return nil
+	}
+
+	h.up[link.Attrs().Name] = true
+	h.record(Action{Op: ActionLinkSetUp, Interface: link.Attrs().Name})
+
+	return nil
+}
+
+// LinkDel records deleting the interface.
+func (h *DryRunHandle) LinkDel(link netlink.Link) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.links, link.Attrs().Name)
+	delete(h.up, link.Attrs().Name)
+	h.record(Action{Op: ActionLinkDel, Interface: link.Attrs().Name})
+
+	return nil
+}
+
+// AddrAdd records adding the address unless it is present on the interface.
+func (h *DryRunHandle) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	present, err := h.present(link, addr)
+	if err != nil {
+		return err
+	}
+
+	if present {
+		return syscall.EEXIST
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.record(Action{Op: ActionAddrAdd, Interface: link.Attrs().Name, Address: addr.IPNet.String()})
+
+	return nil
+}
+
+// AddrDel records deleting the address if it is present on the interface.
+func (h *DryRunHandle) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	present, err := h.present(link, addr)
+	if err != nil {
+		return err
+	}
+
+	if !present {
+		return syscall.EADDRNOTAVAIL
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.record(Action{Op: ActionAddrDel, Interface: link.Attrs().Name, Address: addr.IPNet.String()})
+
+	return nil
+}
+
+// ConntrackDeleteFilters does not flush any conntrack entries, as they are only flushed after an address was
+// actually added.
+func (h *DryRunHandle) ConntrackDeleteFilters(netlink.ConntrackTableType, netlink.InetFamily, ...netlink.CustomConntrackFilter) (uint, error) {
+	return 0, nil
+}
+
+// planned reports whether the interface would have been added.
+func (h *DryRunHandle) planned(link netlink.Link) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, ok := h.links[link.Attrs().Name]
+
+	return ok
+}
+
+// present reports whether the address is present on the interface, taking the recorded actions into account.
+func (h *DryRunHandle) present(link netlink.Link, addr *netlink.Addr) (bool, error) {
+	addrs, err := h.AddrList(link, 0)
+	if err != nil {
+		return false, err
+	}
+
+	present := false
+	for _, a := range addrs {
+		if addr.Equal(a) {
+			present = true
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, action := range h.actions {
+		if action.Interface != link.Attrs().Name || action.Address != addr.IPNet.String() {
+			continue
+		}
+
+		present = action.Op == ActionAddrAdd
+	}
+
+	return present, nil
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netif
+
+import (
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// DuplicatePolicyRemove removes duplicates of the managed addresses from other interfaces.
+	DuplicatePolicyRemove = "remove"
+	// DuplicatePolicyWarn keeps duplicates of the managed addresses on other interfaces and warns about them.
+	DuplicatePolicyWarn = "warn"
+	// DuplicatePolicyFail keeps duplicates of the managed addresses on other interfaces and fails ensuring the addresses.
+	DuplicatePolicyFail = "fail"
+)
+
+// Duplicates configures how duplicates of the managed addresses on other interfaces are handled. The zero value
+// removes duplicates from all other interfaces.
+type Duplicates struct {
+	// Policy is one of DuplicatePolicyRemove, DuplicatePolicyWarn or DuplicatePolicyFail. Defaults to
+	// DuplicatePolicyRemove if empty.
+	Policy string
+	// Include are the patterns of the interfaces checked for duplicates, all interfaces if empty.
+	Include []string
+	// Exclude are the patterns of the interfaces which are never checked for duplicates, e.g. kube-ipvs0.
+	Exclude []string
+	// OnDuplicate is called for every duplicate found with whether it was removed, if set.
+	OnDuplicate func(addr, devName string, removed bool)
+}
+
+// Validate validates the policy and the patterns, which use the syntax of filepath.Match.
+func (d *Duplicates) Validate() error {
+	switch d.Policy {
+	case "", DuplicatePolicyRemove, DuplicatePolicyWarn, DuplicatePolicyFail:
+	default:
+		return xerrors.Errorf("unknown duplicate policy %q, must be one of %q, %q or %q",
+			d.Policy, DuplicatePolicyRemove, DuplicatePolicyWarn, DuplicatePolicyFail)
+	}
+
+	for _, pattern := range append(append([]string{}, d.Include...), d.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return xerrors.Errorf("invalid interface pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// policy returns the policy, defaulting to DuplicatePolicyRemove.
+func (d *Duplicates) policy() string {
+	if d.Policy == "" {
+		return DuplicatePolicyRemove
+	}
+
+	return d.Policy
+}
+
+// checks reports whether the interface with the given name is checked for duplicates.
+func (d *Duplicates) checks(devName string) bool {
+	if matchesAny(d.Exclude, devName) {
+		return false
+	}
+
+	return len(d.Include) == 0 || matchesAny(d.Include, devName)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// the patterns are validated, so that errors can be ignored
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netif
+
+import (
+	"errors"
+)
+
+// Reason classifies the errors returned by EnsureIPAddress.
+type Reason string
+
+const (
+	// ReasonLinkLookup is the reason for errors getting the interface.
+	ReasonLinkLookup Reason = "link_lookup"
+	// ReasonLinkAdd is the reason for errors adding the interface or setting it up.
+	ReasonLinkAdd Reason = "link_add"
+	// ReasonLinkType is the reason for errors about an existing interface which does not match the interface mode.
+	ReasonLinkType Reason = "link_type"
+	// ReasonAddrAdd is the reason for errors adding an ip address.
+	ReasonAddrAdd Reason = "addr_add"
+	// ReasonDedupe is the reason for errors removing duplicates of an ip address from other interfaces.
+	ReasonDedupe Reason = "dedupe"
+	// ReasonDuplicate is the reason for errors about duplicates of an ip address on other interfaces,
+	// which are kept according to DuplicatePolicyFail.
+	ReasonDuplicate Reason = "duplicate"
+	// ReasonConflict is the reason for errors about repairs which are delayed due to a conflict with another agent.
+	ReasonConflict Reason = "conflict"
+	// ReasonUnknown is the reason for errors which are not classified.
+	ReasonUnknown Reason = "unknown"
+)
+
+// Error is an error classified by a Reason.
+type Error struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ReasonOf returns the Reason of the first classified error in the tree of err.
+func ReasonOf(err error) Reason {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+
+	return ReasonUnknown
+}
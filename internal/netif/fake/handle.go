@@ -0,0 +1,566 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fake provides an in-memory implementation of the netif.Handle for tests.
+package fake
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Op names a method of the Handle for injecting errors.
+type Op string
+
+const (
+	OpAddrAdd    Op = "AddrAdd"
+	OpAddrDel    Op = "AddrDel"
+	OpAddrList   Op = "AddrList"
+	OpLinkByName Op = "LinkByName"
+	OpLinkSetUp  Op = "LinkSetUp"
+	OpLinkAdd    Op = "LinkAdd"
+	OpLinkDel    Op = "LinkDel"
+	OpLinkList   Op = "LinkList"
+
+	OpConntrackDeleteFilters Op = "ConntrackDeleteFilters"
+)
+
+// Handle is an in-memory netif.Handle which models links and addresses like the kernel does:
+//   - LinkByName returns a netlink.LinkNotFoundError for unknown links.
+//   - LinkAdd returns EEXIST if a link with the same name exists.
+//   - AddrAdd returns EEXIST if the address exists on the link.
+//   - AddrDel returns EADDRNOTAVAIL if the address does not exist on the link.
+//   - Operations on unknown links return ENODEV.
+//   - ConntrackDeleteFilters deletes the conntrack flows of the family matching any of the filters.
+//
+// Address and link updates are sent to all subscribers. The zero value is not usable, use NewHandle.
+type Handle struct {
+	mu        sync.Mutex
+	links     map[int]netlink.Link
+	addrs     map[int][]netlink.Addr
+	nextIndex int
+	errors    map[Op][]injectedError
+	calls     map[Op]int
+	flows     []*netlink.ConntrackFlow
+
+	// updates are queued while holding mu and sent to the subscribers after releasing it
+	pendingAddr []netlink.AddrUpdate
+	pendingLink []netlink.LinkUpdate
+
+	subsMu   sync.Mutex
+	addrSubs []*subscription[netlink.AddrUpdate]
+	linkSubs []*subscription[netlink.LinkUpdate]
+}
+
+type injectedError struct {
+	err   error
+	times int
+}
+
+type subscription[T any] struct {
+	mu     sync.Mutex
+	ch     chan<- T
+	done   <-chan struct{}
+	closed bool
+}
+
+func (s *subscription[T]) send(update T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- update:
+	case <-s.done:
+	}
+}
+
+func (s *subscription[T]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	close(s.ch)
+}
+
+// NewHandle returns a new Handle without any links.
+func NewHandle() *Handle {
+	return &Handle{
+		links:     map[int]netlink.Link{},
+		addrs:     map[int][]netlink.Addr{},
+		nextIndex: 1,
+		errors:    map[Op][]injectedError{},
+		calls:     map[Op]int{},
+	}
+}
+
+// InjectError makes the next times calls of op fail with err without changing any state.
+// A negative times makes all subsequent calls fail. Errors injected for the same op are
+// returned in the order they were injected.
+func (h *Handle) InjectError(op Op, err error, times int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors[op] = append(h.errors[op], injectedError{err: err, times: times})
+}
+
+// ClearErrors removes all injected errors.
+func (h *Handle) ClearErrors() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors = map[Op][]injectedError{}
+}
+
+// Calls returns how often op was called, including calls which failed.
+func (h *Handle) Calls(op Op) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.calls[op]
+}
+
+// AddLink adds the link with the given addresses, e.g. to model existing interfaces. The link is set up
+// if its flags contain net.FlagUp. No updates are sent to the subscribers.
+func (h *Handle) AddLink(link netlink.Link, addrs ...netlink.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.addLink(link)
+	for _, addr := range addrs {
+		addr.LinkIndex = link.Attrs().Index
+		h.addrs[link.Attrs().Index] = append(h.addrs[link.Attrs().Index], addr)
+	}
+}
+
+// Link returns the link with the given name or nil if it does not exist.
+func (h *Handle) Link(name string) netlink.Link {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.linkByName(name)
+}
+
+// Addrs returns the addresses of the link with the given name.
+func (h *Handle) Addrs(name string) []netlink.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l := h.linkByName(name)
+	if l == nil {
+		return nil
+	}
+
+	return append([]netlink.Addr{}, h.addrs[l.Attrs().Index]...)
+}
+
+// call records the call of op and returns the injected error if there is any.
+func (h *Handle) call(op Op) error {
+	h.calls[op]++
+
+	injected := h.errors[op]
+	if len(injected) == 0 {
+		return nil
+	}
+
+	err := injected[0].err
+	if injected[0].times > 0 {
+		injected[0].times--
+		if injected[0].times == 0 {
+			h.errors[op] = injected[1:]
+		}
+	}
+
+	return err
+}
+
+func (h *Handle) addLink(link netlink.Link) {
+	attrs := link.Attrs()
+	if attrs.Index == 0 {
+		attrs.Index = h.nextIndex
+	}
+	if attrs.Index >= h.nextIndex {
+		h.nextIndex = attrs.Index + 1
+	}
+
+	h.links[attrs.Index] = link
+}
+
+func (h *Handle) linkByName(name string) netlink.Link {
+	for _, l := range h.links {
+		if l.Attrs().Name == name {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the stored link for the given link, which is identified by its index or name.
+func (h *Handle) lookup(link netlink.Link) (netlink.Link, error) {
+	if l, ok := h.links[link.Attrs().Index]; ok && link.Attrs().Index != 0 {
+		return l, nil
+	}
+
+	if l := h.linkByName(link.Attrs().Name); l != nil {
+		return l, nil
+	}
+
+	return nil, syscall.ENODEV
+}
+
+func (h *Handle) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	defer h.flush()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpAddrAdd); err != nil {
+		return err
+	}
+
+	l, err := h.lookup(link)
+	if err != nil {
+		return err
+	}
+
+	index := l.Attrs().Index
+	for _, a := range h.addrs[index] {
+		if a.Equal(*addr) {
+			return syscall.EEXIST
+		}
+	}
+
+	added := *addr
+	added.LinkIndex = index
+	h.addrs[index] = append(h.addrs[index], added)
+	h.queueAddrUpdate(added, true)
+
+	return nil
+}
+
+func (h *Handle) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	defer h.flush()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpAddrDel); err != nil {
+		return err
+	}
+
+	l, err := h.lookup(link)
+	if err != nil {
+		return err
+	}
+
+	index := l.Attrs().Index
+	for i, a := range h.addrs[index] {
+		if a.Equal(*addr) {
+			h.addrs[index] = append(h.addrs[index][:i:i], h.addrs[index][i+1:]...)
+			h.queueAddrUpdate(a, false)
+
+			return nil
+		}
+	}
+
+	return syscall.EADDRNOTAVAIL
+}
+
+func (h *Handle) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpAddrList); err != nil {
+		return nil, err
+	}
+
+	var addrs []*netlink.Addr
+	if link == nil {
+		for _, index := range h.sortedIndices() {
+			for i := range h.addrs[index] {
+				addrs = append(addrs, &h.addrs[index][i])
+			}
+		}
+	} else {
+		l, err := h.lookup(link)
+		if err != nil {
+			return nil, err
+		}
+		for i := range h.addrs[l.Attrs().Index] {
+			addrs = append(addrs, &h.addrs[l.Attrs().Index][i])
+		}
+	}
+
+	var result []netlink.Addr
+	for _, a := range addrs {
+		if matchesFamily(a, family) {
+			result = append(result, *a)
+		}
+	}
+
+	return result, nil
+}
+
+func matchesFamily(addr *netlink.Addr, family int) bool {
+	switch family {
+	case netlink.FAMILY_V4:
+		return addr.IP.To4() != nil
+	case netlink.FAMILY_V6:
+		return addr.IP.To4() == nil
+	default:
+		return true
+	}
+}
+
+func (h *Handle) LinkByName(name string) (netlink.Link, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpLinkByName); err != nil {
+		return nil, err
+	}
+
+	l := h.linkByName(name)
+	if l == nil {
+		return nil, netlink.LinkNotFoundError{}
+	}
+
+	return l, nil
+}
+
+func (h *Handle) LinkSetUp(link netlink.Link) error {
+	defer h.flush()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpLinkSetUp); err != nil {
+		return err
+	}
+
+	l, err := h.lookup(link)
+	if err != nil {
+		return err
+	}
+
+	l.Attrs().Flags |= net.FlagUp
+	l.Attrs().RawFlags |= unix.IFF_UP
+	l.Attrs().OperState = netlink.OperUp
+	// the kernel does not modify the passed link, but the index is resolved by netlink
+	link.Attrs().Index = l.Attrs().Index
+	h.queueLinkUpdate(l, unix.RTM_NEWLINK)
+
+	return nil
+}
+
+func (h *Handle) LinkAdd(link netlink.Link) error {
+	defer h.flush()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpLinkAdd); err != nil {
+		return err
+	}
+
+	if h.linkByName(link.Attrs().Name) != nil {
+		return syscall.EEXIST
+	}
+
+	h.addLink(link)
+	h.queueLinkUpdate(link, unix.RTM_NEWLINK)
+
+	return nil
+}
+
+func (h *Handle) LinkDel(link netlink.Link) error {
+	defer h.flush()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpLinkDel); err != nil {
+		return err
+	}
+
+	l, err := h.lookup(link)
+	if err != nil {
+		return err
+	}
+
+	index := l.Attrs().Index
+	for _, a := range h.addrs[index] {
+		h.queueAddrUpdate(a, false)
+	}
+	delete(h.addrs, index)
+	delete(h.links, index)
+	h.queueLinkUpdate(l, unix.RTM_DELLINK)
+
+	return nil
+}
+
+func (h *Handle) LinkList() ([]netlink.Link, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpLinkList); err != nil {
+		return nil, err
+	}
+
+	links := make([]netlink.Link, 0, len(h.links))
+	for _, index := range h.sortedIndices() {
+		links = append(links, h.links[index])
+	}
+
+	return links, nil
+}
+
+// AddConntrackFlow adds the flow to the conntrack table, e.g. to model connections tracked before an address was added.
+func (h *Handle) AddConntrackFlow(flow *netlink.ConntrackFlow) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.flows = append(h.flows, flow)
+}
+
+// ConntrackFlows returns the flows of the conntrack table.
+func (h *Handle) ConntrackFlows() []*netlink.ConntrackFlow {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]*netlink.ConntrackFlow(nil), h.flows...)
+}
+
+func (h *Handle) ConntrackDeleteFilters(_ netlink.ConntrackTableType, family netlink.InetFamily, filters ...netlink.CustomConntrackFilter) (uint, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.call(OpConntrackDeleteFilters); err != nil {
+		return 0, err
+	}
+
+	var (
+		kept    []*netlink.ConntrackFlow
+		deleted uint
+	)
+	for _, flow := range h.flows {
+		if flow.FamilyType == uint8(family) && slices.ContainsFunc(filters, func(f netlink.CustomConntrackFilter) bool {
+			return f.MatchConntrackFlow(flow)
+		}) {
+			deleted++
+			continue
+		}
+		kept = append(kept, flow)
+	}
+	h.flows = kept
+
+	return deleted, nil
+}
+
+func (h *Handle) sortedIndices() []int {
+	indices := make([]int, 0, len(h.links))
+	for index := range h.links {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	return indices
+}
+
+func (h *Handle) queueAddrUpdate(addr netlink.Addr, added bool) {
+	h.pendingAddr = append(h.pendingAddr, netlink.AddrUpdate{
+		LinkAddress: *addr.IPNet,
+		LinkIndex:   addr.LinkIndex,
+		Flags:       addr.Flags,
+		Scope:       addr.Scope,
+		PreferedLft: addr.PreferedLft,
+		ValidLft:    addr.ValidLft,
+		NewAddr:     added,
+	})
+}
+
+func (h *Handle) queueLinkUpdate(link netlink.Link, msgType uint16) {
+	h.pendingLink = append(h.pendingLink, netlink.LinkUpdate{
+		Header: unix.NlMsghdr{Type: msgType},
+		Link:   link,
+	})
+}
+
+// flush sends the queued updates to the subscribers. The subscriptions lock is held while sending,
+// so updates are delivered in order.
+func (h *Handle) flush() {
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+
+	h.mu.Lock()
+	addrUpdates, linkUpdates := h.pendingAddr, h.pendingLink
+	h.pendingAddr, h.pendingLink = nil, nil
+	h.mu.Unlock()
+
+	for _, u := range addrUpdates {
+		for _, sub := range h.addrSubs {
+			sub.send(u)
+		}
+	}
+	for _, u := range linkUpdates {
+		for _, sub := range h.linkSubs {
+			sub.send(u)
+		}
+	}
+}
+
+func (h *Handle) AddrSubscribe(ch chan<- netlink.AddrUpdate, done <-chan struct{}) error {
+	sub := &subscription[netlink.AddrUpdate]{ch: ch, done: done}
+
+	h.subsMu.Lock()
+	h.addrSubs = append(h.addrSubs, sub)
+	h.subsMu.Unlock()
+
+	go func() {
+		<-done
+		h.subsMu.Lock()
+		h.addrSubs = remove(h.addrSubs, sub)
+		h.subsMu.Unlock()
+		sub.close()
+	}()
+
+	return nil
+}
+
+func (h *Handle) LinkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error {
+	sub := &subscription[netlink.LinkUpdate]{ch: ch, done: done}
+
+	h.subsMu.Lock()
+	h.linkSubs = append(h.linkSubs, sub)
+	h.subsMu.Unlock()
+
+	go func() {
+		<-done
+		h.subsMu.Lock()
+		h.linkSubs = remove(h.linkSubs, sub)
+		h.subsMu.Unlock()
+		sub.close()
+	}()
+
+	return nil
+}
+
+func remove[T any](subs []*subscription[T], sub *subscription[T]) []*subscription[T] {
+	for i, s := range subs {
+		if s == sub {
+			return append(subs[:i:i], subs[i+1:]...)
+		}
+	}
+
+	return subs
+}
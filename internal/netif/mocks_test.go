@@ -83,6 +83,40 @@ func (mr *MockHandleMockRecorder) AddrList(link, family any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddrList", reflect.TypeOf((*MockHandle)(nil).AddrList), link, family)
 }
 
+// AddrSubscribe mocks base method.
+func (m *MockHandle) AddrSubscribe(ch chan<- netlink.AddrUpdate, done <-chan struct{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddrSubscribe", ch, done)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddrSubscribe indicates an expected call of AddrSubscribe.
+func (mr *MockHandleMockRecorder) AddrSubscribe(ch, done any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddrSubscribe", reflect.TypeOf((*MockHandle)(nil).AddrSubscribe), ch, done)
+}
+
+// ConntrackDeleteFilters mocks base method.
+func (m *MockHandle) ConntrackDeleteFilters(table netlink.ConntrackTableType, family netlink.InetFamily, filters ...netlink.CustomConntrackFilter) (uint, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{table, family}
+	for _, a := range filters {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ConntrackDeleteFilters", varargs...)
+	ret0, _ := ret[0].(uint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConntrackDeleteFilters indicates an expected call of ConntrackDeleteFilters.
+func (mr *MockHandleMockRecorder) ConntrackDeleteFilters(table, family any, filters ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{table, family}, filters...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConntrackDeleteFilters", reflect.TypeOf((*MockHandle)(nil).ConntrackDeleteFilters), varargs...)
+}
+
 // LinkAdd mocks base method.
 func (m *MockHandle) LinkAdd(arg0 netlink.Link) error {
 	m.ctrl.T.Helper()
@@ -155,6 +189,20 @@ func (mr *MockHandleMockRecorder) LinkSetUp(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkSetUp", reflect.TypeOf((*MockHandle)(nil).LinkSetUp), arg0)
 }
 
+// LinkSubscribe mocks base method.
+func (m *MockHandle) LinkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LinkSubscribe", ch, done)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LinkSubscribe indicates an expected call of LinkSubscribe.
+func (mr *MockHandleMockRecorder) LinkSubscribe(ch, done any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkSubscribe", reflect.TypeOf((*MockHandle)(nil).LinkSubscribe), ch, done)
+}
+
 // MockManager is a mock of Manager interface.
 type MockManager struct {
 	ctrl     *gomock.Controller
@@ -193,6 +241,35 @@ func (mr *MockManagerMockRecorder) CleanupDevice() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CleanupDevice", reflect.TypeOf((*MockManager)(nil).CleanupDevice))
 }
 
+// Conflict mocks base method.
+func (m *MockManager) Conflict() *ConflictStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Conflict")
+	ret0, _ := ret[0].(*ConflictStatus)
+	return ret0
+}
+
+// Conflict indicates an expected call of Conflict.
+func (mr *MockManagerMockRecorder) Conflict() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Conflict", reflect.TypeOf((*MockManager)(nil).Conflict))
+}
+
+// DeviceOwned mocks base method.
+func (m *MockManager) DeviceOwned() (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeviceOwned")
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeviceOwned indicates an expected call of DeviceOwned.
+func (mr *MockManagerMockRecorder) DeviceOwned() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceOwned", reflect.TypeOf((*MockManager)(nil).DeviceOwned))
+}
+
 // EnsureIPAddress mocks base method.
 func (m *MockManager) EnsureIPAddress() error {
 	m.ctrl.T.Helper()
@@ -220,3 +297,33 @@ func (mr *MockManagerMockRecorder) RemoveIPAddress() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveIPAddress", reflect.TypeOf((*MockManager)(nil).RemoveIPAddress))
 }
+
+// Status mocks base method.
+func (m *MockManager) Status() (*Status, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].(*Status)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Status indicates an expected call of Status.
+func (mr *MockManagerMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockManager)(nil).Status))
+}
+
+// Watch mocks base method.
+func (m *MockManager) Watch(done <-chan struct{}) (<-chan struct{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Watch", done)
+	ret0, _ := ret[0].(<-chan struct{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Watch indicates an expected call of Watch.
+func (mr *MockManagerMockRecorder) Watch(done any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Watch", reflect.TypeOf((*MockManager)(nil).Watch), done)
+}
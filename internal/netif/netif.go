@@ -6,11 +6,22 @@ package netif
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/vishvananda/netlink"
+	vnetns "github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 	"golang.org/x/xerrors"
 	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+	"github.com/gardener/apiserver-proxy/internal/netns"
 )
 
 type Handle interface {
@@ -22,6 +33,9 @@ type Handle interface {
 	LinkAdd(netlink.Link) error
 	LinkDel(netlink.Link) error
 	LinkList() ([]netlink.Link, error)
+	AddrSubscribe(ch chan<- netlink.AddrUpdate, done <-chan struct{}) error
+	LinkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error
+	ConntrackDeleteFilters(table netlink.ConntrackTableType, family netlink.InetFamily, filters ...netlink.CustomConntrackFilter) (uint, error)
 }
 
 // Manager ensures that the dummy device is created or removed.
@@ -29,132 +43,567 @@ type Manager interface {
 	EnsureIPAddress() error
 	RemoveIPAddress() error
 	CleanupDevice() error
+	// DeviceOwned reports whether the interface exists and was created by the sidecar.
+	DeviceOwned() (bool, error)
+	// Status returns the observed state of the interface and the managed addresses.
+	Status() (*Status, error)
+	// Conflict returns the state of a conflict with another agent which keeps changing the managed addresses,
+	// or nil if there is none.
+	Conflict() *ConflictStatus
+	// Watch subscribes to address and link changes. The returned channel receives a notification
+	// whenever the managed address or device changed and is closed once done is closed or the
+	// subscription failed.
+	Watch(done <-chan struct{}) (<-chan struct{}, error)
+}
+
+const (
+	// InterfaceModeCreateDummy creates a dummy interface owned by the sidecar if the interface does not exist.
+	InterfaceModeCreateDummy = "create-dummy"
+	// InterfaceModeExisting uses an existing interface of any type and fails if it does not exist.
+	InterfaceModeExisting = "existing"
+	// InterfaceModeLoopback uses the loopback interface.
+	InterfaceModeLoopback = "loopback"
+)
+
+// interfaceMode returns the given interface mode or, if it is empty, the one derived from the name of
+// the interface, i.e. InterfaceModeLoopback for lo and InterfaceModeCreateDummy otherwise.
+func interfaceMode(mode, devName string) string {
+	switch {
+	case mode != "":
+		return mode
+	case devName == "lo":
+		return InterfaceModeLoopback
+	default:
+		return InterfaceModeCreateDummy
+	}
+}
+
+// LinkAlias is set as alias of the interfaces created by the sidecar to mark them as owned by it.
+const LinkAlias = "apiserver-proxy-sidecar"
+
+// Status is the observed state of the interface and the managed addresses.
+type Status struct {
+	// Interface is the name of the interface.
+	Interface string `json:"interface"`
+	// Exists reports whether the interface exists.
+	Exists bool `json:"exists"`
+	// Up reports whether the interface is administratively up.
+	Up bool `json:"up"`
+	// Owned reports whether the interface was created by the sidecar.
+	Owned bool `json:"owned"`
+	// DuplicatePolicy is how duplicates of the addresses on other interfaces are handled.
+	DuplicatePolicy string `json:"duplicatePolicy"`
+	// Addresses is the state of the managed addresses.
+	Addresses []AddressStatus `json:"addresses"`
+}
+
+// AddressStatus is the observed state of a managed address.
+type AddressStatus struct {
+	// Address is the managed address in CIDR notation.
+	Address string `json:"address"`
+	// Present reports whether the address is present on the interface.
+	Present bool `json:"present"`
+	// Duplicates are the names of other interfaces the address is present on.
+	Duplicates []string `json:"duplicates,omitempty"`
+}
+
+// Healthy reports whether the interface is up and all addresses are present on it only,
+// unless duplicates are tolerated by DuplicatePolicyWarn.
+func (s *Status) Healthy() bool {
+	if !s.Exists || !s.Up {
+		return false
+	}
+
+	for _, addr := range s.Addresses {
+		if !addr.Present || (len(addr.Duplicates) > 0 && s.DuplicatePolicy != DuplicatePolicyWarn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// updateBufferSize is the capacity of the channels receiving netlink updates.
+const updateBufferSize = 64
+
+// netlinkHandle extends netlink.Handle with the subscription functions of the netlink package.
+type netlinkHandle struct {
+	*netlink.Handle
+	// ns is the network namespace of the subscriptions, the current one if nil.
+	ns *vnetns.NsHandle
+}
+
+func (h *netlinkHandle) AddrSubscribe(ch chan<- netlink.AddrUpdate, done <-chan struct{}) error {
+	return netlink.AddrSubscribeWithOptions(ch, done, netlink.AddrSubscribeOptions{
+		Namespace: h.ns,
+		ErrorCallback: func(err error) {
+			if !closed(done) {
+				klog.Warningf("Address subscription error: %v", err)
+			}
+		},
+	})
+}
+
+func (h *netlinkHandle) LinkSubscribe(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error {
+	return netlink.LinkSubscribeWithOptions(ch, done, netlink.LinkSubscribeOptions{
+		Namespace: h.ns,
+		ErrorCallback: func(err error) {
+			if !closed(done) {
+				klog.Warningf("Link subscription error: %v", err)
+			}
+		},
+	})
+}
+
+// closed reports whether the given channel is closed, e.g. because the subscription was closed on purpose.
+func closed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Options configures the handling of other agents changing the managed addresses. The zero value removes
+// duplicates from all other interfaces and does not detect conflicts.
+type Options struct {
+	// Mode is how the interface is provided, one of the InterfaceMode constants. If empty, it is derived
+	// from the name of the interface.
+	Mode string
+	// Duplicates configures how duplicates of the addresses on other interfaces are handled.
+	Duplicates Duplicates
+	// Conflicts configures the detection of conflicts with other agents.
+	Conflicts Conflicts
+	// Conntrack configures flushing the conntrack entries of the addresses after they were (re)added.
+	Conntrack Conntrack
+	// Namespace is the network namespace the sysctls of the interface are read in, the current one if nil.
+	Namespace *netns.Namespace
+}
+
+// Validate validates the interface mode and the handling of duplicates and conflicts.
+func (o *Options) Validate() error {
+	switch o.Mode {
+	case "", InterfaceModeCreateDummy, InterfaceModeExisting, InterfaceModeLoopback:
+	default:
+		return xerrors.Errorf("unknown interface mode %q, must be one of %q, %q or %q",
+			o.Mode, InterfaceModeCreateDummy, InterfaceModeExisting, InterfaceModeLoopback)
+	}
+
+	if err := o.Duplicates.Validate(); err != nil {
+		return err
+	}
+
+	return o.Conflicts.Validate()
 }
 
 // netifManagerDefault is the default implementation handling creating
 // and removing of the dummy interface.
 type netifManagerDefault struct {
 	Handle
-	addr    *netlink.Addr
-	devName string
+	addrs      []*netlink.Addr
+	devName    string
+	mode       string
+	duplicates Duplicates
+	conflicts  *conflictTracker
+	conntrack  Conntrack
+	// established reports whether the addresses were ensured successfully before, so that further changes are repairs.
+	established bool
+	// ipv6Disabled reports whether IPv6 is disabled for the given device.
+	ipv6Disabled func(devName string) (bool, error)
+}
+
+// NewNetifManager returns a new instance of NetifManager with the ip addresses set to the provided values
+// These ip addresses will be bound to any devices created by this instance in the network namespace of the options.
+// IPv6 addresses are added without duplicate address detection, so they are usable right away.
+func NewNetifManager(addrs []*netlink.Addr, devName string, opts Options) (Manager, error) {
+	handle, err := NewHandleIn(opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewNetifManagerWithHandle(handle, addrs, devName, opts), nil
+}
+
+// NewHandle returns a Handle managing the devices and addresses of the current network namespace.
+func NewHandle() Handle {
+	return &netlinkHandle{Handle: &netlink.Handle{}}
+}
+
+// NewHandleIn returns a Handle managing the devices and addresses of the given network namespace,
+// or of the current one if ns is nil.
+func NewHandleIn(ns *netns.Namespace) (Handle, error) {
+	if ns == nil {
+		return NewHandle(), nil
+	}
+
+	nsHandle := ns.Handle()
+	h, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		return nil, xerrors.Errorf("could not create netlink handle in network namespace %q: %v", ns, err)
+	}
+
+	return &netlinkHandle{Handle: h, ns: &nsHandle}, nil
 }
 
-// NewNetifManager returns a new instance of NetifManager with the ip address set to the provided values
-// These ip addresses will be bound to any devices created by this instance.
-func NewNetifManager(addr *netlink.Addr, devName string) Manager {
+// NewNetifManagerWithHandle returns a new instance of NetifManager like NewNetifManager, which uses
+// the given Handle to manage the devices and addresses, e.g. the in-memory Handle of the fake package.
+// Duplicates of the addresses on other interfaces and conflicts are handled as configured by the options.
+func NewNetifManagerWithHandle(handle Handle, addrs []*netlink.Addr, devName string, opts Options) Manager {
+	managed := make([]*netlink.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		addr := *addr
+		if addr.IP.To4() == nil {
+			addr.Flags |= unix.IFA_F_NODAD
+		}
+		managed = append(managed, &addr)
+	}
 
 	return &netifManagerDefault{
-		&netlink.Handle{},
-		addr,
-		devName,
+		Handle:     handle,
+		addrs:      managed,
+		devName:    devName,
+		mode:       interfaceMode(opts.Mode, devName),
+		duplicates: opts.Duplicates,
+		conflicts:  newConflictTracker(opts.Conflicts, devName),
+		conntrack:  opts.Conntrack,
+		ipv6Disabled: func(devName string) (disabled bool, err error) {
+			nsErr := opts.Namespace.Do(func() error {
+				disabled, err = ipv6DisabledSysctl(devName)
+				return nil
+			})
+			if nsErr != nil {
+				return false, nsErr
+			}
+
+			return disabled, err
+		},
 	}
 }
 
+// ipv6DisabledSysctl reads the disable_ipv6 sysctl of the given device in the network namespace of the calling thread. IPv6 is considered
+// disabled if the sysctl does not exist, because the kernel has no IPv6 support then.
+func ipv6DisabledSysctl(devName string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join("/proc/sys/net/ipv6/conf", devName, "disable_ipv6"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
 // EnsureIPAddress makes sure to have the device running as desired.
 func (m *netifManagerDefault) EnsureIPAddress() error {
+	m.conflicts.update()
+
 	klog.V(4).Infof("Getting interface %q", m.devName)
 
 	l, err := m.LinkByName(m.devName)
 	if err != nil {
 		var linkNotFoundErr netlink.LinkNotFoundError
 		if !errors.As(err, &linkNotFoundErr) {
-			return xerrors.Errorf("could not get interface %s:\n%v", m.devName, err)
+			return &Error{ReasonLinkLookup, xerrors.Errorf("could not get interface %s:\n%v", m.devName, err)}
+		}
+
+		if m.mode != InterfaceModeCreateDummy {
+			return &Error{ReasonLinkLookup, xerrors.Errorf("could not get interface %s, it must exist with interface mode %s", m.devName, m.mode)}
+		}
+
+		if wait := m.conflicts.backoff(); wait > 0 {
+			m.conflicts.skipped()
+			return &Error{ReasonConflict, xerrors.Errorf("could not add dummy interface %s, delaying the repair for %v due to a conflict", m.devName, wait)}
 		}
 
 		dummyLink := &netlink.Dummy{
 			LinkAttrs: netlink.LinkAttrs{
-				Name: m.devName,
+				Name:  m.devName,
+				Alias: LinkAlias,
 			},
 		}
 		err = m.LinkAdd(dummyLink)
 		if err != nil {
-			return xerrors.Errorf("could not add dummy interface %s:\n%v", m.devName, err)
+			return &Error{ReasonLinkAdd, xerrors.Errorf("could not add dummy interface %s:\n%v", m.devName, err)}
 		}
 
 		err = m.LinkSetUp(dummyLink)
 		if err != nil {
-			return xerrors.Errorf("could not set interface %s up:\n%v", m.devName, err)
+			return &Error{ReasonLinkAdd, xerrors.Errorf("could not set interface %s up:\n%v", m.devName, err)}
+		}
+
+		if m.established {
+			m.conflicts.record(RepairLink)
 		}
 
 		l = dummyLink
+	} else if err := m.ensureLink(l); err != nil {
+		return err
+	}
+
+	klog.V(6).Infof("Got interface %+v", l)
+
+	var errs []error
+	for _, addr := range m.addrs {
+		if err := m.ensureAddr(l, addr); err != nil {
+			metrics.AddressPresent.WithLabelValues(addr.IP.String(), m.devName).Set(0)
+
+			var classified *Error
+			if !errors.As(err, &classified) {
+				err = &Error{ReasonAddrAdd, err}
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		metrics.AddressPresent.WithLabelValues(addr.IP.String(), m.devName).Set(1)
+	}
+
+	// duplicates are only removed after adding the addresses, so that they stay available when moving them to
+	// another interface, but also if adding them failed
+	if err := m.ensureNoDuplicates(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 
-	err = m.deduplicateIPAddress()
+	m.established = true
+
+	return nil
+}
+
+// ensureNoDuplicates removes the duplicates of the addresses on other interfaces as configured by the duplicate
+// policy and returns an error if duplicates are left.
+func (m *netifManagerDefault) ensureNoDuplicates() error {
+	kept, delayed, err := m.deduplicateIPAddress()
 	if err != nil {
-		return xerrors.Errorf("could not deduplicate IP address:\n%v", err)
+		return &Error{ReasonDedupe, xerrors.Errorf("could not deduplicate IP address:\n%v", err)}
 	}
 
-	klog.V(6).Infof("Got interface %+v", l)
+	if len(delayed) > 0 {
+		return &Error{ReasonConflict, xerrors.Errorf("could not remove duplicate addresses %s, delaying the repair for %v due to a conflict",
+			strings.Join(delayed, ", "), m.conflicts.backoff())}
+	}
+
+	if len(kept) > 0 && m.duplicates.policy() == DuplicatePolicyFail {
+		return &Error{ReasonDuplicate, xerrors.Errorf("found duplicate addresses %s", strings.Join(kept, ", "))}
+	}
+
+	return nil
+}
+
+// ensureLink validates that the existing interface has the type expected by the interface mode and sets it up
+// again if somebody else set it down.
+func (m *netifManagerDefault) ensureLink(l netlink.Link) error {
+	switch {
+	case m.mode == InterfaceModeCreateDummy && l.Type() != "dummy":
+		return &Error{ReasonLinkType, xerrors.Errorf("interface %s has type %s, but must be a dummy interface with interface mode %s",
+			m.devName, l.Type(), m.mode)}
+	case m.mode == InterfaceModeLoopback && l.Attrs().Flags&net.FlagLoopback == 0:
+		return &Error{ReasonLinkType, xerrors.Errorf("interface %s must be a loopback interface with interface mode %s", m.devName, m.mode)}
+	}
+
+	if l.Attrs().Flags&net.FlagUp != 0 {
+		return nil
+	}
+
+	if wait := m.conflicts.backoff(); wait > 0 {
+		m.conflicts.skipped()
+		return &Error{ReasonConflict, xerrors.Errorf("could not set interface %s up, delaying the repair for %v due to a conflict", m.devName, wait)}
+	}
+
+	klog.Warningf("Interface %q is down. Setting it up", m.devName)
+
+	if err := m.LinkSetUp(l); err != nil {
+		return &Error{ReasonLinkAdd, xerrors.Errorf("could not set interface %s up:\n%v", m.devName, err)}
+	}
+
+	if m.established {
+		m.conflicts.record(RepairLink)
+	}
+
+	return nil
+}
+
+// ensureAddr adds the given address to the link if it is not present yet.
+func (m *netifManagerDefault) ensureAddr(l netlink.Link, addr *netlink.Addr) error {
+	if addr.IP.To4() == nil {
+		disabled, err := m.ipv6Disabled(m.devName)
+		if err != nil {
+			return xerrors.Errorf("could not check if IPv6 is disabled for interface %s: %v", m.devName, err)
+		}
+
+		if disabled {
+			return xerrors.Errorf("could not add IPv6 address %q, IPv6 is disabled for interface %s", addr.String(), m.devName)
+		}
+	}
+
+	if wait := m.conflicts.backoff(); wait > 0 {
+		// only observe whether the address is present, as adding it again would continue the conflict
+		present, err := m.hasAddr(l, addr)
+		if err != nil {
+			return err
+		}
+
+		if !present {
+			m.conflicts.skipped()
+			return &Error{ReasonConflict, xerrors.Errorf("could not add ip address %q, delaying the repair for %v due to a conflict", addr.String(), wait)}
+		}
 
-	if err := m.AddrAdd(l, m.addr); err != nil {
+		return nil
+	}
+
+	if err := m.AddrAdd(l, addr); err != nil {
 		if os.IsExist(err) {
-			klog.V(4).Infof("Address %q already exists. Skipping", m.addr.String())
+			klog.V(4).Infof("Address %q already exists. Skipping", addr.String())
 			return nil
 		}
 
-		return xerrors.Errorf("could not add IPV4 addresses %v", err)
+		return xerrors.Errorf("could not add ip address %q: %v", addr.String(), err)
 	}
 
-	klog.Infof("Successfully added %q to %q", m.addr.String(), m.devName)
+	klog.Infof("Successfully added %q to %q", addr.String(), m.devName)
+
+	// connections tracked while the address was missing would keep failing otherwise
+	m.flushConntrack(addr)
+
+	if m.established {
+		m.conflicts.record(RepairAddress)
+	}
 
 	return nil
 }
 
-// deduplicateIPAddress removes duplicates of the given IP address on other devices
-func (m *netifManagerDefault) deduplicateIPAddress() error {
-	klog.V(4).Infof("Deduplicating address %q", m.addr.String())
+// hasAddr reports whether the given address is present on the link.
+func (m *netifManagerDefault) hasAddr(l netlink.Link, addr *netlink.Addr) (bool, error) {
+	addrs, err := m.AddrList(l, 0)
+	if err != nil {
+		return false, xerrors.Errorf("could not list addresses for interface %s: %v", m.devName, err)
+	}
+
+	for _, a := range addrs {
+		if addr.Equal(a) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// deduplicateIPAddress handles duplicates of the managed IP addresses on other devices according to the policy
+// and returns the duplicates which were kept and those whose removal was delayed due to a conflict
+func (m *netifManagerDefault) deduplicateIPAddress() ([]string, []string, error) {
+	klog.V(4).Infof("Deduplicating addresses %v", m.addrs)
 	links, err := m.LinkList()
 	if err != nil {
-		return xerrors.Errorf("could not list interfaces: %v", err)
+		return nil, nil, xerrors.Errorf("could not list interfaces: %v", err)
 	}
+	policy := m.duplicates.policy()
+	var kept, delayed []string
 	for _, l := range links {
-		if l.Attrs().Name == m.devName {
-			// skip own link
+		if l.Attrs().Name == m.devName || !m.duplicates.checks(l.Attrs().Name) {
+			// skip own and ignored links
 			continue
 		}
 		addrs, err := m.AddrList(l, 0)
 		if err != nil {
-			return xerrors.Errorf("could not list addresses for interface %s: %v", l.Attrs().Name, err)
+			return nil, nil, xerrors.Errorf("could not list addresses for interface %s: %v", l.Attrs().Name, err)
 		}
 		for _, addr := range addrs {
-			if addr.Equal(*m.addr) {
-				klog.Infof("Found duplicate address %q on interface %q. Removing it.", m.addr.String(), l.Attrs().Name)
-				if err := m.AddrDel(l, &addr); err != nil {
-					return xerrors.Errorf("could not delete duplicate address %q from interface %q: %v", m.addr.String(), l.Attrs().Name, err)
-				}
+			if !m.isManaged(addr.IPNet) {
+				continue
+			}
+			duplicate := fmt.Sprintf("%q on interface %q", addr.IPNet.String(), l.Attrs().Name)
+			if policy != DuplicatePolicyRemove {
+				klog.Warningf("Found duplicate address %q on interface %q. Keeping it due to duplicate policy %q.", addr.String(), l.Attrs().Name, policy)
+				metrics.DuplicateAddressesFound.WithLabelValues(l.Attrs().Name).Inc()
+				m.onDuplicate(addr.IPNet.String(), l.Attrs().Name, false)
+				kept = append(kept, duplicate)
+				continue
+			}
+			if wait := m.conflicts.backoff(); wait > 0 {
+				klog.Warningf("Found duplicate address %q on interface %q. Delaying its removal for %v due to a conflict.", addr.String(), l.Attrs().Name, wait)
+				m.conflicts.skipped()
+				delayed = append(delayed, duplicate)
+				continue
+			}
+			klog.Infof("Found duplicate address %q on interface %q. Removing it.", addr.String(), l.Attrs().Name)
+			if err := m.AddrDel(l, &addr); err != nil {
+				return nil, nil, xerrors.Errorf("could not delete duplicate address %q from interface %q: %v", addr.String(), l.Attrs().Name, err)
+			}
+			metrics.DuplicateAddressesRemoved.WithLabelValues(l.Attrs().Name).Inc()
+			m.onDuplicate(addr.IPNet.String(), l.Attrs().Name, true)
+			if m.established {
+				m.conflicts.record(RepairDuplicate)
 			}
 		}
 	}
-	return nil
+	return kept, delayed, nil
+}
+
+// onDuplicate calls the OnDuplicate function of the duplicate handling if set.
+func (m *netifManagerDefault) onDuplicate(addr, devName string, removed bool) {
+	if m.duplicates.OnDuplicate != nil {
+		m.duplicates.OnDuplicate(addr, devName, removed)
+	}
+}
+
+// isManaged reports whether the given ip network is one of the managed addresses.
+func (m *netifManagerDefault) isManaged(ipNet *net.IPNet) bool {
+	for _, addr := range m.addrs {
+		if addr.Equal(netlink.Addr{IPNet: ipNet}) {
+			return true
+		}
+	}
+
+	return false
 }
 
-// RemoveIPAddress removes the IP address from the given interface
+// RemoveIPAddress removes the IP addresses from the given interface
 func (m *netifManagerDefault) RemoveIPAddress() error {
 	klog.V(4).Infof("Getting interface %q", m.devName)
 
 	l, err := m.LinkByName(m.devName)
 	if err != nil {
+		var linkNotFoundErr netlink.LinkNotFoundError
+		if errors.As(err, &linkNotFoundErr) {
+			klog.V(4).Infof("Interface %q does not exist. Skipping", m.devName)
+			return nil
+		}
+
 		return xerrors.Errorf("could not get interface %s:\n%v", m.devName, err)
 	}
 
 	klog.V(6).Infof("Got interface %+v", l)
 
-	if err := m.AddrDel(l, m.addr); err != nil {
-		if os.IsNotExist(err) {
-			klog.V(4).Infof("Address %q already removed. Skipping", m.addr.String())
-			return nil
+	for _, addr := range m.addrs {
+		if err := m.AddrDel(l, addr); err != nil {
+			// the kernel reports EADDRNOTAVAIL for addresses which do not exist on the interface
+			if os.IsNotExist(err) || errors.Is(err, syscall.EADDRNOTAVAIL) {
+				klog.V(4).Infof("Address %q already removed. Skipping", addr.String())
+				metrics.AddressPresent.WithLabelValues(addr.IP.String(), m.devName).Set(0)
+				continue
+			}
+
+			return xerrors.Errorf("could not delete ip address %q: %v", addr.String(), err)
 		}
 
-		return xerrors.Errorf("could not delete ip address %v", err)
-	}
+		metrics.AddressPresent.WithLabelValues(addr.IP.String(), m.devName).Set(0)
 
-	klog.Infof("Successfully removed %q from %q", m.addr.String(), m.devName)
+		klog.Infof("Successfully removed %q from %q", addr.String(), m.devName)
+	}
 
 	return nil
 }
 
+// CleanupDevice deletes the interface if it was created by the sidecar. Interfaces which existed before,
+// e.g. the loopback interface, are left untouched.
 func (m *netifManagerDefault) CleanupDevice() error {
-	link, err := netlink.LinkByName(m.devName)
+	link, err := m.LinkByName(m.devName)
 	if err != nil {
 		var linkNotFoundErr netlink.LinkNotFoundError
 		if !errors.As(err, &linkNotFoundErr) {
@@ -163,9 +612,159 @@ func (m *netifManagerDefault) CleanupDevice() error {
 		// link already gone
 		return nil
 	}
-	err = netlink.LinkDel(link)
+
+	if link.Attrs().Alias != LinkAlias {
+		klog.Infof("Interface %q was not created by apiserver-proxy-sidecar. Skipping deletion", m.devName)
+		return nil
+	}
+
+	err = m.LinkDel(link)
 	if err != nil {
 		return xerrors.Errorf("could not delete interface %s:\n%v", m.devName, err)
 	}
+
+	klog.Infof("Successfully deleted interface %q", m.devName)
+
 	return nil
 }
+
+// DeviceOwned reports whether the interface exists and carries the alias of the sidecar.
+func (m *netifManagerDefault) DeviceOwned() (bool, error) {
+	link, err := m.LinkByName(m.devName)
+	if err != nil {
+		var linkNotFoundErr netlink.LinkNotFoundError
+		if errors.As(err, &linkNotFoundErr) {
+			return false, nil
+		}
+
+		return false, xerrors.Errorf("could not get interface %s:\n%v", m.devName, err)
+	}
+
+	return link.Attrs().Alias == LinkAlias, nil
+}
+
+// Conflict returns the state of a conflict with another agent or nil if there is none.
+func (m *netifManagerDefault) Conflict() *ConflictStatus {
+	return m.conflicts.status()
+}
+
+// Status returns the observed state of the interface and the managed addresses without changing anything.
+func (m *netifManagerDefault) Status() (*Status, error) {
+	st := &Status{Interface: m.devName, DuplicatePolicy: m.duplicates.policy()}
+	for _, addr := range m.addrs {
+		st.Addresses = append(st.Addresses, AddressStatus{Address: addr.IPNet.String()})
+	}
+
+	links, err := m.LinkList()
+	if err != nil {
+		return nil, xerrors.Errorf("could not list interfaces: %v", err)
+	}
+
+	for _, l := range links {
+		own := l.Attrs().Name == m.devName
+		if !own && !m.duplicates.checks(l.Attrs().Name) {
+			continue
+		}
+
+		if own {
+			st.Exists = true
+			st.Up = l.Attrs().Flags&net.FlagUp != 0
+			st.Owned = l.Attrs().Alias == LinkAlias
+		}
+
+		addrs, err := m.AddrList(l, 0)
+		if err != nil {
+			return nil, xerrors.Errorf("could not list addresses for interface %s: %v", l.Attrs().Name, err)
+		}
+
+		for _, addr := range addrs {
+			for i, managed := range m.addrs {
+				if !managed.Equal(addr) {
+					continue
+				}
+
+				if own {
+					st.Addresses[i].Present = true
+				} else {
+					st.Addresses[i].Duplicates = append(st.Addresses[i].Duplicates, l.Attrs().Name)
+				}
+			}
+		}
+	}
+
+	return st, nil
+}
+
+// Watch subscribes to netlink address and link updates and notifies the returned channel
+// about every update concerning the managed address or device.
+func (m *netifManagerDefault) Watch(done <-chan struct{}) (<-chan struct{}, error) {
+	stop := make(chan struct{})
+
+	addrUpdates := make(chan netlink.AddrUpdate, updateBufferSize)
+	if err := m.AddrSubscribe(addrUpdates, stop); err != nil {
+		close(stop)
+		return nil, xerrors.Errorf("could not subscribe to address updates: %v", err)
+	}
+
+	linkUpdates := make(chan netlink.LinkUpdate, updateBufferSize)
+	if err := m.LinkSubscribe(linkUpdates, stop); err != nil {
+		close(stop)
+		return nil, xerrors.Errorf("could not subscribe to link updates: %v", err)
+	}
+
+	events := make(chan struct{}, 1)
+	go m.watch(done, stop, addrUpdates, linkUpdates, events)
+
+	return events, nil
+}
+
+// watch filters the netlink updates until both subscriptions are closed. The updates are drained
+// after stopping, because the netlink subscriptions block on sending until they notice the closed socket.
+func (m *netifManagerDefault) watch(done <-chan struct{}, stop chan struct{},
+	addrUpdates <-chan netlink.AddrUpdate, linkUpdates <-chan netlink.LinkUpdate, events chan<- struct{}) {
+	defer close(events)
+
+	var stopOnce sync.Once
+	stopSubscriptions := func() {
+		stopOnce.Do(func() { close(stop) })
+	}
+	defer stopSubscriptions()
+
+	for addrUpdates != nil || linkUpdates != nil {
+		select {
+		case <-done:
+			stopSubscriptions()
+			done = nil
+		case u, ok := <-addrUpdates:
+			if !ok {
+				klog.V(2).Infoln("Address subscription closed")
+				addrUpdates = nil
+				stopSubscriptions()
+				continue
+			}
+			if m.isManaged(&u.LinkAddress) {
+				klog.V(4).Infof("Address %q changed on interface index %d (added: %t)", u.LinkAddress.String(), u.LinkIndex, u.NewAddr)
+				notify(events)
+			}
+		case u, ok := <-linkUpdates:
+			if !ok {
+				klog.V(2).Infoln("Link subscription closed")
+				linkUpdates = nil
+				stopSubscriptions()
+				continue
+			}
+			if u.Link != nil && u.Attrs().Name == m.devName {
+				klog.V(4).Infof("Interface %q changed", m.devName)
+				notify(events)
+			}
+		}
+	}
+}
+
+// notify sends a notification without blocking. Pending notifications are coalesced.
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
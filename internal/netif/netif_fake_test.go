@@ -0,0 +1,539 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netif_test
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vishvananda/netlink"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+	"github.com/gardener/apiserver-proxy/internal/netif"
+	"github.com/gardener/apiserver-proxy/internal/netif/fake"
+)
+
+var _ netif.Handle = &fake.Handle{}
+
+var _ = Describe("Manager with fake Handle", func() {
+
+	var (
+		handle  *fake.Handle
+		addr    *netlink.Addr
+		manager netif.Manager
+	)
+
+	BeforeEach(func() {
+		handle = fake.NewHandle()
+		handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}})
+		addr, _ = netlink.ParseAddr("192.168.0.3/32")
+		manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{})
+	})
+
+	It("should create the dummy interface and add the address", func() {
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		link := handle.Link("foo")
+		Expect(link).To(BeAssignableToTypeOf(&netlink.Dummy{}))
+		Expect(link.Attrs().Flags & net.FlagUp).ToNot(BeZero())
+		Expect(handle.Addrs("foo")).To(ConsistOf(HaveField("IPNet", Equal(addr.IPNet))))
+	})
+
+	It("should be idempotent", func() {
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		Expect(handle.Calls(fake.OpLinkAdd)).To(Equal(1))
+		Expect(handle.Addrs("foo")).To(HaveLen(1))
+	})
+
+	It("should use an existing interface", func() {
+		manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "lo", netif.Options{})
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		Expect(handle.Calls(fake.OpLinkAdd)).To(BeZero())
+		Expect(handle.Addrs("lo")).To(HaveLen(1))
+	})
+
+	It("should remove duplicates from other interfaces only", func() {
+		other, _ := netlink.ParseAddr("10.0.0.1/24")
+		handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}, *other, *addr)
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		Expect(handle.Addrs("eth0")).To(ConsistOf(HaveField("IPNet", Equal(other.IPNet))))
+		Expect(handle.Addrs("foo")).To(HaveLen(1))
+	})
+
+	Describe("interface mode", func() {
+
+		newManager := func(devName, mode string) netif.Manager {
+			return netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, devName, netif.Options{Mode: mode})
+		}
+
+		It("should not create the interface with the existing mode", func() {
+			err := newManager("foo", netif.InterfaceModeExisting).EnsureIPAddress()
+
+			Expect(netif.ReasonOf(err)).To(Equal(netif.ReasonLinkLookup))
+			Expect(handle.Calls(fake.OpLinkAdd)).To(BeZero())
+		})
+
+		It("should use an existing interface of any type with the existing mode", func() {
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagUp}})
+
+			Expect(newManager("eth0", netif.InterfaceModeExisting).EnsureIPAddress()).To(Succeed())
+			Expect(handle.Addrs("eth0")).To(HaveLen(1))
+		})
+
+		It("should fail for an existing interface which is not a dummy interface with the create-dummy mode", func() {
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagUp}})
+
+			err := newManager("eth0", netif.InterfaceModeCreateDummy).EnsureIPAddress()
+
+			Expect(netif.ReasonOf(err)).To(Equal(netif.ReasonLinkType))
+			Expect(handle.Addrs("eth0")).To(BeEmpty())
+		})
+
+		It("should fail for an interface which is not a loopback interface with the loopback mode", func() {
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagUp}})
+
+			Expect(netif.ReasonOf(newManager("eth0", netif.InterfaceModeLoopback).EnsureIPAddress())).To(Equal(netif.ReasonLinkType))
+			Expect(newManager("lo", netif.InterfaceModeLoopback).EnsureIPAddress()).To(Succeed())
+		})
+
+		It("should derive the mode from the name of the interface", func() {
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagUp}})
+
+			Expect(newManager("lo", "").EnsureIPAddress()).To(Succeed())
+			Expect(netif.ReasonOf(newManager("eth0", "").EnsureIPAddress())).To(Equal(netif.ReasonLinkType))
+		})
+
+		It("should set the interface up again if somebody set it down", func() {
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+			handle.Link("foo").Attrs().Flags &^= net.FlagUp
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Link("foo").Attrs().Flags & net.FlagUp).ToNot(BeZero())
+			Expect(handle.Calls(fake.OpLinkSetUp)).To(Equal(2))
+		})
+
+		It("should reject an unknown mode", func() {
+			Expect((&netif.Options{Mode: "ipvlan"}).Validate()).To(MatchError(ContainSubstring("unknown interface mode")))
+		})
+	})
+
+	Describe("duplicates", func() {
+
+		var (
+			other      *netlink.Addr
+			duplicates []string
+		)
+
+		BeforeEach(func() {
+			other, _ = netlink.ParseAddr("10.0.0.1/24")
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}, *other, *addr)
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "kube-ipvs0"}}, *addr)
+			duplicates = nil
+		})
+
+		newManager := func(d netif.Duplicates) netif.Manager {
+			d.OnDuplicate = func(addr, devName string, removed bool) {
+				duplicates = append(duplicates, fmt.Sprintf("%s %s %t", addr, devName, removed))
+			}
+			Expect(d.Validate()).To(Succeed())
+			return netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{Duplicates: d})
+		}
+
+		It("should remove duplicates from all other interfaces by default", func() {
+			Expect(newManager(netif.Duplicates{}).EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Addrs("eth0")).To(HaveLen(1))
+			Expect(handle.Addrs("kube-ipvs0")).To(BeEmpty())
+			Expect(duplicates).To(ConsistOf("192.168.0.3/32 eth0 true", "192.168.0.3/32 kube-ipvs0 true"))
+		})
+
+		It("should not check excluded interfaces", func() {
+			manager = newManager(netif.Duplicates{Exclude: []string{"kube-*"}})
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Addrs("eth0")).To(HaveLen(1))
+			Expect(handle.Addrs("kube-ipvs0")).To(HaveLen(1))
+			Expect(duplicates).To(ConsistOf("192.168.0.3/32 eth0 true"))
+
+			st, err := manager.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(st.Addresses).To(ConsistOf(HaveField("Duplicates", BeEmpty())))
+			Expect(st.Healthy()).To(BeTrue())
+		})
+
+		It("should only check included interfaces", func() {
+			Expect(newManager(netif.Duplicates{Include: []string{"eth*"}}).EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Addrs("kube-ipvs0")).To(HaveLen(1))
+			Expect(duplicates).To(ConsistOf("192.168.0.3/32 eth0 true"))
+		})
+
+		It("should keep duplicates and warn about them", func() {
+			manager = newManager(netif.Duplicates{Policy: netif.DuplicatePolicyWarn})
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Addrs("eth0")).To(HaveLen(2))
+			Expect(handle.Addrs("kube-ipvs0")).To(HaveLen(1))
+			Expect(handle.Calls(fake.OpAddrDel)).To(BeZero())
+			Expect(duplicates).To(ConsistOf("192.168.0.3/32 eth0 false", "192.168.0.3/32 kube-ipvs0 false"))
+
+			st, err := manager.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(st.Addresses).To(ConsistOf(HaveField("Duplicates", ConsistOf("eth0", "kube-ipvs0"))))
+			Expect(st.Healthy()).To(BeTrue())
+		})
+
+		It("should keep duplicates and fail", func() {
+			manager = newManager(netif.Duplicates{Policy: netif.DuplicatePolicyFail, Exclude: []string{"kube-ipvs0"}})
+
+			err := manager.EnsureIPAddress()
+			Expect(err).To(MatchError(ContainSubstring(`"192.168.0.3/32" on interface "eth0"`)))
+			Expect(netif.ReasonOf(err)).To(Equal(netif.ReasonDuplicate))
+
+			Expect(handle.Addrs("foo")).To(HaveLen(1))
+			Expect(handle.Addrs("eth0")).To(HaveLen(2))
+
+			st, err := manager.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(st.Healthy()).To(BeFalse())
+		})
+
+		It("should reject an unknown policy and invalid patterns", func() {
+			Expect((&netif.Duplicates{Policy: "ignore"}).Validate()).To(MatchError(ContainSubstring("unknown duplicate policy")))
+			Expect((&netif.Duplicates{Include: []string{"eth["}}).Validate()).To(MatchError(ContainSubstring("invalid interface pattern")))
+		})
+	})
+
+	Describe("conflicts", func() {
+
+		removeAddress := func() {
+			ExpectWithOffset(1, handle.AddrDel(handle.Link("foo"), addr)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{
+				Conflicts: netif.Conflicts{Threshold: 2, Window: time.Minute},
+			})
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+		})
+
+		It("should not detect a conflict below the threshold", func() {
+			removeAddress()
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(manager.Conflict()).To(BeNil())
+		})
+
+		It("should detect a conflict when repeatedly repairing the address", func() {
+			for range 2 {
+				removeAddress()
+				Expect(manager.EnsureIPAddress()).To(Succeed())
+			}
+
+			Expect(manager.Conflict()).To(PointTo(MatchFields(IgnoreExtras, Fields{
+				"Repairs":      Equal(2),
+				"BackoffUntil": BeNil(),
+			})))
+			Expect(handle.Addrs("foo")).To(HaveLen(1))
+		})
+
+		It("should count the removal of duplicates as repairs", func() {
+			handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}, *addr)
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+			removeAddress()
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(manager.Conflict()).ToNot(BeNil())
+		})
+
+		It("should delay further repairs while in conflict", func() {
+			manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{
+				Conflicts: netif.Conflicts{Threshold: 2, Window: time.Minute, MaxBackoff: time.Minute},
+			})
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			for range 2 {
+				removeAddress()
+				Expect(manager.EnsureIPAddress()).To(Succeed())
+			}
+			Expect(manager.Conflict().BackoffUntil).ToNot(BeNil())
+
+			// the state is observed without changing it while backing off
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			removeAddress()
+			err := manager.EnsureIPAddress()
+			Expect(err).To(MatchError(ContainSubstring("delaying the repair")))
+			Expect(netif.ReasonOf(err)).To(Equal(netif.ReasonConflict))
+			Expect(handle.Addrs("foo")).To(BeEmpty())
+		})
+	})
+
+	Describe("conntrack", func() {
+
+		var flows []*netlink.ConntrackFlow
+
+		flow := func(protocol uint8, dst string, dstPort uint16, replySrc string, replySrcPort uint16) *netlink.ConntrackFlow {
+			f := &netlink.ConntrackFlow{FamilyType: netlink.FAMILY_V4}
+			f.Forward = netlink.IPTuple{Protocol: protocol, SrcIP: net.ParseIP("10.0.0.1"), SrcPort: 40000, DstIP: net.ParseIP(dst), DstPort: dstPort}
+			f.Reverse = netlink.IPTuple{Protocol: protocol, SrcIP: net.ParseIP(replySrc), SrcPort: replySrcPort, DstIP: net.ParseIP("10.0.0.1"), DstPort: 40000}
+			return f
+		}
+
+		BeforeEach(func() {
+			flows = []*netlink.ConntrackFlow{
+				flow(syscall.IPPROTO_TCP, "192.168.0.3", 443, "192.168.0.3", 443),
+				// DNATed from a service IP
+				flow(syscall.IPPROTO_UDP, "100.64.0.1", 443, "192.168.0.3", 443),
+				flow(syscall.IPPROTO_TCP, "192.168.0.3", 80, "192.168.0.3", 80),
+				flow(syscall.IPPROTO_TCP, "192.168.0.4", 443, "192.168.0.4", 443),
+				flow(syscall.IPPROTO_ICMP, "192.168.0.3", 0, "192.168.0.3", 0),
+			}
+			for _, f := range flows {
+				handle.AddConntrackFlow(f)
+			}
+
+			manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{
+				Conntrack: netif.Conntrack{Flush: true, Port: 443},
+			})
+		})
+
+		It("should flush the entries of the address and port after adding the address", func() {
+			flushed := testutil.ToFloat64(metrics.ConntrackEntriesFlushed.WithLabelValues("192.168.0.3"))
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.ConntrackFlows()).To(Equal(flows[2:]))
+			Expect(testutil.ToFloat64(metrics.ConntrackEntriesFlushed.WithLabelValues("192.168.0.3"))).To(Equal(flushed + 2))
+		})
+
+		It("should not flush the entries if the address is present", func() {
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+			handle.AddConntrackFlow(flows[0])
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Calls(fake.OpConntrackDeleteFilters)).To(Equal(1))
+			Expect(handle.ConntrackFlows()).To(ContainElement(flows[0]))
+		})
+
+		It("should flush the entries of all ports if no port is given", func() {
+			manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{
+				Conntrack: netif.Conntrack{Flush: true},
+			})
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.ConntrackFlows()).To(Equal(flows[3:]))
+		})
+
+		It("should not flush the entries if disabled", func() {
+			manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{})
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Calls(fake.OpConntrackDeleteFilters)).To(BeZero())
+			Expect(handle.ConntrackFlows()).To(Equal(flows))
+		})
+
+		It("should add the address even if flushing the entries fails", func() {
+			failures := testutil.ToFloat64(metrics.ConntrackFlushes.WithLabelValues("192.168.0.3", "failure"))
+			handle.InjectError(fake.OpConntrackDeleteFilters, syscall.EPERM, 1)
+
+			Expect(manager.EnsureIPAddress()).To(Succeed())
+
+			Expect(handle.Addrs("foo")).To(HaveLen(1))
+			Expect(handle.ConntrackFlows()).To(Equal(flows))
+			Expect(testutil.ToFloat64(metrics.ConntrackFlushes.WithLabelValues("192.168.0.3", "failure"))).To(Equal(failures + 1))
+		})
+	})
+
+	It("should remove the address", func() {
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+
+		Expect(handle.Addrs("foo")).To(BeEmpty())
+	})
+
+	It("should tolerate removing an address which does not exist", func() {
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+	})
+
+	It("should delete the interface it created", func() {
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+		Expect(manager.CleanupDevice()).To(Succeed())
+
+		Expect(handle.Link("foo")).To(BeNil())
+		Expect(manager.CleanupDevice()).To(Succeed())
+	})
+
+	It("should not delete an interface it did not create", func() {
+		manager = netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "lo", netif.Options{})
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+		Expect(manager.CleanupDevice()).To(Succeed())
+
+		Expect(handle.Link("lo")).ToNot(BeNil())
+		Expect(handle.Calls(fake.OpLinkDel)).To(BeZero())
+	})
+
+	It("should report whether it owns the interface", func() {
+		Expect(manager.DeviceOwned()).To(BeFalse())
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.DeviceOwned()).To(BeTrue())
+
+		Expect(netif.NewNetifManagerWithHandle(handle, nil, "lo", netif.Options{}).DeviceOwned()).To(BeFalse())
+	})
+
+	It("should succeed removing the address from an interface which does not exist", func() {
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+	})
+
+	It("should recover from a transient failure", func() {
+		handle.InjectError(fake.OpLinkAdd, syscall.EAGAIN, 1)
+
+		err := manager.EnsureIPAddress()
+		Expect(err).To(MatchError(ContainSubstring("resource temporarily unavailable")))
+		Expect(netif.ReasonOf(err)).To(Equal(netif.ReasonLinkAdd))
+		Expect(handle.Link("foo")).To(BeNil())
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(handle.Addrs("foo")).To(HaveLen(1))
+	})
+
+	It("should keep failing without permissions", func() {
+		handle.InjectError(fake.OpAddrAdd, syscall.EPERM, -1)
+
+		for range 2 {
+			err := manager.EnsureIPAddress()
+			Expect(netif.ReasonOf(err)).To(Equal(netif.ReasonAddrAdd))
+		}
+
+		Expect(handle.Link("foo")).ToNot(BeNil())
+		Expect(handle.Addrs("foo")).To(BeEmpty())
+	})
+
+	It("should notify about the address being removed by somebody else", func() {
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		done := make(chan struct{})
+		defer close(done)
+
+		events, err := manager.Watch(done)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(handle.AddrDel(handle.Link("foo"), addr)).To(Succeed())
+		Eventually(events).Should(Receive())
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(handle.Addrs("foo")).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("DryRunHandle", func() {
+
+	var (
+		handle *fake.Handle
+		dryRun *netif.DryRunHandle
+		addr   *netlink.Addr
+	)
+
+	BeforeEach(func() {
+		handle = fake.NewHandle()
+		handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}})
+		addr, _ = netlink.ParseAddr("192.168.0.3/32")
+		dryRun = netif.NewDryRunHandle(handle)
+	})
+
+	newManager := func(devName string) netif.Manager {
+		return netif.NewNetifManagerWithHandle(dryRun, []*netlink.Addr{addr}, devName, netif.Options{})
+	}
+
+	It("should record creating the dummy interface and adding the address without applying it", func() {
+		manager := newManager("foo")
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		Expect(dryRun.Actions()).To(Equal([]netif.Action{
+			{Op: netif.ActionLinkAdd, Interface: "foo", Type: "dummy"},
+			{Op: netif.ActionLinkSetUp, Interface: "foo"},
+			{Op: netif.ActionAddrAdd, Interface: "foo", Address: "192.168.0.3/32"},
+		}))
+		Expect(handle.Link("foo")).To(BeNil())
+		Expect(handle.Calls(fake.OpLinkAdd)).To(BeZero())
+	})
+
+	It("should not flush conntrack entries", func() {
+		manager := netif.NewNetifManagerWithHandle(dryRun, []*netlink.Addr{addr}, "foo", netif.Options{
+			Conntrack: netif.Conntrack{Flush: true},
+		})
+
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		Expect(handle.Calls(fake.OpConntrackDeleteFilters)).To(BeZero())
+	})
+
+	It("should not record adding an address which is present", func() {
+		handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagUp}}, *addr)
+
+		manager := netif.NewNetifManagerWithHandle(dryRun, []*netlink.Addr{addr}, "eth0", netif.Options{Mode: netif.InterfaceModeExisting})
+		Expect(manager.EnsureIPAddress()).To(Succeed())
+
+		Expect(dryRun.Actions()).To(BeEmpty())
+	})
+
+	It("should record removing duplicates without applying it", func() {
+		handle.AddLink(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}, *addr)
+
+		Expect(newManager("lo").EnsureIPAddress()).To(Succeed())
+
+		Expect(dryRun.Actions()).To(Equal([]netif.Action{
+			{Op: netif.ActionAddrAdd, Interface: "lo", Address: "192.168.0.3/32"},
+			{Op: netif.ActionAddrDel, Interface: "eth0", Address: "192.168.0.3/32"},
+		}))
+		Expect(handle.Addrs("eth0")).To(HaveLen(1))
+		Expect(handle.Addrs("lo")).To(BeEmpty())
+	})
+
+	It("should record removing the address and deleting the interface", func() {
+		Expect(netif.NewNetifManagerWithHandle(handle, []*netlink.Addr{addr}, "foo", netif.Options{}).EnsureIPAddress()).To(Succeed())
+		manager := newManager("foo")
+
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+		Expect(manager.RemoveIPAddress()).To(Succeed())
+		Expect(manager.CleanupDevice()).To(Succeed())
+
+		Expect(dryRun.Actions()).To(Equal([]netif.Action{
+			{Op: netif.ActionAddrDel, Interface: "foo", Address: "192.168.0.3/32"},
+			{Op: netif.ActionLinkDel, Interface: "foo"},
+		}))
+		Expect(handle.Addrs("foo")).To(HaveLen(1))
+	})
+
+	It("should describe the actions", func() {
+		Expect(netif.Action{Op: netif.ActionLinkAdd, Interface: "foo", Type: "dummy"}.String()).To(Equal("add dummy interface foo"))
+		Expect(netif.Action{Op: netif.ActionAddrDel, Interface: "eth0", Address: "192.168.0.3/32"}.String()).
+			To(Equal("delete address 192.168.0.3/32 from interface eth0"))
+	})
+})
@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build integration
+
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	vnetns "github.com/vishvananda/netns"
+
+	"github.com/gardener/apiserver-proxy/internal/netns"
+)
+
+// The specs below run the manager against the kernel in a throwaway network namespace. They are only built with
+// the integration tag (make test-integration) and skip themselves without the privileges to create namespaces.
+var _ = Describe("Manager in a network namespace", func() {
+
+	var (
+		name    string
+		ns      *netns.Namespace
+		handle  *netlink.Handle
+		addr    *netlink.Addr
+		addr6   *netlink.Addr
+		counter int
+	)
+
+	BeforeEach(func() {
+		counter++
+		name = fmt.Sprintf("apiserver-proxy-test-%d-%d", os.Getpid(), counter)
+
+		if err := newNamedNamespace(name); err != nil {
+			if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+				Skip(fmt.Sprintf("creating network namespaces is not permitted: %v", err))
+			}
+			Fail(err.Error())
+		}
+
+		var err error
+		ns, err = netns.Open("/var/run/netns/" + name)
+		Expect(err).ToNot(HaveOccurred())
+
+		handle, err = netlink.NewHandleAt(ns.Handle())
+		Expect(err).ToNot(HaveOccurred())
+
+		// the loopback interface of a new namespace is down
+		lo, err := handle.LinkByName("lo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(handle.LinkSetUp(lo)).To(Succeed())
+
+		addr, _ = netlink.ParseAddr("10.96.0.2/32")
+		addr6, _ = netlink.ParseAddr("fd00::2/128")
+	})
+
+	AfterEach(func() {
+		if ns == nil {
+			return
+		}
+
+		handle.Close()
+		Expect(ns.Close()).To(Succeed())
+		Expect(vnetns.DeleteNamed(name)).To(Succeed())
+		ns = nil
+	})
+
+	newManager := func(devName string, opts Options, addrs ...*netlink.Addr) Manager {
+		opts.Namespace = ns
+		m, err := NewNetifManager(addrs, devName, opts)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return m
+	}
+
+	addresses := func(devName string) []string {
+		link, err := handle.LinkByName(devName)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		addrs, err := handle.AddrList(link, netlink.FAMILY_ALL)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+		var cidrs []string
+		for _, a := range addrs {
+			cidrs = append(cidrs, a.IPNet.String())
+		}
+		return cidrs
+	}
+
+	addVeth := func() {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0"}, PeerName: "veth1"}
+		ExpectWithOffset(1, handle.LinkAdd(veth)).To(Succeed())
+		ExpectWithOffset(1, handle.LinkSetUp(veth)).To(Succeed())
+	}
+
+	It("should create the dummy interface, add the addresses and delete the interface again", func() {
+		m := newManager("proxy0", Options{}, addr, addr6)
+
+		// the kernel error is not wrapped, so only its message is available
+		if err := m.EnsureIPAddress(); ReasonOf(err) == ReasonLinkAdd && strings.Contains(err.Error(), "not supported") {
+			Skip("dummy interfaces are not supported by the kernel")
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		link, err := handle.LinkByName("proxy0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(link.Type()).To(Equal("dummy"))
+		Expect(link.Attrs().Alias).To(Equal(LinkAlias))
+		Expect(link.Attrs().Flags & net.FlagUp).ToNot(BeZero())
+		Expect(addresses("proxy0")).To(ContainElements("10.96.0.2/32", "fd00::2/128"))
+		Expect(m.DeviceOwned()).To(BeTrue())
+
+		Expect(m.RemoveIPAddress()).To(Succeed())
+		Expect(m.CleanupDevice()).To(Succeed())
+
+		_, err = handle.LinkByName("proxy0")
+		Expect(err).To(BeAssignableToTypeOf(netlink.LinkNotFoundError{}))
+	})
+
+	It("should add the addresses to and remove them from an existing interface", func() {
+		m := newManager("lo", Options{}, addr, addr6)
+
+		Expect(m.EnsureIPAddress()).To(Succeed())
+		Expect(m.EnsureIPAddress()).To(Succeed())
+		Expect(addresses("lo")).To(ContainElements("10.96.0.2/32", "fd00::2/128"))
+
+		Expect(m.RemoveIPAddress()).To(Succeed())
+		Expect(addresses("lo")).ToNot(ContainElements("10.96.0.2/32"))
+		Expect(addresses("lo")).ToNot(ContainElements("fd00::2/128"))
+
+		// the kernel reports the addresses which are already removed with EADDRNOTAVAIL
+		Expect(m.RemoveIPAddress()).To(Succeed())
+		Expect(m.CleanupDevice()).To(Succeed())
+		_, err := handle.LinkByName("lo")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should read the disable_ipv6 sysctl of the namespace", func() {
+		Expect(ns.Do(func() error {
+			return os.WriteFile("/proc/sys/net/ipv6/conf/lo/disable_ipv6", []byte("1"), 0o644)
+		})).To(Succeed())
+
+		err := newManager("lo", Options{}, addr6).EnsureIPAddress()
+		Expect(err).To(MatchError(ContainSubstring("IPv6 is disabled for interface lo")))
+	})
+
+	It("should remove duplicates from the other end of a veth pair", func() {
+		addVeth()
+		link, err := handle.LinkByName("veth1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(handle.AddrAdd(link, addr)).To(Succeed())
+
+		Expect(newManager("lo", Options{}, addr).EnsureIPAddress()).To(Succeed())
+
+		Expect(addresses("lo")).To(ContainElement("10.96.0.2/32"))
+		Expect(addresses("veth1")).ToNot(ContainElement("10.96.0.2/32"))
+	})
+
+	It("should keep duplicates on excluded interfaces", func() {
+		addVeth()
+		link, err := handle.LinkByName("veth1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(handle.AddrAdd(link, addr)).To(Succeed())
+
+		m := newManager("lo", Options{Duplicates: Duplicates{Exclude: []string{"veth*"}}}, addr)
+		Expect(m.EnsureIPAddress()).To(Succeed())
+
+		Expect(addresses("veth1")).To(ContainElement("10.96.0.2/32"))
+		st, err := m.Status()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(st.Healthy()).To(BeTrue())
+	})
+
+	It("should notify about the address being removed by somebody else", func() {
+		m := newManager("lo", Options{}, addr)
+		Expect(m.EnsureIPAddress()).To(Succeed())
+
+		done := make(chan struct{})
+		defer close(done)
+
+		events, err := m.Watch(done)
+		Expect(err).ToNot(HaveOccurred())
+
+		link, err := handle.LinkByName("lo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(handle.AddrDel(link, addr)).To(Succeed())
+
+		Eventually(events).Should(Receive())
+		Expect(m.EnsureIPAddress()).To(Succeed())
+		Expect(addresses("lo")).To(ContainElement("10.96.0.2/32"))
+	})
+})
+
+// newNamedNamespace creates a network namespace which is bind mounted to /var/run/netns/<name>
+// without switching the namespace of the calling thread.
+func newNamedNamespace(name string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := vnetns.Get()
+	if err != nil {
+		return err
+	}
+	defer orig.Close()
+
+	created, err := vnetns.NewNamed(name)
+	if err != nil {
+		return err
+	}
+	defer created.Close()
+
+	return vnetns.Set(orig)
+}
@@ -8,11 +8,16 @@ import (
 	"net"
 	"syscall"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/vishvananda/netlink"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/sys/unix"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
 )
 
 func TestNetif(t *testing.T) {
@@ -26,6 +31,7 @@ var _ = Describe("Manager", func() {
 		ctrl          *gomock.Controller
 		mh            *MockHandle
 		addr          *netlink.Addr
+		addrs         []*netlink.Addr
 		interfaceName string
 		manager       Manager
 		dm            *netifManagerDefault
@@ -35,11 +41,12 @@ var _ = Describe("Manager", func() {
 
 	BeforeEach(func() {
 		addr, _ = netlink.ParseAddr(ip + "/32")
+		addrs = []*netlink.Addr{addr}
 		interfaceName = "foo"
 		ctrl = gomock.NewController(GinkgoT())
 		mh = NewMockHandle(ctrl)
 		dummy = &netlink.Dummy{
-			LinkAttrs: netlink.LinkAttrs{Name: interfaceName},
+			LinkAttrs: netlink.LinkAttrs{Name: interfaceName, Flags: net.FlagUp},
 		}
 	})
 
@@ -53,7 +60,9 @@ var _ = Describe("Manager", func() {
 	})
 
 	JustBeforeEach(func() {
-		manager = NewNetifManager(addr, interfaceName)
+		var err error
+		manager, err = NewNetifManager(addrs, interfaceName, Options{})
+		Expect(err).NotTo(HaveOccurred())
 		dm = manager.(*netifManagerDefault)
 		// override the default handler
 		dm.Handle = mh
@@ -67,11 +76,30 @@ var _ = Describe("Manager", func() {
 
 		Context("address", func() {
 			JustBeforeEach(func() {
-				Expect(dm.addr).NotTo(BeNil(), "addr should always be set")
+				Expect(dm.addrs).To(HaveLen(1), "addr should always be set")
 			})
 
 			It("should set point to the corrext IP", func() {
-				Expect(dm.addr.IPNet).To(Equal(&net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(32, 32)}))
+				Expect(dm.addrs[0].IPNet).To(Equal(&net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(32, 32)}))
+			})
+
+			It("should not set flags for IPv4 addresses", func() {
+				Expect(dm.addrs[0].Flags).To(BeZero())
+			})
+		})
+
+		Context("IPv6 address", func() {
+			var addr6 *netlink.Addr
+
+			BeforeEach(func() {
+				addr6, _ = netlink.ParseAddr("fd00::3/128")
+				addrs = []*netlink.Addr{addr, addr6}
+			})
+
+			It("should disable duplicate address detection", func() {
+				Expect(dm.addrs).To(HaveLen(2))
+				Expect(dm.addrs[1].Flags & unix.IFA_F_NODAD).ToNot(BeZero())
+				Expect(addr6.Flags).To(BeZero(), "the passed address should not be modified")
 			})
 
 		})
@@ -145,10 +173,15 @@ var _ = Describe("Manager", func() {
 
 			err := manager.EnsureIPAddress()
 			Expect(err).To(HaveOccurred())
+			Expect(ReasonOf(err)).To(Equal(ReasonLinkLookup))
 		})
 
 		Context("LinkByName errors with LinkNotFoundError", func() {
 			BeforeEach(func() {
+				// the created link is marked as owned by the sidecar and passed on as it was before setting it up
+				dummy.Alias = LinkAlias
+				dummy.Flags = 0
+
 				mh.EXPECT().
 					LinkByName(gomock.Eq("foo")).
 					Return(dummy, netlink.LinkNotFoundError{}).
@@ -157,7 +190,7 @@ var _ = Describe("Manager", func() {
 
 			It("should return error when adding ip address", func() {
 				mh.EXPECT().
-					LinkAdd(gomock.Any()).
+					LinkAdd(gomock.Cond(func(l netlink.Link) bool { return l.Attrs().Alias == LinkAlias })).
 					Return(nil).
 					Times(1)
 
@@ -218,6 +251,7 @@ var _ = Describe("Manager", func() {
 
 				err := manager.EnsureIPAddress()
 				Expect(err).To(HaveOccurred())
+				Expect(ReasonOf(err)).To(Equal(ReasonLinkAdd))
 			})
 
 		})
@@ -242,6 +276,7 @@ var _ = Describe("Manager", func() {
 
 				err := manager.EnsureIPAddress()
 				Expect(err).To(HaveOccurred())
+				Expect(ReasonOf(err)).To(Equal(ReasonAddrAdd))
 			})
 
 			It("should return already exists error", func() {
@@ -264,6 +299,51 @@ var _ = Describe("Manager", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 		})
+		Context("dual-stack", func() {
+			var addr6 *netlink.Addr
+
+			BeforeEach(func() {
+				addr6, _ = netlink.ParseAddr("fd00::3/128")
+				addrs = []*netlink.Addr{addr, addr6}
+
+				mh.EXPECT().
+					LinkByName(gomock.Eq("foo")).
+					Return(dummy, nil).
+					Times(1)
+				mh.EXPECT().
+					LinkList().
+					Return([]netlink.Link{dummy}, nil).
+					Times(1)
+			})
+
+			It("should add both addresses", func() {
+				dm.ipv6Disabled = func(string) (bool, error) { return false, nil }
+
+				mh.EXPECT().
+					AddrAdd(gomock.Eq(dummy), gomock.Eq(addr)).
+					Return(nil).
+					Times(1)
+				mh.EXPECT().
+					AddrAdd(gomock.Eq(dummy), gomock.Eq(dm.addrs[1])).
+					Return(nil).
+					Times(1)
+
+				Expect(manager.EnsureIPAddress()).To(Succeed())
+			})
+
+			It("should add the IPv4 address and return error when IPv6 is disabled", func() {
+				dm.ipv6Disabled = func(string) (bool, error) { return true, nil }
+
+				mh.EXPECT().
+					AddrAdd(gomock.Eq(dummy), gomock.Eq(addr)).
+					Return(nil).
+					Times(1)
+
+				err := manager.EnsureIPAddress()
+				Expect(err).To(MatchError(ContainSubstring("IPv6 is disabled")))
+			})
+		})
+
 		Context("Duplicate IP exists", func() {
 			var dupLink netlink.Link
 			BeforeEach(func() {
@@ -278,6 +358,8 @@ var _ = Describe("Manager", func() {
 			})
 
 			It("should remove duplicate ip address", func() {
+				removed := testutil.ToFloat64(metrics.DuplicateAddressesRemoved.WithLabelValues("dup"))
+
 				mh.EXPECT().
 					LinkList().
 					Return([]netlink.Link{dummy, dupLink}, nil).
@@ -300,8 +382,294 @@ var _ = Describe("Manager", func() {
 
 				err := manager.EnsureIPAddress()
 				Expect(err).To(HaveOccurred())
+				Expect(ReasonOf(err)).To(Equal(ReasonAddrAdd))
+				Expect(testutil.ToFloat64(metrics.DuplicateAddressesRemoved.WithLabelValues("dup"))).To(Equal(removed + 1))
+			})
+
+			It("should return the errors of adding and deduplicating the ip address", func() {
+				mh.EXPECT().
+					AddrAdd(gomock.Eq(dummy), gomock.Eq(addr)).
+					Return(fmt.Errorf("err")).
+					Times(1)
+
+				mh.EXPECT().
+					LinkList().
+					Return(nil, fmt.Errorf("err")).
+					Times(1)
+
+				err := manager.EnsureIPAddress()
+				Expect(err).To(MatchError(ContainSubstring("could not deduplicate IP address")))
+				Expect(ReasonOf(err)).To(Equal(ReasonAddrAdd))
+			})
+
+			It("should remove duplicate ip address after adding it", func() {
+				removed := testutil.ToFloat64(metrics.DuplicateAddressesRemoved.WithLabelValues("dup"))
+
+				mh.EXPECT().
+					LinkList().
+					Return([]netlink.Link{dummy, dupLink}, nil).
+					Times(1)
+
+				mh.EXPECT().
+					AddrList(dupLink, 0).
+					Return([]netlink.Addr{*addr}, nil).
+					Times(1)
+
+				gomock.InOrder(
+					mh.EXPECT().
+						AddrAdd(gomock.Eq(dummy), gomock.Eq(addr)).
+						Return(nil).
+						Times(1),
+					mh.EXPECT().
+						AddrDel(dupLink, addr).
+						Return(nil).
+						Times(1),
+				)
+
+				Expect(manager.EnsureIPAddress()).To(Succeed())
+				Expect(testutil.ToFloat64(metrics.DuplicateAddressesRemoved.WithLabelValues("dup"))).To(Equal(removed + 1))
 			})
 		})
 	})
 
+	Describe("CleanupDevice", func() {
+
+		It("should return error when getting link", func() {
+			mh.EXPECT().
+				LinkByName(gomock.Eq("foo")).
+				Return(nil, fmt.Errorf("err")).
+				Times(1)
+
+			Expect(manager.CleanupDevice()).ToNot(Succeed())
+		})
+
+		It("should succeed when link is already gone", func() {
+			mh.EXPECT().
+				LinkByName(gomock.Eq("foo")).
+				Return(nil, netlink.LinkNotFoundError{}).
+				Times(1)
+
+			Expect(manager.CleanupDevice()).To(Succeed())
+		})
+
+		It("should not delete a link which was not created by the sidecar", func() {
+			mh.EXPECT().
+				LinkByName(gomock.Eq("foo")).
+				Return(dummy, nil).
+				Times(1)
+
+			mh.EXPECT().
+				LinkDel(gomock.Any()).
+				Times(0)
+
+			Expect(manager.CleanupDevice()).To(Succeed())
+		})
+
+		Context("link was created by the sidecar", func() {
+			BeforeEach(func() {
+				dummy.Alias = LinkAlias
+
+				mh.EXPECT().
+					LinkByName(gomock.Eq("foo")).
+					Return(dummy, nil).
+					Times(1)
+			})
+
+			It("should delete the link", func() {
+				mh.EXPECT().
+					LinkDel(gomock.Eq(dummy)).
+					Return(nil).
+					Times(1)
+
+				Expect(manager.CleanupDevice()).To(Succeed())
+			})
+
+			It("should return error when deleting the link fails", func() {
+				mh.EXPECT().
+					LinkDel(gomock.Eq(dummy)).
+					Return(fmt.Errorf("err")).
+					Times(1)
+
+				Expect(manager.CleanupDevice()).ToNot(Succeed())
+			})
+		})
+	})
+
+	Describe("Watch", func() {
+		var done chan struct{}
+
+		BeforeEach(func() {
+			done = make(chan struct{})
+		})
+
+		It("should return error when subscribing to address updates fails", func() {
+			mh.EXPECT().
+				AddrSubscribe(gomock.Any(), gomock.Any()).
+				Return(fmt.Errorf("err")).
+				Times(1)
+
+			_, err := manager.Watch(done)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error when subscribing to link updates fails", func() {
+			mh.EXPECT().
+				AddrSubscribe(gomock.Any(), gomock.Any()).
+				Return(nil).
+				Times(1)
+
+			mh.EXPECT().
+				LinkSubscribe(gomock.Any(), gomock.Any()).
+				Return(fmt.Errorf("err")).
+				Times(1)
+
+			_, err := manager.Watch(done)
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("subscriptions succeed", func() {
+			var (
+				addrUpdates chan<- netlink.AddrUpdate
+				linkUpdates chan<- netlink.LinkUpdate
+				events      <-chan struct{}
+			)
+
+			BeforeEach(func() {
+				mh.EXPECT().
+					AddrSubscribe(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ch chan<- netlink.AddrUpdate, stop <-chan struct{}) error {
+						addrUpdates = ch
+						go func() {
+							<-stop
+							close(ch)
+						}()
+						return nil
+					}).
+					Times(1)
+
+				mh.EXPECT().
+					LinkSubscribe(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ch chan<- netlink.LinkUpdate, stop <-chan struct{}) error {
+						linkUpdates = ch
+						go func() {
+							<-stop
+							close(ch)
+						}()
+						return nil
+					}).
+					Times(1)
+			})
+
+			JustBeforeEach(func() {
+				var err error
+				events, err = manager.Watch(done)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should notify about changes of the managed address", func() {
+				addrUpdates <- netlink.AddrUpdate{LinkAddress: *addr.IPNet, LinkIndex: 2}
+
+				Eventually(events).Should(Receive())
+				close(done)
+				Eventually(events).Should(BeClosed())
+			})
+
+			It("should notify about changes of the managed interface", func() {
+				linkUpdates <- netlink.LinkUpdate{Link: dummy}
+
+				Eventually(events).Should(Receive())
+				close(done)
+				Eventually(events).Should(BeClosed())
+			})
+
+			It("should ignore changes of other addresses and interfaces", func() {
+				other, _ := netlink.ParseAddr("192.168.0.4/32")
+				addrUpdates <- netlink.AddrUpdate{LinkAddress: *other.IPNet, LinkIndex: 2}
+				linkUpdates <- netlink.LinkUpdate{Link: &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "bar"}}}
+
+				Consistently(events, "100ms").ShouldNot(Receive())
+				close(done)
+				Eventually(events).Should(BeClosed())
+			})
+		})
+	})
+
+})
+
+var _ = Describe("conflictTracker", func() {
+
+	var (
+		now     time.Time
+		tracker *conflictTracker
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		tracker = newConflictTracker(Conflicts{Threshold: 3, Window: time.Minute, MaxBackoff: 30 * time.Second}, "conflict0")
+		tracker.now = func() time.Time { return now }
+	})
+
+	repair := func(after time.Duration) {
+		now = now.Add(after)
+		tracker.update()
+		tracker.record(RepairAddress)
+	}
+
+	It("should only count the repairs within the window", func() {
+		repair(0)
+		repair(40 * time.Second)
+		repair(40 * time.Second)
+
+		Expect(tracker.status()).To(BeNil())
+
+		repair(time.Second)
+
+		Expect(tracker.status()).ToNot(BeNil())
+		Expect(tracker.status().Repairs).To(Equal(3))
+		Expect(testutil.ToFloat64(metrics.AddressConflict.WithLabelValues("conflict0"))).To(Equal(1.0))
+	})
+
+	It("should double the backoff up to the maximum", func() {
+		for range 3 {
+			repair(time.Second)
+		}
+		Expect(tracker.backoff()).To(Equal(initialConflictBackoff))
+
+		repair(initialConflictBackoff)
+		Expect(tracker.backoff()).To(Equal(20 * time.Second))
+
+		repair(20 * time.Second)
+		Expect(tracker.backoff()).To(Equal(30 * time.Second))
+
+		now = now.Add(10 * time.Second)
+		Expect(tracker.backoff()).To(Equal(20 * time.Second))
+		Expect(*tracker.status().BackoffUntil).To(Equal(now.Add(20 * time.Second)))
+	})
+
+	It("should resolve the conflict once no repair was needed for a whole window", func() {
+		for range 3 {
+			repair(time.Second)
+		}
+
+		now = now.Add(50 * time.Second)
+		tracker.skipped()
+		now = now.Add(50 * time.Second)
+		tracker.update()
+		Expect(tracker.status()).ToNot(BeNil())
+
+		now = now.Add(10 * time.Second)
+		tracker.update()
+		Expect(tracker.status()).To(BeNil())
+		Expect(tracker.backoff()).To(BeZero())
+		Expect(testutil.ToFloat64(metrics.AddressConflict.WithLabelValues("conflict0"))).To(BeZero())
+	})
+
+	It("should not detect conflicts if disabled", func() {
+		tracker.Threshold = 0
+		for range 5 {
+			repair(time.Second)
+		}
+
+		Expect(tracker.status()).To(BeNil())
+	})
 })
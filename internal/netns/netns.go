@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package netns provides access to a network namespace other than the one of the sidecar.
+package netns
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+)
+
+// Namespace is a network namespace given by a path, e.g. /var/run/netns/<name>, or the PID of a process in it.
+// A nil *Namespace is the network namespace of the sidecar itself.
+type Namespace struct {
+	ref    string
+	handle netns.NsHandle
+}
+
+// Open opens the network namespace given by a path or PID. It returns nil for an empty ref.
+func Open(ref string) (*Namespace, error) {
+	if ref == "" {
+		return nil, nil
+	}
+
+	path := ref
+	if pid, err := strconv.Atoi(ref); err == nil {
+		path = fmt.Sprintf("/proc/%d/ns/net", pid)
+	}
+
+	handle, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, xerrors.Errorf("could not open network namespace %q: %v", ref, err)
+	}
+
+	return &Namespace{ref: ref, handle: handle}, nil
+}
+
+// Handle returns the file handle of the namespace.
+func (n *Namespace) Handle() netns.NsHandle {
+	return n.handle
+}
+
+// String returns the path or PID the namespace was opened with.
+func (n *Namespace) String() string {
+	if n == nil {
+		return "current"
+	}
+
+	return n.ref
+}
+
+// Do runs fn on an OS thread switched to the namespace, so that the sockets created and the processes started
+// by fn belong to it. Goroutines started by fn do not.
+func (n *Namespace) Do(fn func() error) error {
+	if n == nil {
+		return fn()
+	}
+
+	runtime.LockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return xerrors.Errorf("could not get current network namespace: %v", err)
+	}
+	defer orig.Close()
+
+	if err := netns.Set(n.handle); err != nil {
+		runtime.UnlockOSThread()
+		return xerrors.Errorf("could not switch to network namespace %q: %v", n.ref, err)
+	}
+
+	defer func() {
+		if err := netns.Set(orig); err != nil {
+			// the thread stays locked, so that it is terminated with the goroutine instead of being reused
+			klog.Errorf("Could not switch back from network namespace %q: %v", n.ref, err)
+			return
+		}
+
+		runtime.UnlockOSThread()
+	}()
+
+	return fn()
+}
+
+// Close closes the file handle of the namespace.
+func (n *Namespace) Close() error {
+	if n == nil {
+		return nil
+	}
+
+	return n.handle.Close()
+}
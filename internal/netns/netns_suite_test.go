@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package netns
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netns"
+)
+
+func TestNetns(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Netns Suite")
+}
+
+var _ = Describe("Namespace", func() {
+
+	It("should return nil for an empty reference", func() {
+		ns, err := Open("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ns).To(BeNil())
+		Expect(ns.String()).To(Equal("current"))
+		Expect(ns.Close()).To(Succeed())
+	})
+
+	It("should run functions directly for the current namespace", func() {
+		var ns *Namespace
+		called := false
+
+		Expect(ns.Do(func() error {
+			called = true
+			return nil
+		})).To(Succeed())
+		Expect(called).To(BeTrue())
+	})
+
+	It("should fail for a namespace which does not exist", func() {
+		_, err := Open("/does/not/exist")
+		Expect(err).To(MatchError(ContainSubstring(`could not open network namespace "/does/not/exist"`)))
+	})
+
+	It("should open the namespace of a process by its PID", func() {
+		ns, err := Open(strconv.Itoa(os.Getpid()))
+		Expect(err).ToNot(HaveOccurred())
+		defer ns.Close()
+
+		self, err := netns.GetFromPath("/proc/self/ns/net")
+		Expect(err).ToNot(HaveOccurred())
+		defer self.Close()
+
+		Expect(ns.Handle().Equal(self)).To(BeTrue())
+
+		err = ns.Do(func() error {
+			current, err := netns.Get()
+			if err != nil {
+				return err
+			}
+			defer current.Close()
+
+			Expect(current.Equal(self)).To(BeTrue())
+			return nil
+		})
+		if err != nil && strings.Contains(err.Error(), syscall.EPERM.Error()) {
+			Skip("switching network namespaces is not permitted")
+		}
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+
+	"github.com/gardener/apiserver-proxy/internal/netns"
+)
+
+// Connections returns the number of established TCP connections to the port on the given ip addresses in the
+// given network namespace, the current one if nil. The sockets are listed with sock_diag, so that the connections
+// of any proxy listening on the addresses are counted and not only the ones of the embedded proxy.
+func Connections(ns *netns.Namespace, ips []netip.Addr, port uint16) (int, error) {
+	var families []uint8
+	for _, ip := range ips {
+		family := uint8(unix.AF_INET6)
+		if ip.Is4() {
+			family = unix.AF_INET
+		}
+		if !slices.Contains(families, family) {
+			families = append(families, family)
+		}
+	}
+
+	var sockets []*netlink.Socket
+	// the netlink socket is created in the namespace, so that its TCP sockets are listed
+	if err := ns.Do(func() error {
+		for _, family := range families {
+			s, err := netlink.SocketDiagTCP(family)
+			// an interrupted dump is incomplete, which is good enough for counting the connections
+			if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+				return xerrors.Errorf("could not list TCP sockets: %v", err)
+			}
+			sockets = append(sockets, s...)
+		}
+
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, s := range sockets {
+		if s.State != netlink.TCP_ESTABLISHED || s.ID.SourcePort != port {
+			continue
+		}
+
+		if source, ok := netip.AddrFromSlice(s.ID.Source); ok && slices.Contains(ips, source.Unmap()) {
+			count++
+		}
+	}
+
+	return count, nil
+}
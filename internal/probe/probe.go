@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/netns"
+)
+
+const (
+	// ModeNone disables probing.
+	ModeNone = "none"
+	// ModeTCP probes by establishing a TCP connection.
+	ModeTCP = "tcp"
+	// ModeTLS probes by establishing a TCP connection and performing a TLS handshake.
+	ModeTLS = "tls"
+)
+
+// Prober checks whether the proxy is listening.
+type Prober interface {
+	// Probe probes all addresses and returns an error for every address which is not reachable.
+	Probe(ctx context.Context) error
+}
+
+// Result is called with the result of probing a single address.
+type Result func(address string, err error)
+
+// proberDefault is the default implementation probing the addresses over TCP.
+type proberDefault struct {
+	addresses []string
+	tls       bool
+	timeout   time.Duration
+	result    Result
+	ns        *netns.Namespace
+}
+
+// NewProber returns a new Prober for the given mode which probes the port on all given ip addresses from within
+// the given network namespace, the current one if nil. It returns nil if the mode is ModeNone. The result is called
+// after every probed address.
+func NewProber(mode string, ns *netns.Namespace, ips []netip.Addr, port uint16, timeout time.Duration, result Result) (Prober, error) {
+	p := &proberDefault{timeout: timeout, result: result, ns: ns}
+
+	switch mode {
+	case ModeNone:
+		return nil, nil
+	case ModeTCP:
+	case ModeTLS:
+		p.tls = true
+	default:
+		return nil, xerrors.Errorf("unknown probe mode %q, must be one of %q, %q or %q", mode, ModeNone, ModeTCP, ModeTLS)
+	}
+
+	for _, ip := range ips {
+		p.addresses = append(p.addresses, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+
+	return p, nil
+}
+
+// Probe probes all addresses.
+func (p *proberDefault) Probe(ctx context.Context) error {
+	var errs []error
+	for _, address := range p.addresses {
+		err := p.probe(ctx, address)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			klog.V(4).Infof("Proxy on %q is reachable", address)
+		}
+
+		if p.result != nil {
+			p.result(address, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *proberDefault) probe(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	// the socket is created in the namespace, so that the connection stays in it
+	if nsErr := p.ns.Do(func() error {
+		dialer := &net.Dialer{}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+		return nil
+	}); nsErr != nil {
+		return nsErr
+	}
+	if err != nil {
+		return xerrors.Errorf("could not connect to proxy on %q: %v", address, err)
+	}
+	defer conn.Close()
+
+	if !p.tls {
+		return nil
+	}
+
+	// #nosec G402 -- only the handshake is checked, the identity of the proxy is not relevant.
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return xerrors.Errorf("could not perform TLS handshake with proxy on %q: %v", address, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestProbe(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Probe Suite")
+}
+
+var _ = Describe("Prober", func() {
+
+	var (
+		ip      = netip.MustParseAddr("127.0.0.1")
+		results map[string]error
+		result  Result
+	)
+
+	BeforeEach(func() {
+		results = map[string]error{}
+		result = func(address string, err error) {
+			results[address] = err
+		}
+	})
+
+	portOf := func(address string) uint16 {
+		return netip.MustParseAddrPort(address).Port()
+	}
+
+	Describe("NewProber", func() {
+		It("should return no Prober if probing is disabled", func() {
+			p, err := NewProber(ModeNone, nil, []netip.Addr{ip}, 443, time.Second, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+		})
+
+		It("should return error for an unknown mode", func() {
+			_, err := NewProber("foo", nil, []netip.Addr{ip}, 443, time.Second, nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should probe the port on all addresses", func() {
+			p, err := NewProber(ModeTCP, nil, []netip.Addr{ip, netip.MustParseAddr("fd00::3")}, 443, time.Second, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.(*proberDefault).addresses).To(Equal([]string{"127.0.0.1:443", "[fd00::3]:443"}))
+		})
+	})
+
+	Describe("tcp", func() {
+		It("should succeed if the proxy is listening", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer l.Close()
+
+			p, err := NewProber(ModeTCP, nil, []netip.Addr{ip}, portOf(l.Addr().String()), time.Second, result)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(p.Probe(context.Background())).To(Succeed())
+			Expect(results).To(HaveKeyWithValue(l.Addr().String(), BeNil()))
+		})
+
+		It("should fail if the proxy is not listening", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.Close()).To(Succeed())
+
+			p, err := NewProber(ModeTCP, nil, []netip.Addr{ip}, portOf(l.Addr().String()), time.Second, result)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(p.Probe(context.Background())).ToNot(Succeed())
+			Expect(results).To(HaveKeyWithValue(l.Addr().String(), HaveOccurred()))
+		})
+	})
+
+	Describe("tls", func() {
+		It("should succeed if the proxy performs the handshake", func() {
+			server := httptest.NewTLSServer(http.NotFoundHandler())
+			defer server.Close()
+
+			p, err := NewProber(ModeTLS, nil, []netip.Addr{ip}, portOf(server.Listener.Addr().String()), time.Second, result)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(p.Probe(context.Background())).To(Succeed())
+		})
+
+		It("should fail if the proxy does not perform the handshake", func() {
+			server := httptest.NewServer(http.NotFoundHandler())
+			defer server.Close()
+
+			p, err := NewProber(ModeTLS, nil, []netip.Addr{ip}, portOf(server.Listener.Addr().String()), time.Second, result)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(p.Probe(context.Background())).ToNot(Succeed())
+		})
+	})
+	Describe("Connections", func() {
+		It("should count the established connections to the port on the addresses", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer l.Close()
+			port := portOf(l.Addr().String())
+
+			Expect(Connections(nil, []netip.Addr{ip}, port)).To(BeZero())
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			accepted, err := l.Accept()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(Connections(nil, []netip.Addr{ip}, port)).To(Equal(1))
+			Expect(Connections(nil, []netip.Addr{netip.MustParseAddr("127.0.0.2")}, port)).To(BeZero())
+
+			Expect(accepted.Close()).To(Succeed())
+			Expect(Connections(nil, []netip.Addr{ip}, port)).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// HealthCheckTCP checks an upstream by establishing a TCP connection.
+	HealthCheckTCP = "tcp"
+	// HealthCheckTLS checks an upstream by establishing a TCP connection and performing a TLS handshake.
+	HealthCheckTLS = "tls"
+	// HealthCheckHTTPS checks an upstream by requesting its /readyz endpoint.
+	HealthCheckHTTPS = "https"
+)
+
+// HealthCheck configures how an upstream is checked.
+type HealthCheck struct {
+	// Mode is how the upstream is checked, HealthCheckTCP if empty.
+	Mode string
+	// TokenFile is the path of a file containing the bearer token sent to the /readyz endpoint (https only).
+	// It is read for every check, so that rotated tokens are picked up. Requires the CAFile, so that the token is
+	// never sent to an unverified upstream.
+	TokenFile string
+	// CAFile is the path of a file containing the CA certificates the upstream is verified with (tls and https).
+	// The certificate of the upstream is not verified if empty.
+	CAFile string
+}
+
+// validate validates the health check configuration.
+func (hc HealthCheck) validate() error {
+	switch hc.Mode {
+	case "", HealthCheckTCP:
+		if hc.TokenFile != "" || hc.CAFile != "" {
+			return xerrors.Errorf("a token or CA file requires the health check %s or %s", HealthCheckTLS, HealthCheckHTTPS)
+		}
+	case HealthCheckTLS:
+		if hc.TokenFile != "" {
+			return xerrors.Errorf("a token file requires the health check %s", HealthCheckHTTPS)
+		}
+	case HealthCheckHTTPS:
+		if hc.TokenFile != "" && hc.CAFile == "" {
+			return xerrors.New("a token file requires a CA file to verify the upstream with")
+		}
+	default:
+		return xerrors.Errorf("unsupported health check %q, must be %s, %s or %s", hc.Mode, HealthCheckTCP, HealthCheckTLS, HealthCheckHTTPS)
+	}
+
+	return nil
+}
+
+// check checks whether the upstream is reachable as configured by its health check.
+func (u *upstream) check(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch u.HealthCheck.Mode {
+	case HealthCheckTLS:
+		return u.checkTLS(ctx)
+	case HealthCheckHTTPS:
+		return u.checkHTTPS(ctx)
+	default:
+		conn, err := u.dialContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+}
+
+func (u *upstream) checkTLS(ctx context.Context) error {
+	tlsConfig, err := u.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	conn, err := u.dialContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := tls.Client(conn, tlsConfig).HandshakeContext(ctx); err != nil {
+		return xerrors.Errorf("could not perform TLS handshake: %v", err)
+	}
+
+	return nil
+}
+
+func (u *upstream) checkHTTPS(ctx context.Context) error {
+	tlsConfig, err := u.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+u.Address+"/readyz", nil)
+	if err != nil {
+		return err
+	}
+
+	// never send the token to an unverified upstream, even if the health check was not validated
+	if u.HealthCheck.TokenFile != "" && u.HealthCheck.CAFile != "" {
+		token, err := os.ReadFile(u.HealthCheck.TokenFile)
+		if err != nil {
+			return xerrors.Errorf("could not read token file: %v", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext:       func(ctx context.Context, _, _ string) (net.Conn, error) { return u.dialContext(ctx) },
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("/readyz returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// dialContext connects to the upstream for a health check. If the upstream expects the PROXY protocol, a
+// header without addresses is sent, as the connection is not forwarded on behalf of a client.
+func (u *upstream) dialContext(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "tcp", u.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if header := u.ProxyProtocol.localHeader(); len(header) > 0 {
+		if _, err := conn.Write(header); err != nil {
+			_ = conn.Close()
+			return nil, xerrors.Errorf("could not send PROXY protocol header: %v", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// tlsConfig returns the TLS configuration for checking the upstream. The certificate is verified for the
+// first server name of the upstream, or its host if it has none.
+func (u *upstream) tlsConfig() (*tls.Config, error) {
+	serverName, _, _ := net.SplitHostPort(u.Address)
+	if len(u.ServerNames) > 0 {
+		serverName = u.ServerNames[0]
+	}
+
+	if u.HealthCheck.CAFile == "" {
+		// #nosec G402 -- the CA is not configured, only the reachability of the upstream is checked.
+		return &tls.Config{ServerName: serverName, InsecureSkipVerify: true}, nil
+	}
+
+	ca, err := os.ReadFile(u.HealthCheck.CAFile)
+	if err != nil {
+		return nil, xerrors.Errorf("could not read CA file: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, xerrors.Errorf("no certificates found in CA file %s", u.HealthCheck.CAFile)
+	}
+
+	return &tls.Config{ServerName: serverName, RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
@@ -0,0 +1,445 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package proxy implements a TCP proxy forwarding the connections to the address of the apiserver-proxy to the
+// kube-apiservers, so that no separate proxy is needed, e.g. for small clusters.
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+	"github.com/gardener/apiserver-proxy/internal/netns"
+)
+
+const (
+	// LoadBalancingRoundRobin distributes the connections evenly over the upstreams.
+	LoadBalancingRoundRobin = "round-robin"
+	// LoadBalancingLeastConnections forwards the connections to the upstream with the fewest active connections.
+	LoadBalancingLeastConnections = "least-connections"
+	// LoadBalancingFailover forwards the connections to the first upstream, the others are only used if it fails.
+	LoadBalancingFailover = "failover"
+)
+
+// Config configures the proxy.
+type Config struct {
+	// Upstreams are the kube-apiserver endpoints, for LoadBalancingFailover in the order they are preferred.
+	Upstreams []Upstream
+	// LoadBalancing is how the connections are distributed over the healthy upstreams, LoadBalancingRoundRobin
+	// if empty.
+	LoadBalancing string
+	// DialTimeout is the timeout for connecting to an upstream, reading the TLS ClientHello of a client and
+	// the health checks.
+	DialTimeout time.Duration
+	// HealthCheckInterval is the interval in which the upstreams are checked.
+	HealthCheckInterval time.Duration
+	// UnhealthyThreshold is the number of consecutive failed health checks or connection attempts after which
+	// an upstream is ejected until the next successful health check.
+	UnhealthyThreshold int
+}
+
+// Proxy forwards the TCP connections it accepts on the listen addresses to the upstreams without terminating
+// them. Connections carrying a TLS server name (SNI) which is served by some upstreams are forwarded to those,
+// all others to the upstreams without server names. The connections are distributed over the healthy upstreams
+// as configured; ejected upstreams are skipped, unless all are unhealthy.
+// If configured for an upstream, the address of the client is sent to it with the PROXY protocol.
+type Proxy struct {
+	cfg       Config
+	ns        *netns.Namespace
+	upstreams []*upstream
+	// next is the number of the next connection for round-robin load balancing
+	next atomic.Uint64
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	conns     map[net.Conn]struct{}
+	closing   bool
+	// handlers tracks the goroutines serving the listeners and connections
+	handlers sync.WaitGroup
+}
+
+// New returns a new Proxy forwarding to the upstreams of the given config, which listens in the given network
+// namespace, the current one if nil. The upstreams are connected to from the current network namespace.
+func New(cfg Config, ns *netns.Namespace) (*Proxy, error) {
+	if len(cfg.Upstreams) == 0 {
+		return nil, xerrors.Errorf("at least one upstream is required")
+	}
+
+	if cfg.DialTimeout <= 0 || cfg.HealthCheckInterval <= 0 || cfg.UnhealthyThreshold <= 0 {
+		return nil, xerrors.Errorf("the dial timeout, health check interval and unhealthy threshold must be positive")
+	}
+
+	switch cfg.LoadBalancing {
+	case "":
+		cfg.LoadBalancing = LoadBalancingRoundRobin
+	case LoadBalancingRoundRobin, LoadBalancingLeastConnections, LoadBalancingFailover:
+	default:
+		return nil, xerrors.Errorf("unsupported load balancing %q, must be %s, %s or %s", cfg.LoadBalancing,
+			LoadBalancingRoundRobin, LoadBalancingLeastConnections, LoadBalancingFailover)
+	}
+
+	for i, u := range cfg.Upstreams {
+		if _, _, err := net.SplitHostPort(u.Address); err != nil {
+			return nil, xerrors.Errorf("invalid upstream address %q: %v", u.Address, err)
+		}
+
+		if err := u.ProxyProtocol.validate(); err != nil {
+			return nil, xerrors.Errorf("invalid upstream %q: %v", u.Address, err)
+		}
+
+		if err := u.HealthCheck.validate(); err != nil {
+			return nil, xerrors.Errorf("invalid upstream %q: %v", u.Address, err)
+		}
+
+		for _, other := range cfg.Upstreams[:i] {
+			if other.Address == u.Address && (!other.ProxyProtocol.equal(u.ProxyProtocol) || other.HealthCheck != u.HealthCheck) {
+				return nil, xerrors.Errorf("upstream %q is given with different PROXY protocol or health check configurations", u.Address)
+			}
+		}
+	}
+
+	return &Proxy{
+		cfg:       cfg,
+		ns:        ns,
+		upstreams: mergeUpstreams(cfg.Upstreams, cfg.UnhealthyThreshold),
+		listeners: map[string]net.Listener{},
+		conns:     map[net.Conn]struct{}{},
+	}, nil
+}
+
+// Listen makes the proxy listen on exactly the given addresses, i.e. it starts listening on the new ones and
+// stops listening on the ones which are not given anymore, without closing the connections accepted on them.
+// The addresses do not need to be present on an interface yet.
+func (p *Proxy) Listen(addresses []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closing {
+		return xerrors.Errorf("could not listen, the proxy is shut down")
+	}
+
+	for address, l := range p.listeners {
+		if !slices.Contains(addresses, address) {
+			klog.Infof("Proxy stops listening on %q", address)
+			_ = l.Close()
+			delete(p.listeners, address)
+		}
+	}
+
+	var errs []error
+	for _, address := range addresses {
+		if _, ok := p.listeners[address]; ok {
+			continue
+		}
+
+		l, err := p.listen(address)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		klog.Infof("Proxy listening on %q", address)
+		p.listeners[address] = l
+
+		p.handlers.Add(1)
+		go p.serve(l)
+	}
+
+	return errors.Join(errs...)
+}
+
+// listen listens on the address in the network namespace of the proxy. The socket is bound with IP_FREEBIND,
+// so that the proxy can listen before the address is added to the interface.
+func (p *Proxy) listen(address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: freebind}
+
+	var (
+		l   net.Listener
+		err error
+	)
+	// the socket is created in the namespace, so that it stays bound to it
+	if nsErr := p.ns.Do(func() error {
+		l, err = lc.Listen(context.Background(), "tcp", address)
+		return nil
+	}); nsErr != nil {
+		return nil, nsErr
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("could not listen on %q: %v", address, err)
+	}
+
+	return l, nil
+}
+
+func freebind(network, _ string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_FREEBIND, 1)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND, 1)
+		}
+	}); err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+// serve accepts the connections on the listener until it is closed.
+func (p *Proxy) serve(l net.Listener) {
+	defer p.handlers.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				klog.Errorf("Proxy stopped accepting connections on %q: %v", l.Addr(), err)
+			}
+
+			return
+		}
+
+		if !p.track(conn) {
+			_ = conn.Close()
+			return
+		}
+
+		p.handlers.Add(1)
+		go p.handle(conn)
+	}
+}
+
+// track tracks the connection, so that it is closed if draining the connections times out. It reports
+// whether the connection was tracked, i.e. the proxy is not shut down.
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closing {
+		return false
+	}
+
+	p.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.conns, conn)
+}
+
+// handle forwards the client connection to an upstream.
+func (p *Proxy) handle(client net.Conn) {
+	defer p.handlers.Done()
+	defer p.untrack(client)
+	defer client.Close()
+
+	metrics.ProxyConnectionsActive.Inc()
+	defer metrics.ProxyConnectionsActive.Dec()
+
+	_ = client.SetReadDeadline(time.Now().Add(p.cfg.DialTimeout))
+	serverName, hello := readServerName(client)
+	_ = client.SetReadDeadline(time.Time{})
+
+	u, conn := p.dial(serverName)
+	if conn == nil {
+		klog.Errorf("Proxy could not forward connection from %q with server name %q, no upstream is reachable", client.RemoteAddr(), serverName)
+		metrics.ProxyConnections.WithLabelValues("none").Inc()
+
+		return
+	}
+
+	metrics.ProxyConnections.WithLabelValues(u.Address).Inc()
+
+	u.active.Add(1)
+	defer u.active.Add(-1)
+
+	if !p.track(conn) {
+		_ = conn.Close()
+		return
+	}
+	defer p.untrack(conn)
+	defer conn.Close()
+
+	klog.V(4).Infof("Proxy forwarding connection from %q with server name %q to %q", client.RemoteAddr(), serverName, u.Address)
+
+	// the PROXY protocol header carries the address of the client and the one it connected to
+	header := u.ProxyProtocol.header(client.RemoteAddr(), client.LocalAddr(), serverName)
+	if _, err := conn.Write(append(header, hello...)); err != nil {
+		klog.V(2).Infof("Proxy could not forward connection to %q: %v", u.Address, err)
+		return
+	}
+
+	pipe(client, conn)
+}
+
+// dial connects to the first reachable upstream for the server name.
+func (p *Proxy) dial(serverName string) (*upstream, net.Conn) {
+	for _, u := range p.candidates(serverName) {
+		dialer := &net.Dialer{Timeout: p.cfg.DialTimeout}
+
+		conn, err := dialer.Dial("tcp", u.Address)
+		if err != nil {
+			klog.Warningf("Proxy could not connect to upstream %q: %v", u.Address, err)
+			u.record(err)
+
+			continue
+		}
+
+		return u, conn
+	}
+
+	return nil, nil
+}
+
+// candidates returns the upstreams for the server name in the order they are tried. These are the healthy
+// upstreams serving the server name or, if there are none, the fallback ones given without server names. If
+// none of them is healthy, all of them are tried, as the health checks may be wrong. The upstreams are ordered
+// by the load balancing, so that the connection goes to the first one and the others are only tried if it fails.
+func (p *Proxy) candidates(serverName string) []*upstream {
+	var serving, others []*upstream
+	for _, u := range p.upstreams {
+		switch {
+		case serverName != "" && u.serves(serverName):
+			serving = append(serving, u)
+		case u.fallback:
+			others = append(others, u)
+		}
+	}
+
+	if len(serving) == 0 {
+		serving = others
+	}
+
+	healthy := slices.DeleteFunc(slices.Clone(serving), func(u *upstream) bool { return !u.healthy.Load() })
+	if len(healthy) == 0 {
+		healthy = slices.Clone(serving)
+	}
+
+	return p.balance(healthy)
+}
+
+// balance orders the upstreams by the configured load balancing.
+func (p *Proxy) balance(upstreams []*upstream) []*upstream {
+	if len(upstreams) < 2 || p.cfg.LoadBalancing == LoadBalancingFailover {
+		return upstreams
+	}
+
+	// rotating the upstreams by the number of the connection distributes them evenly, also if the
+	// least-connections ordering is tied
+	n := (p.next.Add(1) - 1) % uint64(len(upstreams))
+	upstreams = slices.Concat(upstreams[n:], upstreams[:n])
+
+	if p.cfg.LoadBalancing == LoadBalancingLeastConnections {
+		slices.SortStableFunc(upstreams, func(a, b *upstream) int {
+			return cmp.Compare(a.active.Load(), b.active.Load())
+		})
+	}
+
+	return upstreams
+}
+
+// pipe copies the data between the connections in both directions until both are done.
+func pipe(client, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+
+	cp := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+
+		// propagate the end of the stream, so that the other direction can still finish
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+
+		done <- struct{}{}
+	}
+
+	go cp(upstream, client)
+	go cp(client, upstream)
+
+	<-done
+	<-done
+}
+
+// Run checks the health of the upstreams in the configured interval until the context is done.
+func (p *Proxy) Run(ctx context.Context) {
+	tick := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer tick.Stop()
+
+	for {
+		for _, u := range p.upstreams {
+			err := u.check(ctx, p.cfg.DialTimeout)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+
+			u.record(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Shutdown stops listening and waits for the active connections to finish until the context is done,
+// after which the remaining connections are closed.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closing = true
+	for address, l := range p.listeners {
+		_ = l.Close()
+		delete(p.listeners, address)
+	}
+	active := len(p.conns)
+	p.mu.Unlock()
+
+	if active > 0 {
+		klog.Infof("Proxy draining connections")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	klog.Warningf("Proxy closing %d connections which were not drained in time", len(p.conns))
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.mu.Unlock()
+
+	<-done
+
+	return ctx.Err()
+}
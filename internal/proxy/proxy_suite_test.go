@@ -0,0 +1,606 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestProxy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Proxy Suite")
+}
+
+// fakeAPIServer is a local listener standing in for a kube-apiserver. It echoes lines and records the server
+// name of the TLS ClientHellos it receives.
+type fakeAPIServer struct {
+	listener    net.Listener
+	serverNames chan string
+}
+
+func newFakeAPIServer() *fakeAPIServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+	s := &fakeAPIServer{listener: l, serverNames: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.handle(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeAPIServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+
+	// 0x16 is the content type of a TLS handshake record
+	if first[0] == 0x16 {
+		serverName, _ := readServerName(bufferedConn{Conn: conn, r: r})
+		s.serverNames <- serverName
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if _, err := conn.Write([]byte(s.listener.Addr().String() + " " + line)); err != nil {
+			return
+		}
+	}
+}
+
+// bufferedConn reads the data peeked by r first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (s *fakeAPIServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeAPIServer) close() {
+	_ = s.listener.Close()
+}
+
+var _ = Describe("ParseUpstream", func() {
+	It("should parse an upstream without server name", func() {
+		Expect(ParseUpstream("10.0.0.1:443")).To(Equal(Upstream{Address: "10.0.0.1:443"}))
+	})
+
+	It("should parse an upstream with server name", func() {
+		Expect(ParseUpstream("api.example.com=[fd00::1]:443")).
+			To(Equal(Upstream{Address: "[fd00::1]:443", ServerNames: []string{"api.example.com"}}))
+	})
+
+	It("should parse the PROXY protocol options", func() {
+		Expect(ParseUpstream("api.example.com=10.0.0.1:443?proxy-protocol=v2&authority=true&tlv=0xe0:node-1&tlv=225:a%3Ab")).
+			To(Equal(Upstream{
+				Address:     "10.0.0.1:443",
+				ServerNames: []string{"api.example.com"},
+				ProxyProtocol: ProxyProtocol{
+					Version:   ProxyProtocolV2,
+					Authority: true,
+					TLVs:      []TLV{{Type: 0xe0, Value: []byte("node-1")}, {Type: 0xe1, Value: []byte("a:b")}},
+				},
+			}))
+	})
+
+	It("should parse the health check options", func() {
+		Expect(ParseUpstream("10.0.0.1:443?health-check=https&token-file=%2Fvar%2Frun%2Ftoken&ca-file=%2Fca.crt")).
+			To(Equal(Upstream{
+				Address:     "10.0.0.1:443",
+				HealthCheck: HealthCheck{Mode: HealthCheckHTTPS, TokenFile: "/var/run/token", CAFile: "/ca.crt"},
+			}))
+	})
+
+	It("should reject invalid upstreams", func() {
+		for _, s := range []string{
+			"10.0.0.1", "=10.0.0.1:443", "api.example.com=",
+			"10.0.0.1:443?proxy-protocol=v3", "10.0.0.1:443?proxy-protocol=v1&tlv=0xe0:foo",
+			"10.0.0.1:443?proxy-protocol=v2&tlv=256:foo", "10.0.0.1:443?proxy-protocol=v2&tlv=foo", "10.0.0.1:443?foo=bar",
+			"10.0.0.1:443?health-check=http", "10.0.0.1:443?health-check=tls&token-file=/token", "10.0.0.1:443?ca-file=/ca.crt",
+			"10.0.0.1:443?health-check=https&token-file=/token",
+		} {
+			_, err := ParseUpstream(s)
+			Expect(err).To(HaveOccurred(), s)
+		}
+	})
+})
+
+var _ = Describe("ProxyProtocol", func() {
+
+	var (
+		src4 = &net.TCPAddr{IP: net.ParseIP("10.1.0.5"), Port: 51234}
+		dst4 = &net.TCPAddr{IP: net.ParseIP("10.96.0.2"), Port: 443}
+		src6 = &net.TCPAddr{IP: net.ParseIP("fd01::5"), Port: 51234}
+		dst6 = &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 443}
+	)
+
+	It("should not send a header without version", func() {
+		Expect(ProxyProtocol{}.header(src4, dst4, "")).To(BeEmpty())
+	})
+
+	It("should send a header of version 1", func() {
+		pp := ProxyProtocol{Version: ProxyProtocolV1}
+
+		Expect(string(pp.header(src4, dst4, ""))).To(Equal("PROXY TCP4 10.1.0.5 10.96.0.2 51234 443\r\n"))
+		Expect(string(pp.header(src6, dst6, ""))).To(Equal("PROXY TCP6 fd01::5 fd00::2 51234 443\r\n"))
+		Expect(string(pp.header(src4, dst6, ""))).To(Equal("PROXY UNKNOWN\r\n"))
+	})
+
+	It("should send a header of version 2", func() {
+		pp := ProxyProtocol{Version: ProxyProtocolV2}
+
+		Expect(pp.header(src4, dst4, "")).To(Equal(append(slices.Clone(v2Signature),
+			0x21, 0x11, 0x00, 0x0c,
+			10, 1, 0, 5,
+			10, 96, 0, 2,
+			0xc8, 0x22,
+			0x01, 0xbb,
+		)))
+
+		header := pp.header(src6, dst6, "")
+		Expect(header[12:16]).To(Equal([]byte{0x21, 0x21, 0x00, 0x24}))
+		Expect(header[16:32]).To(Equal([]byte(net.ParseIP("fd01::5"))))
+		Expect(header[32:48]).To(Equal([]byte(net.ParseIP("fd00::2"))))
+
+		Expect(pp.header(src4, dst6, "")).To(Equal(append(slices.Clone(v2Signature), 0x21, 0x00, 0x00, 0x00)))
+	})
+
+	It("should send the TLVs with a header of version 2", func() {
+		pp := ProxyProtocol{Version: ProxyProtocolV2, Authority: true, TLVs: []TLV{{Type: 0xe0, Value: []byte("node-1")}}}
+
+		header := pp.header(src4, dst4, "api")
+		Expect(header[14:16]).To(Equal([]byte{0x00, 12 + 6 + 9}))
+		Expect(header[28:]).To(Equal([]byte{
+			0x02, 0x00, 0x03, 'a', 'p', 'i',
+			0xe0, 0x00, 0x06, 'n', 'o', 'd', 'e', '-', '1',
+		}))
+
+		// the authority is only sent for connections with a server name
+		Expect(pp.header(src4, dst4, "")[28:]).To(Equal([]byte{0xe0, 0x00, 0x06, 'n', 'o', 'd', 'e', '-', '1'}))
+		Expect(pp.header(src4, dst4, strings.Repeat("a", 256))[28:]).To(Equal([]byte{0xe0, 0x00, 0x06, 'n', 'o', 'd', 'e', '-', '1'}))
+	})
+
+	It("should reject TLVs which do not fit a header of version 2", func() {
+		pp := ProxyProtocol{Version: ProxyProtocolV2, Authority: true, TLVs: []TLV{{Type: 0xe0, Value: make([]byte, maxTLVsLength-3)}}}
+		Expect(pp.validate()).To(Succeed())
+
+		header := pp.header(src6, dst6, strings.Repeat("a", maxAuthorityLength))
+		Expect(len(header)).To(Equal(16 + math.MaxUint16))
+		Expect(header[14:16]).To(Equal([]byte{0xff, 0xff}))
+
+		pp.TLVs = append(pp.TLVs, TLV{Type: 0xe1})
+		Expect(pp.validate()).To(MatchError(ContainSubstring("must be at most")))
+
+		_, err := ParseUpstream("10.0.0.1:443?proxy-protocol=v2&tlv=0xe0:" + strings.Repeat("a", math.MaxUint16))
+		Expect(err).To(MatchError(ContainSubstring("must be at most")))
+	})
+
+	It("should send a header without addresses for local connections", func() {
+		Expect(ProxyProtocol{}.localHeader()).To(BeEmpty())
+		Expect(string(ProxyProtocol{Version: ProxyProtocolV1}.localHeader())).To(Equal("PROXY UNKNOWN\r\n"))
+		Expect(ProxyProtocol{Version: ProxyProtocolV2, Authority: true}.localHeader()).
+			To(Equal(append(slices.Clone(v2Signature), 0x20, 0x00, 0x00, 0x00)))
+	})
+})
+
+var _ = Describe("Proxy", func() {
+
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		servers []*fakeAPIServer
+		p       *Proxy
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		servers = []*fakeAPIServer{newFakeAPIServer(), newFakeAPIServer()}
+	})
+
+	AfterEach(func() {
+		cancel()
+		if p != nil {
+			Expect(p.Shutdown(context.Background())).To(Succeed())
+		}
+		for _, s := range servers {
+			s.close()
+		}
+	})
+
+	newProxyWith := func(cfg Config) string {
+		cfg.DialTimeout = 200 * time.Millisecond
+		cfg.HealthCheckInterval = time.Hour
+		if cfg.UnhealthyThreshold == 0 {
+			cfg.UnhealthyThreshold = 1
+		}
+
+		var err error
+		p, err = New(cfg, nil)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		ExpectWithOffset(1, p.Listen([]string{"127.0.0.1:0"})).To(Succeed())
+
+		return p.listeners["127.0.0.1:0"].Addr().String()
+	}
+
+	newProxy := func(upstreams ...Upstream) string {
+		return newProxyWith(Config{Upstreams: upstreams, LoadBalancing: LoadBalancingFailover})
+	}
+
+	connect := func(address string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", address)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	// echo sends a line and returns the address of the fake apiserver answering it
+	echo := func(conn net.Conn, r *bufio.Reader) string {
+		_, err := conn.Write([]byte("ping\n"))
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+		line, err := r.ReadString('\n')
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+
+		return line
+	}
+
+	// hello sends a TLS ClientHello with the given server name through the proxy
+	hello := func(address, serverName string) {
+		conn, err := net.Dial("tcp", address)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		// #nosec G402 -- the handshake is aborted by the fake apiserver anyway.
+		_ = tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}
+
+	It("should reject an invalid config", func() {
+		_, err := New(Config{DialTimeout: time.Second, HealthCheckInterval: time.Second}, nil)
+		Expect(err).To(MatchError(ContainSubstring("at least one upstream")))
+
+		_, err = New(Config{Upstreams: []Upstream{{Address: "10.0.0.1:443"}}}, nil)
+		Expect(err).To(MatchError(ContainSubstring("must be positive")))
+	})
+
+	It("should forward connections to the first upstream", func() {
+		address := newProxy(Upstream{Address: servers[0].address()}, Upstream{Address: servers[1].address()})
+
+		conn, r := connect(address)
+		defer conn.Close()
+
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " ping\n"))
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " ping\n"))
+	})
+
+	It("should fail over to the next upstream", func() {
+		servers[0].close()
+		address := newProxy(Upstream{Address: servers[0].address()}, Upstream{Address: servers[1].address()})
+
+		conn, r := connect(address)
+		defer conn.Close()
+
+		Expect(echo(conn, r)).To(Equal(servers[1].address() + " ping\n"))
+		Expect(p.upstreams[0].healthy.Load()).To(BeFalse())
+	})
+
+	It("should send the address of the client with the PROXY protocol", func() {
+		address := newProxy(Upstream{Address: servers[0].address(), ProxyProtocol: ProxyProtocol{Version: ProxyProtocolV1}})
+
+		conn, r := connect(address)
+		defer conn.Close()
+
+		_, clientPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+		_, proxyPort, _ := net.SplitHostPort(address)
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " PROXY TCP4 127.0.0.1 127.0.0.1 " + clientPort + " " + proxyPort + "\r\n"))
+	})
+
+	It("should reject different configurations for the same upstream", func() {
+		_, err := New(Config{
+			Upstreams: []Upstream{
+				{Address: "10.0.0.1:443"},
+				{Address: "10.0.0.1:443", ServerNames: []string{"api.example.com"}, ProxyProtocol: ProxyProtocol{Version: ProxyProtocolV2}},
+			},
+			DialTimeout:         time.Second,
+			HealthCheckInterval: time.Second,
+			UnhealthyThreshold:  1,
+		}, nil)
+		Expect(err).To(MatchError(ContainSubstring("different PROXY protocol or health check configurations")))
+	})
+
+	It("should forward connections by their server name", func() {
+		address := newProxy(
+			Upstream{Address: servers[0].address()},
+			Upstream{Address: servers[1].address(), ServerNames: []string{"api.example.com"}},
+		)
+
+		hello(address, "API.example.com")
+		Eventually(servers[1].serverNames).Should(Receive(Equal("API.example.com")))
+
+		hello(address, "other.example.com")
+		Eventually(servers[0].serverNames).Should(Receive(Equal("other.example.com")))
+
+		hello(address, "")
+		Eventually(servers[0].serverNames).Should(Receive(BeEmpty()))
+	})
+
+	It("should keep forwarding the other connections to an upstream also given with server names", func() {
+		address := newProxy(
+			Upstream{Address: servers[0].address()},
+			Upstream{Address: servers[1].address(), ServerNames: []string{"api.example.com"}},
+			Upstream{Address: servers[0].address(), ServerNames: []string{"api.internal.example.com"}},
+		)
+		Expect(p.upstreams).To(HaveLen(2))
+
+		hello(address, "api.internal.example.com")
+		Eventually(servers[0].serverNames).Should(Receive(Equal("api.internal.example.com")))
+
+		hello(address, "other.example.com")
+		Eventually(servers[0].serverNames).Should(Receive(Equal("other.example.com")))
+
+		hello(address, "api.example.com")
+		Eventually(servers[1].serverNames).Should(Receive(Equal("api.example.com")))
+	})
+
+	It("should distribute the connections round robin", func() {
+		address := newProxyWith(Config{Upstreams: []Upstream{{Address: servers[0].address()}, {Address: servers[1].address()}}})
+
+		var answers []string
+		for range 4 {
+			conn, r := connect(address)
+			answers = append(answers, echo(conn, r))
+			Expect(conn.Close()).To(Succeed())
+		}
+
+		Expect(answers).To(Equal([]string{
+			servers[0].address() + " ping\n", servers[1].address() + " ping\n",
+			servers[0].address() + " ping\n", servers[1].address() + " ping\n",
+		}))
+	})
+
+	It("should forward the connections to the upstream with the least connections", func() {
+		address := newProxyWith(Config{
+			Upstreams:     []Upstream{{Address: servers[0].address()}, {Address: servers[1].address()}},
+			LoadBalancing: LoadBalancingLeastConnections,
+		})
+
+		conn1, r1 := connect(address)
+		defer conn1.Close()
+		Expect(echo(conn1, r1)).To(Equal(servers[0].address() + " ping\n"))
+
+		conn2, r2 := connect(address)
+		Expect(echo(conn2, r2)).To(Equal(servers[1].address() + " ping\n"))
+		Expect(conn2.Close()).To(Succeed())
+		Eventually(p.upstreams[1].active.Load).Should(BeZero())
+
+		// round robin would pick the first upstream
+		conn3, r3 := connect(address)
+		defer conn3.Close()
+		Expect(echo(conn3, r3)).To(Equal(servers[1].address() + " ping\n"))
+	})
+
+	It("should eject an upstream after the configured number of failures", func() {
+		servers[0].close()
+		address := newProxyWith(Config{
+			Upstreams:          []Upstream{{Address: servers[0].address()}, {Address: servers[1].address()}},
+			LoadBalancing:      LoadBalancingFailover,
+			UnhealthyThreshold: 2,
+		})
+
+		conn, r := connect(address)
+		Expect(echo(conn, r)).To(Equal(servers[1].address() + " ping\n"))
+		Expect(conn.Close()).To(Succeed())
+		Expect(p.upstreams[0].healthy.Load()).To(BeTrue())
+
+		conn, r = connect(address)
+		Expect(echo(conn, r)).To(Equal(servers[1].address() + " ping\n"))
+		Expect(conn.Close()).To(Succeed())
+		Expect(p.upstreams[0].healthy.Load()).To(BeFalse())
+		Expect(p.candidates("")).To(ConsistOf(p.upstreams[1]))
+
+		// the upstream is readmitted by the next successful health check
+		p.upstreams[0].record(nil)
+		Expect(p.upstreams[0].healthy.Load()).To(BeTrue())
+		Expect(p.upstreams[0].failures.Load()).To(BeZero())
+	})
+
+	It("should skip unhealthy upstreams found by the health checks", func() {
+		address := newProxy(Upstream{Address: servers[0].address()}, Upstream{Address: servers[1].address()})
+		servers[0].close()
+
+		go p.Run(ctx)
+		Eventually(p.upstreams[0].healthy.Load).Should(BeFalse())
+		Expect(p.upstreams[1].healthy.Load()).To(BeTrue())
+
+		Expect(p.candidates("")).To(ConsistOf(p.upstreams[1]))
+
+		conn, r := connect(address)
+		defer conn.Close()
+		Expect(echo(conn, r)).To(Equal(servers[1].address() + " ping\n"))
+	})
+
+	It("should try all upstreams if none is healthy", func() {
+		newProxy(Upstream{Address: servers[0].address()}, Upstream{Address: servers[1].address()})
+		for _, u := range p.upstreams {
+			u.setHealthy(false, nil)
+		}
+
+		Expect(p.candidates("")).To(Equal(p.upstreams))
+	})
+
+	It("should stop listening on addresses which are not given anymore", func() {
+		address := newProxy(Upstream{Address: servers[0].address()})
+
+		conn, r := connect(address)
+		defer conn.Close()
+
+		Expect(p.Listen([]string{"127.0.0.2:0"})).To(Succeed())
+		Expect(p.listeners).To(HaveKey("127.0.0.2:0"))
+		Expect(p.listeners).ToNot(HaveKey("127.0.0.1:0"))
+
+		_, err := net.Dial("tcp", address)
+		Expect(err).To(HaveOccurred())
+
+		// accepted connections are kept
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " ping\n"))
+	})
+
+	It("should listen on addresses which are not present on an interface", func() {
+		newProxy(Upstream{Address: servers[0].address()})
+
+		Expect(p.Listen([]string{"127.0.0.1:0", "192.0.2.1:0"})).To(Succeed())
+	})
+
+	It("should drain the connections on shutdown", func() {
+		address := newProxy(Upstream{Address: servers[0].address()})
+
+		conn, r := connect(address)
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " ping\n"))
+
+		shutdown := make(chan error)
+		go func() {
+			shutdown <- p.Shutdown(context.Background())
+		}()
+
+		Consistently(shutdown, 100*time.Millisecond).ShouldNot(Receive())
+		_, err := net.Dial("tcp", address)
+		Expect(err).To(HaveOccurred())
+
+		// the connection is still forwarded until it is closed
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " ping\n"))
+		Expect(conn.Close()).To(Succeed())
+
+		Eventually(shutdown).Should(Receive(BeNil()))
+		p = nil
+	})
+
+	It("should close the connections which are not drained in time", func() {
+		address := newProxy(Upstream{Address: servers[0].address()})
+
+		conn, r := connect(address)
+		defer conn.Close()
+		Expect(echo(conn, r)).To(Equal(servers[0].address() + " ping\n"))
+
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancelShutdown()
+		Expect(p.Shutdown(shutdownCtx)).To(MatchError(context.DeadlineExceeded))
+		p = nil
+
+		_, err := r.ReadString('\n')
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Health checks", func() {
+
+	var (
+		dir    string
+		server *httptest.Server
+		u      Upstream
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "healthcheck")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dir, "token"), []byte("secret\n"), 0600)).To(Succeed())
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/readyz" || r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}))
+		u = Upstream{Address: server.Listener.Addr().String()}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	check := func(u Upstream) error {
+		return newUpstream(u, 1).check(context.Background(), time.Second)
+	}
+
+	It("should check the upstream with a TCP connection", func() {
+		Expect(check(u)).To(Succeed())
+
+		server.Close()
+		Expect(check(u)).ToNot(Succeed())
+	})
+
+	It("should check the upstream with a TLS handshake", func() {
+		u.HealthCheck = HealthCheck{Mode: HealthCheckTLS}
+		Expect(check(u)).To(Succeed())
+
+		caFile := filepath.Join(dir, "ca.crt")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)).To(Succeed())
+		u.HealthCheck.CAFile = caFile
+		Expect(check(u)).To(Succeed())
+
+		// the certificate of the server is not valid for this server name
+		u.ServerNames = []string{"api.example.org"}
+		Expect(check(u)).To(MatchError(ContainSubstring("could not perform TLS handshake")))
+	})
+
+	It("should check the upstream by requesting /readyz with the token", func() {
+		caFile := filepath.Join(dir, "ca.crt")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)).To(Succeed())
+
+		u.HealthCheck = HealthCheck{Mode: HealthCheckHTTPS, TokenFile: filepath.Join(dir, "token"), CAFile: caFile}
+		Expect(check(u)).To(Succeed())
+
+		Expect(os.WriteFile(u.HealthCheck.TokenFile, []byte("rotated"), 0600)).To(Succeed())
+		Expect(check(u)).To(MatchError(ContainSubstring("status 401")))
+
+		u.HealthCheck.TokenFile = filepath.Join(dir, "missing")
+		Expect(check(u)).To(MatchError(ContainSubstring("could not read token file")))
+	})
+
+	It("should not send the token to an unverified upstream", func() {
+		u.HealthCheck = HealthCheck{Mode: HealthCheckHTTPS, TokenFile: filepath.Join(dir, "token")}
+		Expect(u.HealthCheck.validate()).To(MatchError(ContainSubstring("requires a CA file")))
+		Expect(check(u)).To(MatchError(ContainSubstring("status 401")))
+	})
+})
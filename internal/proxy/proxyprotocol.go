@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ProxyProtocolV1 is the human-readable version 1 of the PROXY protocol.
+	ProxyProtocolV1 = "v1"
+	// ProxyProtocolV2 is the binary version 2 of the PROXY protocol, which supports TLVs.
+	ProxyProtocolV2 = "v2"
+
+	// TLVTypeAuthority is the type of the TLV carrying the TLS server name (SNI) of the connection.
+	TLVTypeAuthority = 0x02
+
+	// maxAuthorityLength is the maximum length of a server name sent as TLV, that of a DNS name.
+	maxAuthorityLength = 255
+	// maxTLVsLength is the maximum length of the additional TLVs including their type and length, so that the
+	// body of a header of version 2 with IPv6 addresses and the authority still fits its 16 bit length.
+	maxTLVsLength = math.MaxUint16 - 36 - (3 + maxAuthorityLength)
+)
+
+// v2Signature starts every header of version 2 of the PROXY protocol.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocol configures the PROXY protocol header sent to an upstream before the data of a connection,
+// so that the upstream gets the address of the client, see https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+type ProxyProtocol struct {
+	// Version is the version of the PROXY protocol, no header is sent if empty.
+	Version string
+	// Authority indicates whether the TLS server name of the connection, if any, is sent as TLV (v2 only).
+	Authority bool
+	// TLVs are additional TLVs sent with every header (v2 only).
+	TLVs []TLV
+}
+
+// TLV is a type-length-value field of a header of version 2 of the PROXY protocol.
+type TLV struct {
+	// Type is the type of the TLV, 0xE0 to 0xEF are reserved for custom ones.
+	Type uint8
+	// Value is the value of the TLV.
+	Value []byte
+}
+
+// validate validates the PROXY protocol configuration.
+func (pp ProxyProtocol) validate() error {
+	switch pp.Version {
+	case "", ProxyProtocolV1:
+		if pp.Authority || len(pp.TLVs) > 0 {
+			return xerrors.Errorf("TLVs require version %s of the PROXY protocol", ProxyProtocolV2)
+		}
+	case ProxyProtocolV2:
+	default:
+		return xerrors.Errorf("unsupported version %q of the PROXY protocol, must be %s or %s", pp.Version, ProxyProtocolV1, ProxyProtocolV2)
+	}
+
+	length := 0
+	for _, tlv := range pp.TLVs {
+		length += 3 + len(tlv.Value)
+	}
+	if length > maxTLVsLength {
+		return xerrors.Errorf("the TLVs are %d bytes long, must be at most %d", length, maxTLVsLength)
+	}
+
+	return nil
+}
+
+// equal reports whether both PROXY protocol configurations are the same.
+func (pp ProxyProtocol) equal(other ProxyProtocol) bool {
+	return pp.Version == other.Version && pp.Authority == other.Authority &&
+		slices.EqualFunc(pp.TLVs, other.TLVs, func(a, b TLV) bool {
+			return a.Type == b.Type && bytes.Equal(a.Value, b.Value)
+		})
+}
+
+// header returns the PROXY protocol header for a connection from the source to the destination address
+// carrying the given TLS server name. It is empty if no version is configured.
+func (pp ProxyProtocol) header(src, dst net.Addr, serverName string) []byte {
+	srcAddr, srcOK := addrPort(src)
+	dstAddr, dstOK := addrPort(dst)
+	// the addresses are only sent if they are known and of the same family, as required by the protocol
+	known := srcOK && dstOK && srcAddr.Addr().Is4() == dstAddr.Addr().Is4()
+
+	switch pp.Version {
+	case ProxyProtocolV1:
+		return headerV1(srcAddr, dstAddr, known)
+	case ProxyProtocolV2:
+		return pp.headerV2(srcAddr, dstAddr, known, serverName)
+	default:
+		return nil
+	}
+}
+
+// localHeader returns the PROXY protocol header for a connection which is not forwarded on behalf of a client,
+// e.g. a health check. It is empty if no version is configured.
+func (pp ProxyProtocol) localHeader() []byte {
+	switch pp.Version {
+	case ProxyProtocolV1:
+		return headerV1(netip.AddrPort{}, netip.AddrPort{}, false)
+	case ProxyProtocolV2:
+		// version 2 and command LOCAL with the family UNSPEC and no addresses
+		return append(slices.Clone(v2Signature), 0x20, 0x00, 0x00, 0x00)
+	default:
+		return nil
+	}
+}
+
+func headerV1(src, dst netip.AddrPort, known bool) []byte {
+	if !known {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	family := "TCP6"
+	if src.Addr().Is4() {
+		family = "TCP4"
+	}
+
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, src.Addr(), dst.Addr(), src.Port(), dst.Port())
+}
+
+func (pp ProxyProtocol) headerV2(src, dst netip.AddrPort, known bool, serverName string) []byte {
+	var (
+		family byte
+		body   []byte
+	)
+
+	// the family and protocol of an unknown connection is UNSPEC
+	if known {
+		family = 0x21 // TCP over IPv6
+		if src.Addr().Is4() {
+			family = 0x11 // TCP over IPv4
+		}
+
+		body = append(body, src.Addr().AsSlice()...)
+		body = append(body, dst.Addr().AsSlice()...)
+		body = binary.BigEndian.AppendUint16(body, src.Port())
+		body = binary.BigEndian.AppendUint16(body, dst.Port())
+	}
+
+	tlvs := pp.TLVs
+	// longer server names are not valid DNS names and would not fit the header
+	if pp.Authority && serverName != "" && len(serverName) <= maxAuthorityLength {
+		tlvs = append([]TLV{{Type: TLVTypeAuthority, Value: []byte(serverName)}}, tlvs...)
+	}
+
+	for _, tlv := range tlvs {
+		body = append(body, tlv.Type)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(tlv.Value))) // #nosec G115 -- the length of the TLVs is validated
+		body = append(body, tlv.Value...)
+	}
+
+	header := slices.Clone(v2Signature)
+	// version 2 and command PROXY, i.e. the connection is forwarded on behalf of another node
+	header = append(header, 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body))) // #nosec G115 -- the length of the TLVs is validated
+
+	return append(header, body...)
+}
+
+// addrPort returns the address and port of a TCP address with IPv4-mapped IPv6 addresses unmapped.
+func addrPort(addr net.Addr) (netip.AddrPort, bool) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+
+	addrPort := tcpAddr.AddrPort()
+
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), addrPort.IsValid()
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// errClientHelloRead aborts the handshake once the ClientHello was read.
+var errClientHelloRead = errors.New("client hello read")
+
+// readServerName reads the TLS ClientHello from the connection and returns the server name (SNI) it carries
+// together with the bytes read, which have to be forwarded to the upstream before the rest of the connection.
+// If the connection does not start with a ClientHello, the server name is empty.
+func readServerName(conn net.Conn) (string, []byte) {
+	var (
+		read       bytes.Buffer
+		serverName string
+	)
+
+	// the TLS connection is only used to parse the ClientHello, it is not terminated
+	_ = tls.Server(readOnlyConn{Conn: conn, r: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	return serverName, read.Bytes()
+}
+
+// readOnlyConn is a connection which only reads from r, so that nothing is written to the client while
+// parsing the ClientHello.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c readOnlyConn) Write(_ []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func (c readOnlyConn) Close() error {
+	return nil
+}
+
+func (c readOnlyConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c readOnlyConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c readOnlyConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
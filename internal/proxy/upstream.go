@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+
+	"github.com/gardener/apiserver-proxy/internal/metrics"
+)
+
+// Upstream is a kube-apiserver endpoint the connections are forwarded to.
+type Upstream struct {
+	// Address is the host and port of the endpoint.
+	Address string
+	// ServerNames are the TLS server names (SNI) the connections are forwarded to this endpoint for.
+	// If empty, it gets all connections with a server name which is not served by another endpoint.
+	ServerNames []string
+	// ProxyProtocol configures the PROXY protocol header sent to the endpoint.
+	ProxyProtocol ProxyProtocol
+	// HealthCheck configures how the endpoint is checked.
+	HealthCheck HealthCheck
+}
+
+// ParseUpstream parses an upstream given as [<server-name>=]<host>:<port>[?<options>]. The options are
+// URL-encoded and configure
+//   - the PROXY protocol: proxy-protocol=v1|v2 selects the version, authority=true sends the TLS server name
+//     and every tlv=<type>:<value> sends an additional TLV,
+//   - the health check: health-check=tcp|tls|https selects the mode, token-file=<path> the file containing
+//     the bearer token for /readyz and ca-file=<path> the CA certificates,
+//
+// e.g. 10.0.0.1:443?proxy-protocol=v2&authority=true&tlv=0xe0:node-1&health-check=https&token-file=/token.
+func ParseUpstream(s string) (Upstream, error) {
+	var u Upstream
+
+	address, options, _ := strings.Cut(s, "?")
+	if err := parseOptions(options, &u); err != nil {
+		return u, xerrors.Errorf("invalid upstream %q: %v", s, err)
+	}
+
+	if serverName, rest, ok := strings.Cut(address, "="); ok {
+		if serverName == "" {
+			return u, xerrors.Errorf("invalid upstream %q, the server name must not be empty", s)
+		}
+
+		u.ServerNames = []string{serverName}
+		address = rest
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return u, xerrors.Errorf("invalid upstream %q, must be [<server-name>=]<host>:<port>: %v", s, err)
+	}
+	u.Address = address
+
+	return u, nil
+}
+
+// parseOptions parses the URL-encoded options of an upstream into its PROXY protocol and health check
+// configuration.
+func parseOptions(options string, u *Upstream) error {
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return err
+	}
+
+	pp, hc := &u.ProxyProtocol, &u.HealthCheck
+	for key, vals := range values {
+		switch key {
+		case "proxy-protocol":
+			pp.Version = vals[len(vals)-1]
+		case "authority":
+			if pp.Authority, err = strconv.ParseBool(vals[len(vals)-1]); err != nil {
+				return xerrors.Errorf("invalid option authority: %v", err)
+			}
+		case "tlv":
+			for _, v := range vals {
+				typ, value, ok := strings.Cut(v, ":")
+				t, err := strconv.ParseUint(typ, 0, 8)
+				if !ok || err != nil {
+					return xerrors.Errorf("invalid option tlv %q, must be <type>:<value> with a type from 0 to 255", v)
+				}
+
+				pp.TLVs = append(pp.TLVs, TLV{Type: uint8(t), Value: []byte(value)})
+			}
+		case "health-check":
+			hc.Mode = vals[len(vals)-1]
+		case "token-file":
+			hc.TokenFile = vals[len(vals)-1]
+		case "ca-file":
+			hc.CAFile = vals[len(vals)-1]
+		default:
+			return xerrors.Errorf("unknown option %q", key)
+		}
+	}
+
+	if err := pp.validate(); err != nil {
+		return err
+	}
+
+	return hc.validate()
+}
+
+// upstream is an Upstream with its observed health and load.
+type upstream struct {
+	Upstream
+	// unhealthyThreshold is the number of consecutive failures after which the upstream is ejected
+	unhealthyThreshold int
+	// fallback reports whether the upstream also serves the connections without a matching server name, as it
+	// was given without server names, even if it was merged with the same upstream given with server names
+	fallback bool
+
+	healthy  atomic.Bool
+	failures atomic.Int32
+	active   atomic.Int64
+}
+
+func newUpstream(u Upstream, unhealthyThreshold int) *upstream {
+	up := &upstream{Upstream: u, unhealthyThreshold: unhealthyThreshold, fallback: len(u.ServerNames) == 0}
+	// the upstream is assumed to be healthy until checked otherwise, so that connections are forwarded right away
+	up.healthy.Store(true)
+	metrics.ProxyUpstreamHealthy.WithLabelValues(u.Address).Set(1)
+
+	return up
+}
+
+// serves reports whether the upstream serves the given server name.
+func (u *upstream) serves(serverName string) bool {
+	return slices.ContainsFunc(u.ServerNames, func(name string) bool {
+		return strings.EqualFold(name, serverName)
+	})
+}
+
+// record records the result of a health check or connection attempt. The upstream is ejected after the
+// configured number of consecutive failures and readmitted after the first success.
+func (u *upstream) record(err error) {
+	if err == nil {
+		u.failures.Store(0)
+		u.setHealthy(true, nil)
+
+		return
+	}
+
+	metrics.ProxyUpstreamFailures.WithLabelValues(u.Address).Inc()
+
+	if failures := int(u.failures.Add(1)); failures < u.unhealthyThreshold {
+		klog.V(2).Infof("Upstream %q failed %d of %d times: %v", u.Address, failures, u.unhealthyThreshold, err)
+		return
+	}
+
+	u.setHealthy(false, err)
+}
+
+// setHealthy records the health of the upstream, logging changes.
+func (u *upstream) setHealthy(healthy bool, err error) {
+	if u.healthy.Swap(healthy) == healthy {
+		return
+	}
+
+	if healthy {
+		klog.Infof("Upstream %q is healthy", u.Address)
+		metrics.ProxyUpstreamHealthy.WithLabelValues(u.Address).Set(1)
+
+		return
+	}
+
+	klog.Warningf("Upstream %q is unhealthy: %v", u.Address, err)
+	metrics.ProxyUpstreamHealthy.WithLabelValues(u.Address).Set(0)
+}
+
+// mergeUpstreams merges the server names of the upstreams with the same address, keeping the order of
+// their first occurrence. The merged upstream is a fallback if any of them is. The upstreams with the same
+// address must have the same PROXY protocol and health check configuration.
+func mergeUpstreams(upstreams []Upstream, unhealthyThreshold int) []*upstream {
+	var merged []*upstream
+
+	for _, u := range upstreams {
+		i := slices.IndexFunc(merged, func(m *upstream) bool { return m.Address == u.Address })
+		if i < 0 {
+			merged = append(merged, newUpstream(Upstream{
+				Address:       u.Address,
+				ServerNames:   slices.Clone(u.ServerNames),
+				ProxyProtocol: u.ProxyProtocol,
+				HealthCheck:   u.HealthCheck,
+			}, unhealthyThreshold))
+			continue
+		}
+
+		merged[i].ServerNames = append(merged[i].ServerNames, u.ServerNames...)
+		merged[i].fallback = merged[i].fallback || len(u.ServerNames) == 0
+	}
+
+	return merged
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package report reflects the health of the ip addresses in Kubernetes Events and a Node condition.
+package report
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConditionType is the type of the Node condition reflecting whether the ip addresses are ready.
+const ConditionType corev1.NodeConditionType = "APIServerProxyAddressReady"
+
+// heartbeatInterval is the minimum interval in which the heartbeat of an unchanged condition is refreshed.
+const heartbeatInterval = time.Minute
+
+const (
+	// ReasonAddressReady means that the ip addresses are present and the proxy is reachable, if it is probed.
+	ReasonAddressReady = "AddressReady"
+	// ReasonAddressNotReady means that ensuring the ip addresses failed.
+	ReasonAddressNotReady = "AddressNotReady"
+	// ReasonAddressConflict means that the ip addresses are present, but another agent keeps changing them.
+	ReasonAddressConflict = "AddressConflict"
+	// ReasonProxyNotReachable means that the proxy is not reachable on the ip addresses and port.
+	ReasonProxyNotReachable = "ProxyNotReachable"
+	// ReasonAddressRemoved means that the ip addresses were removed on exit.
+	ReasonAddressRemoved = "AddressRemoved"
+	// ReasonDuplicateAddressRemoved means that a duplicate of an ip address was removed from another interface.
+	ReasonDuplicateAddressRemoved = "DuplicateAddressRemoved"
+	// ReasonDuplicateAddressFound means that a duplicate of an ip address was found and kept on another interface.
+	ReasonDuplicateAddressFound = "DuplicateAddressFound"
+	// ReasonAddressTakenOver means that the ip addresses were taken over from a previous instance of the sidecar.
+	ReasonAddressTakenOver = "AddressTakenOver"
+)
+
+// Result is the health of the ip addresses to report.
+type Result struct {
+	// Ready reports whether the ip addresses are ready.
+	Ready bool
+	// Reason is a CamelCase reason of the result.
+	Reason string
+	// Message is a human readable message of the result.
+	Message string
+}
+
+// NodeReporter records Events on the Node and maintains the ConditionType condition of the Node whenever
+// the reported result changes. The heartbeat of the condition is refreshed at most every heartbeatInterval.
+type NodeReporter struct {
+	client    client.Client
+	recorder  record.EventRecorder
+	nodeName  string
+	condition bool
+	now       func() time.Time
+
+	mu sync.Mutex
+	// last is the last result which was reported successfully, nil before the first one
+	last *Result
+	// lastEvent is the last result an event was recorded for, so that it is not recorded again while
+	// updating the condition is retried
+	lastEvent *Result
+	// heartbeat is the time the condition was last updated successfully
+	heartbeat time.Time
+}
+
+// NewNodeReporter returns a new NodeReporter for the given Node. Events are only recorded if the recorder
+// is not nil and the condition is only maintained if condition is true.
+func NewNodeReporter(c client.Client, recorder record.EventRecorder, nodeName string, condition bool) *NodeReporter {
+	return &NodeReporter{
+		client:    c,
+		recorder:  recorder,
+		nodeName:  nodeName,
+		condition: condition,
+		now:       time.Now,
+	}
+}
+
+// Report reports the given result unless it equals the previous one, in which case only the heartbeat of the
+// condition is refreshed once it is due. If updating the condition fails, it is retried with the next report
+// without recording the event again.
+func (r *NodeReporter) Report(ctx context.Context, res Result) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := r.last == nil || *r.last != res
+	if !changed && (!r.condition || r.now().Sub(r.heartbeat) < heartbeatInterval) {
+		return nil
+	}
+
+	if r.recorder != nil && (r.lastEvent == nil || *r.lastEvent != res) {
+		eventType := corev1.EventTypeNormal
+		if !res.Ready {
+			eventType = corev1.EventTypeWarning
+		}
+
+		r.recorder.Event(r.nodeRef(), eventType, res.Reason, res.Message)
+		r.lastEvent = &res
+	}
+
+	if r.condition {
+		if err := r.updateCondition(ctx, res); err != nil {
+			return err
+		}
+	}
+
+	r.last = &res
+
+	return nil
+}
+
+// Event records an event on the Node if events are recorded, e.g. for a duplicate of an ip address.
+func (r *NodeReporter) Event(eventType, reason, message string) {
+	if r.recorder != nil {
+		r.recorder.Event(r.nodeRef(), eventType, reason, message)
+	}
+}
+
+// nodeRef references the node the same way as the kubelet, so that the events are shown for the node.
+func (r *NodeReporter) nodeRef() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind: "Node",
+		Name: r.nodeName,
+		UID:  types.UID(r.nodeName),
+	}
+}
+
+// updateCondition sets the ConditionType condition of the Node to the given result.
+func (r *NodeReporter) updateCondition(ctx context.Context, res Result) error {
+	node := &corev1.Node{}
+	if err := r.client.Get(ctx, client.ObjectKey{Name: r.nodeName}, node); err != nil {
+		return xerrors.Errorf("could not get node %s: %v", r.nodeName, err)
+	}
+
+	status := corev1.ConditionFalse
+	if res.Ready {
+		status = corev1.ConditionTrue
+	}
+
+	now := metav1.NewTime(r.now())
+	condition := corev1.NodeCondition{
+		Type:               ConditionType,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             res.Reason,
+		Message:            res.Message,
+	}
+
+	patch := client.StrategicMergeFrom(node.DeepCopy())
+
+	found := false
+	for i, existing := range node.Status.Conditions {
+		if existing.Type != ConditionType {
+			continue
+		}
+
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		node.Status.Conditions[i] = condition
+		found = true
+	}
+	if !found {
+		node.Status.Conditions = append(node.Status.Conditions, condition)
+	}
+
+	if err := r.client.Status().Patch(ctx, node, patch); err != nil {
+		return xerrors.Errorf("could not update condition %s of node %s: %v", ConditionType, r.nodeName, err)
+	}
+
+	r.heartbeat = now.Time
+
+	klog.V(2).Infof("Updated condition %s of node %s to %s (%s)", ConditionType, r.nodeName, status, res.Reason)
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package report
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Report Suite")
+}
+
+var _ = Describe("NodeReporter", func() {
+
+	var (
+		ctx      context.Context
+		c        client.Client
+		recorder *record.FakeRecorder
+		reporter *NodeReporter
+		now      time.Time
+	)
+
+	ready := Result{Ready: true, Reason: ReasonAddressReady, Message: "ready"}
+	notReady := Result{Ready: false, Reason: ReasonAddressNotReady, Message: "could not add ip address"}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			}},
+		}
+		c = fake.NewClientBuilder().WithObjects(node).WithStatusSubresource(node).Build()
+		recorder = record.NewFakeRecorder(10)
+		reporter = NewNodeReporter(c, recorder, "node-1", true)
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		reporter.now = func() time.Time { return now }
+	})
+
+	condition := func() *corev1.NodeCondition {
+		node := &corev1.Node{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "node-1"}, node)).To(Succeed())
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == ConditionType {
+				return &cond
+			}
+		}
+		return nil
+	}
+
+	It("should add the condition and record an event", func() {
+		Expect(reporter.Report(ctx, ready)).To(Succeed())
+
+		cond := condition()
+		Expect(cond).ToNot(BeNil())
+		Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(ReasonAddressReady))
+		Expect(cond.Message).To(Equal("ready"))
+		Expect(recorder.Events).To(Receive(Equal("Normal AddressReady ready")))
+	})
+
+	It("should keep the other conditions", func() {
+		Expect(reporter.Report(ctx, ready)).To(Succeed())
+
+		node := &corev1.Node{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "node-1"}, node)).To(Succeed())
+		Expect(node.Status.Conditions).To(HaveLen(2))
+	})
+
+	It("should only report changes and refresh the heartbeat", func() {
+		Expect(reporter.Report(ctx, ready)).To(Succeed())
+		Expect(recorder.Events).To(Receive())
+
+		now = now.Add(30 * time.Second)
+		Expect(reporter.Report(ctx, ready)).To(Succeed())
+		Expect(recorder.Events).ToNot(Receive())
+		Expect(condition().LastHeartbeatTime.Time).To(BeTemporally("==", now.Add(-30*time.Second)))
+
+		now = now.Add(30 * time.Second)
+		Expect(reporter.Report(ctx, ready)).To(Succeed())
+		Expect(recorder.Events).ToNot(Receive())
+		Expect(condition().LastHeartbeatTime.Time).To(BeTemporally("==", now))
+		Expect(condition().LastTransitionTime.Time).To(BeTemporally("==", now.Add(-time.Minute)))
+	})
+
+	It("should update the transition time only if the status changed", func() {
+		Expect(reporter.Report(ctx, notReady)).To(Succeed())
+		Expect(recorder.Events).To(Receive(Equal("Warning AddressNotReady could not add ip address")))
+
+		now = now.Add(time.Minute)
+		Expect(reporter.Report(ctx, Result{Ready: false, Reason: ReasonProxyNotReachable, Message: "refused"})).To(Succeed())
+		Expect(condition().LastTransitionTime.Time).To(BeTemporally("==", now.Add(-time.Minute)))
+		Expect(condition().Reason).To(Equal(ReasonProxyNotReachable))
+
+		now = now.Add(time.Minute)
+		Expect(reporter.Report(ctx, ready)).To(Succeed())
+		Expect(condition().LastTransitionTime.Time).To(BeTemporally("==", now))
+		Expect(condition().Status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("should retry if the node cannot be updated", func() {
+		reporter = NewNodeReporter(c, nil, "node-2", true)
+
+		Expect(reporter.Report(ctx, ready)).ToNot(Succeed())
+		Expect(reporter.last).To(BeNil())
+	})
+
+	It("should record the event only once while the node cannot be updated", func() {
+		reporter = NewNodeReporter(c, recorder, "node-2", true)
+
+		Expect(reporter.Report(ctx, notReady)).ToNot(Succeed())
+		Expect(reporter.Report(ctx, notReady)).ToNot(Succeed())
+		Expect(recorder.Events).To(HaveLen(1))
+
+		Expect(reporter.Report(ctx, ready)).ToNot(Succeed())
+		Expect(recorder.Events).To(HaveLen(2))
+	})
+
+	It("should only record events if the condition is disabled", func() {
+		reporter = NewNodeReporter(c, recorder, "node-1", false)
+
+		Expect(reporter.Report(ctx, notReady)).To(Succeed())
+		Expect(recorder.Events).To(Receive())
+		Expect(condition()).To(BeNil())
+	})
+})
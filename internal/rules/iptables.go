@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package rules
+
+import (
+	"net/netip"
+	"strconv"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+)
+
+// iptablesRuleNotExist is the exit code of iptables if a checked or deleted rule does not exist.
+const iptablesRuleNotExist = 1
+
+// iptablesRule is a single rule of an iptables or ip6tables chain.
+type iptablesRule struct {
+	binary string
+	table  string
+	chain  string
+	args   []string
+}
+
+func (r iptablesRule) command(op string) []string {
+	return append([]string{"-w", "-t", r.table, op, r.chain}, r.args...)
+}
+
+// iptablesManager is the Manager for the iptables backend.
+type iptablesManager struct {
+	Executor
+	rules []iptablesRule
+}
+
+func newIPTablesManager(executor Executor, ips []netip.Addr, port uint16) *iptablesManager {
+	m := &iptablesManager{Executor: executor}
+
+	for _, ip := range ips {
+		binary := "iptables"
+		if ip.Is6() {
+			binary = "ip6tables"
+		}
+
+		dst := []string{"-d", ip.String(), "-p", "tcp", "--dport", strconv.Itoa(int(port))}
+		src := []string{"-s", ip.String(), "-p", "tcp", "--sport", strconv.Itoa(int(port))}
+		comment := []string{"-m", "comment", "--comment", ruleComment}
+
+		rule := func(table, chain string, match []string, target string) iptablesRule {
+			args := append(append(append([]string{}, match...), comment...), "-j", target)
+			return iptablesRule{binary: binary, table: table, chain: chain, args: args}
+		}
+
+		m.rules = append(m.rules,
+			// don't track the connections to and from the proxy in conntrack
+			rule("raw", "PREROUTING", dst, "NOTRACK"),
+			rule("raw", "OUTPUT", dst, "NOTRACK"),
+			rule("raw", "OUTPUT", src, "NOTRACK"),
+			// accept the connections to and from the proxy before any other rule is evaluated
+			rule("filter", "INPUT", dst, "ACCEPT"),
+			rule("filter", "OUTPUT", src, "ACCEPT"),
+		)
+	}
+
+	return m
+}
+
+// EnsureRules inserts every missing rule at the beginning of its chain.
+func (m *iptablesManager) EnsureRules() error {
+	for _, r := range m.rules {
+		_, err := m.Run(nil, r.binary, r.command("-C")...)
+		if err == nil {
+			klog.V(4).Infof("Rule %v already exists. Skipping", r.command("-C"))
+			continue
+		}
+
+		if exitCode(err) != iptablesRuleNotExist {
+			return xerrors.Errorf("could not check rule in chain %s of table %s: %v", r.chain, r.table, err)
+		}
+
+		if _, err := m.Run(nil, r.binary, r.command("-I")...); err != nil {
+			return xerrors.Errorf("could not insert rule into chain %s of table %s: %v", r.chain, r.table, err)
+		}
+
+		klog.Infof("Successfully inserted rule into chain %s of table %s", r.chain, r.table)
+	}
+
+	return nil
+}
+
+// CleanupRules deletes all occurrences of the rules.
+func (m *iptablesManager) CleanupRules() error {
+	for _, r := range m.rules {
+		for {
+			_, err := m.Run(nil, r.binary, r.command("-D")...)
+			if exitCode(err) == iptablesRuleNotExist {
+				break
+			}
+
+			if err != nil {
+				return xerrors.Errorf("could not delete rule from chain %s of table %s: %v", r.chain, r.table, err)
+			}
+
+			klog.Infof("Successfully deleted rule from chain %s of table %s", r.chain, r.table)
+		}
+	}
+
+	return nil
+}
+
+// RulesPresent checks whether every rule exists.
+func (m *iptablesManager) RulesPresent() (bool, error) {
+	for _, r := range m.rules {
+		_, err := m.Run(nil, r.binary, r.command("-C")...)
+		if exitCode(err) == iptablesRuleNotExist {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, xerrors.Errorf("could not check rule in chain %s of table %s: %v", r.chain, r.table, err)
+		}
+	}
+
+	return true, nil
+}
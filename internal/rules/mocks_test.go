@@ -0,0 +1,127 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: rules.go
+//
+// Generated by this command:
+//
+//	mockgen -source rules.go -destination mocks_test.go -package rules
+//
+
+// Package rules is a generated GoMock package.
+package rules
+
+import (
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockExecutor is a mock of Executor interface.
+type MockExecutor struct {
+	ctrl     *gomock.Controller
+	recorder *MockExecutorMockRecorder
+	isgomock struct{}
+}
+
+// MockExecutorMockRecorder is the mock recorder for MockExecutor.
+type MockExecutorMockRecorder struct {
+	mock *MockExecutor
+}
+
+// NewMockExecutor creates a new mock instance.
+func NewMockExecutor(ctrl *gomock.Controller) *MockExecutor {
+	mock := &MockExecutor{ctrl: ctrl}
+	mock.recorder = &MockExecutorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockExecutor) EXPECT() *MockExecutorMockRecorder {
+	return m.recorder
+}
+
+// Run mocks base method.
+func (m *MockExecutor) Run(stdin []byte, name string, args ...string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{stdin, name}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Run", varargs...)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Run indicates an expected call of Run.
+func (mr *MockExecutorMockRecorder) Run(stdin, name any, args ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{stdin, name}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockExecutor)(nil).Run), varargs...)
+}
+
+// MockManager is a mock of Manager interface.
+type MockManager struct {
+	ctrl     *gomock.Controller
+	recorder *MockManagerMockRecorder
+	isgomock struct{}
+}
+
+// MockManagerMockRecorder is the mock recorder for MockManager.
+type MockManagerMockRecorder struct {
+	mock *MockManager
+}
+
+// NewMockManager creates a new mock instance.
+func NewMockManager(ctrl *gomock.Controller) *MockManager {
+	mock := &MockManager{ctrl: ctrl}
+	mock.recorder = &MockManagerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockManager) EXPECT() *MockManagerMockRecorder {
+	return m.recorder
+}
+
+// CleanupRules mocks base method.
+func (m *MockManager) CleanupRules() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CleanupRules")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CleanupRules indicates an expected call of CleanupRules.
+func (mr *MockManagerMockRecorder) CleanupRules() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CleanupRules", reflect.TypeOf((*MockManager)(nil).CleanupRules))
+}
+
+// EnsureRules mocks base method.
+func (m *MockManager) EnsureRules() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnsureRules")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnsureRules indicates an expected call of EnsureRules.
+func (mr *MockManagerMockRecorder) EnsureRules() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureRules", reflect.TypeOf((*MockManager)(nil).EnsureRules))
+}
+
+// RulesPresent mocks base method.
+func (m *MockManager) RulesPresent() (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RulesPresent")
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RulesPresent indicates an expected call of RulesPresent.
+func (mr *MockManagerMockRecorder) RulesPresent() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RulesPresent", reflect.TypeOf((*MockManager)(nil).RulesPresent))
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"k8s.io/klog/v2"
+)
+
+// nftTable is the name of the table owned by the sidecar. It is created in the inet family,
+// so it can hold the rules for IPv4 and IPv6 addresses.
+const nftTable = "apiserver_proxy"
+
+// nftNotExist is the exit code of nft if a listed table does not exist.
+const nftNotExist = 1
+
+// nftablesManager is the Manager for the nftables backend.
+type nftablesManager struct {
+	Executor
+	ruleset string
+}
+
+func newNFTablesManager(executor Executor, ips []netip.Addr, port uint16) *nftablesManager {
+	var dst, src []string
+	for _, ip := range ips {
+		family := "ip"
+		if ip.Is6() {
+			family = "ip6"
+		}
+
+		dst = append(dst, fmt.Sprintf("%s daddr %s tcp dport %d", family, ip, port))
+		src = append(src, fmt.Sprintf("%s saddr %s tcp sport %d", family, ip, port))
+	}
+
+	var b strings.Builder
+	chain := func(name, hook, priority string, rules ...string) {
+		fmt.Fprintf(&b, "\tchain %s {\n\t\ttype filter hook %s priority %s; policy accept;\n", name, hook, priority)
+		for _, r := range rules {
+			fmt.Fprintf(&b, "\t\t%s comment %q\n", r, ruleComment)
+		}
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	suffixed := func(matches []string, verdict string) []string {
+		rules := make([]string, 0, len(matches))
+		for _, match := range matches {
+			rules = append(rules, match+" "+verdict)
+		}
+		return rules
+	}
+
+	// Adding and deleting the table first makes sure that the ruleset is replaced atomically
+	// regardless of whether the table exists already.
+	fmt.Fprintf(&b, "table inet %s\n", nftTable)
+	fmt.Fprintf(&b, "delete table inet %s\n", nftTable)
+	fmt.Fprintf(&b, "table inet %s {\n", nftTable)
+	// don't track the connections to and from the proxy in conntrack
+	chain("prerouting_raw", "prerouting", "raw", suffixed(dst, "notrack")...)
+	chain("output_raw", "output", "raw", append(suffixed(dst, "notrack"), suffixed(src, "notrack")...)...)
+	// accept the connections to and from the proxy. Unlike the iptables rules, this only ends the evaluation of
+	// this table: the chains of other tables at the same hooks are still evaluated and may drop the connections.
+	chain("input", "input", "filter", suffixed(dst, "accept")...)
+	chain("output", "output", "filter", suffixed(src, "accept")...)
+	fmt.Fprintf(&b, "}\n")
+
+	return &nftablesManager{
+		Executor: executor,
+		ruleset:  b.String(),
+	}
+}
+
+// EnsureRules atomically replaces the table owned by the sidecar with the desired ruleset.
+func (m *nftablesManager) EnsureRules() error {
+	if _, err := m.Run([]byte(m.ruleset), "nft", "-f", "-"); err != nil {
+		return xerrors.Errorf("could not apply ruleset to table %s: %v", nftTable, err)
+	}
+
+	klog.V(4).Infof("Successfully applied ruleset to table %s", nftTable)
+
+	return nil
+}
+
+// CleanupRules deletes the table owned by the sidecar.
+func (m *nftablesManager) CleanupRules() error {
+	// Adding the table first makes the deletion succeed if the table does not exist.
+	script := fmt.Sprintf("table inet %s\ndelete table inet %s\n", nftTable, nftTable)
+	if _, err := m.Run([]byte(script), "nft", "-f", "-"); err != nil {
+		return xerrors.Errorf("could not delete table %s: %v", nftTable, err)
+	}
+
+	klog.Infof("Successfully deleted table %s", nftTable)
+
+	return nil
+}
+
+// RulesPresent checks whether the table owned by the sidecar exists.
+func (m *nftablesManager) RulesPresent() (bool, error) {
+	_, err := m.Run(nil, "nft", "list", "table", "inet", nftTable)
+	if exitCode(err) == nftNotExist {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, xerrors.Errorf("could not list table %s: %v", nftTable, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:generate mockgen -source rules.go -destination mocks_test.go -package rules
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+
+	"golang.org/x/xerrors"
+
+	"github.com/gardener/apiserver-proxy/internal/netns"
+)
+
+const (
+	// BackendIPTables manages the rules with the iptables and ip6tables binaries.
+	BackendIPTables = "iptables"
+	// BackendNFTables manages the rules with the nft binary.
+	BackendNFTables = "nftables"
+
+	// ruleComment is attached to every rule managed by the sidecar.
+	ruleComment = "apiserver-proxy-sidecar"
+)
+
+// Executor runs external commands.
+type Executor interface {
+	// Run executes the named command with the given arguments and returns its combined output.
+	// The stdin is passed to the command if it is not nil. If the command exits with a non-zero
+	// exit code, the returned error is an *ExitError.
+	Run(stdin []byte, name string, args ...string) ([]byte, error)
+}
+
+// Manager ensures that the rules for the proxy address are installed or removed.
+type Manager interface {
+	EnsureRules() error
+	CleanupRules() error
+	// RulesPresent reports whether all rules are installed without changing anything.
+	RulesPresent() (bool, error)
+}
+
+// ExitError is returned by an Executor if the command exited with a non-zero exit code.
+type ExitError struct {
+	Code   int
+	Output string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d: %s", e.Code, e.Output)
+}
+
+// exitCode returns the exit code of the command which caused err or -1 if err is no *ExitError.
+func exitCode(err error) int {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+
+	return -1
+}
+
+// execExecutor is the default Executor running the commands with os/exec in the given network namespace.
+type execExecutor struct {
+	ns *netns.Namespace
+}
+
+func (e execExecutor) Run(stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	var (
+		out []byte
+		err error
+	)
+	if nsErr := e.ns.Do(func() error {
+		out, err = cmd.CombinedOutput()
+		return nil
+	}); nsErr != nil {
+		return nil, nsErr
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return out, &ExitError{Code: exitErr.ExitCode(), Output: string(bytes.TrimSpace(out))}
+	}
+
+	return out, err
+}
+
+// NewRulesManager returns a new instance of Manager for the given backend which manages the rules
+// for traffic to and from the given ip addresses and port in the given network namespace, the current one if nil.
+func NewRulesManager(backend string, ns *netns.Namespace, ips []netip.Addr, port uint16) (Manager, error) {
+	switch backend {
+	case BackendIPTables:
+		return newIPTablesManager(execExecutor{ns}, ips, port), nil
+	case BackendNFTables:
+		return newNFTablesManager(execExecutor{ns}, ips, port), nil
+	default:
+		return nil, xerrors.Errorf("unknown rules backend %q, must be one of %q or %q", backend, BackendIPTables, BackendNFTables)
+	}
+}
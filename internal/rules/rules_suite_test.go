@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRules(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Rules Suite")
+}
+
+var _ = Describe("Manager", func() {
+
+	var (
+		ctrl *gomock.Controller
+		me   *MockExecutor
+		ips  []netip.Addr
+	)
+
+	BeforeEach(func() {
+		ips = []netip.Addr{netip.MustParseAddr("192.168.0.3")}
+		ctrl = gomock.NewController(GinkgoT())
+		me = NewMockExecutor(ctrl)
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	Describe("NewRulesManager", func() {
+		It("should return an iptables Manager", func() {
+			m, err := NewRulesManager(BackendIPTables, nil, ips, 443)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(BeAssignableToTypeOf(&iptablesManager{}))
+		})
+
+		It("should return an nftables Manager", func() {
+			m, err := NewRulesManager(BackendNFTables, nil, ips, 443)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(BeAssignableToTypeOf(&nftablesManager{}))
+		})
+
+		It("should return error for an unknown backend", func() {
+			_, err := NewRulesManager("foo", nil, ips, 443)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("iptables", func() {
+		var manager *iptablesManager
+
+		JustBeforeEach(func() {
+			manager = newIPTablesManager(me, ips, 443)
+		})
+
+		It("should use ip6tables for IPv6 addresses", func() {
+			m := newIPTablesManager(me, append(ips, netip.MustParseAddr("fd00::3")), 443)
+			Expect(m.rules).To(HaveLen(10))
+			Expect(m.rules[0].binary).To(Equal("iptables"))
+			Expect(m.rules[5].binary).To(Equal("ip6tables"))
+		})
+
+		It("should render the rules", func() {
+			Expect(manager.rules).To(HaveLen(5))
+			Expect(manager.rules[0].command("-C")).To(Equal([]string{
+				"-w", "-t", "raw", "-C", "PREROUTING",
+				"-d", "192.168.0.3", "-p", "tcp", "--dport", "443",
+				"-m", "comment", "--comment", ruleComment, "-j", "NOTRACK",
+			}))
+			Expect(manager.rules[4].command("-I")).To(Equal([]string{
+				"-w", "-t", "filter", "-I", "OUTPUT",
+				"-s", "192.168.0.3", "-p", "tcp", "--sport", "443",
+				"-m", "comment", "--comment", ruleComment, "-j", "ACCEPT",
+			}))
+		})
+
+		Describe("EnsureRules", func() {
+			It("should not insert existing rules", func() {
+				me.EXPECT().
+					Run(nil, "iptables", gomock.Any()).
+					Return(nil, nil).
+					Times(5)
+
+				Expect(manager.EnsureRules()).To(Succeed())
+			})
+
+			It("should insert missing rules", func() {
+				for _, r := range manager.rules {
+					me.EXPECT().
+						Run(nil, "iptables", r.command("-C")).
+						Return(nil, &ExitError{Code: iptablesRuleNotExist}).
+						Times(1)
+					me.EXPECT().
+						Run(nil, "iptables", r.command("-I")).
+						Return(nil, nil).
+						Times(1)
+				}
+
+				Expect(manager.EnsureRules()).To(Succeed())
+			})
+
+			It("should return error when checking a rule fails", func() {
+				me.EXPECT().
+					Run(nil, "iptables", manager.rules[0].command("-C")).
+					Return(nil, &ExitError{Code: 2}).
+					Times(1)
+
+				Expect(manager.EnsureRules()).ToNot(Succeed())
+			})
+
+			It("should return error when inserting a rule fails", func() {
+				me.EXPECT().
+					Run(nil, "iptables", manager.rules[0].command("-C")).
+					Return(nil, &ExitError{Code: iptablesRuleNotExist}).
+					Times(1)
+				me.EXPECT().
+					Run(nil, "iptables", manager.rules[0].command("-I")).
+					Return(nil, fmt.Errorf("err")).
+					Times(1)
+
+				Expect(manager.EnsureRules()).ToNot(Succeed())
+			})
+		})
+
+		Describe("CleanupRules", func() {
+			It("should delete all occurrences of the rules", func() {
+				for _, r := range manager.rules {
+					gomock.InOrder(
+						me.EXPECT().
+							Run(nil, "iptables", r.command("-D")).
+							Return(nil, nil).
+							Times(2),
+						me.EXPECT().
+							Run(nil, "iptables", r.command("-D")).
+							Return(nil, &ExitError{Code: iptablesRuleNotExist}).
+							Times(1),
+					)
+				}
+
+				Expect(manager.CleanupRules()).To(Succeed())
+			})
+
+			It("should return error when deleting a rule fails", func() {
+				me.EXPECT().
+					Run(nil, "iptables", manager.rules[0].command("-D")).
+					Return(nil, &ExitError{Code: 2}).
+					Times(1)
+
+				Expect(manager.CleanupRules()).ToNot(Succeed())
+			})
+		})
+	})
+
+	Describe("nftables", func() {
+		var manager *nftablesManager
+
+		JustBeforeEach(func() {
+			manager = newNFTablesManager(me, ips, 443)
+		})
+
+		It("should render the ruleset", func() {
+			Expect(manager.ruleset).To(HavePrefix("table inet apiserver_proxy\ndelete table inet apiserver_proxy\n"))
+			Expect(manager.ruleset).To(ContainSubstring(`ip daddr 192.168.0.3 tcp dport 443 notrack comment "apiserver-proxy-sidecar"`))
+			Expect(manager.ruleset).To(ContainSubstring(`ip saddr 192.168.0.3 tcp sport 443 accept comment "apiserver-proxy-sidecar"`))
+		})
+
+		It("should render IPv6 matches for IPv6 addresses", func() {
+			ruleset := newNFTablesManager(me, append(ips, netip.MustParseAddr("fd00::3")), 443).ruleset
+			Expect(ruleset).To(ContainSubstring("ip daddr 192.168.0.3 tcp dport 443 notrack"))
+			Expect(ruleset).To(ContainSubstring("ip6 daddr fd00::3 tcp dport 443 notrack"))
+		})
+
+		It("should apply the ruleset", func() {
+			me.EXPECT().
+				Run([]byte(manager.ruleset), "nft", "-f", "-").
+				Return(nil, nil).
+				Times(1)
+
+			Expect(manager.EnsureRules()).To(Succeed())
+		})
+
+		It("should return error when applying the ruleset fails", func() {
+			me.EXPECT().
+				Run(gomock.Any(), "nft", "-f", "-").
+				Return(nil, &ExitError{Code: 1}).
+				Times(1)
+
+			Expect(manager.EnsureRules()).ToNot(Succeed())
+		})
+
+		It("should delete the table", func() {
+			me.EXPECT().
+				Run([]byte("table inet apiserver_proxy\ndelete table inet apiserver_proxy\n"), "nft", "-f", "-").
+				Return(nil, nil).
+				Times(1)
+
+			Expect(manager.CleanupRules()).To(Succeed())
+		})
+	})
+})
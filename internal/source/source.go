@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package source derives the ip addresses of the proxy from an object in the cluster.
+package source
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KindService derives the addresses from the ClusterIPs of a Service.
+	KindService = "service"
+	// KindConfigMap derives the addresses from a comma separated list in a key of a ConfigMap.
+	KindConfigMap = "configmap"
+	// KindNodeAnnotation derives the addresses from a comma separated list in an annotation of the Node.
+	KindNodeAnnotation = "node-annotation"
+)
+
+// Reference references the object and key the addresses are derived from.
+type Reference struct {
+	// Kind is the kind of the source.
+	Kind string
+	// Namespace is the namespace of the object, empty for Nodes.
+	Namespace string
+	// Name is the name of the object.
+	Name string
+	// Key is the key of the ConfigMap or the annotation of the Node.
+	Key string
+}
+
+// ParseReference parses a reference in one of the formats service:<namespace>/<name>,
+// configmap:<namespace>/<name>/<key> or node-annotation:<key>. The node name is only used for the latter.
+func ParseReference(ref, nodeName string) (*Reference, error) {
+	kind, value, ok := strings.Cut(ref, ":")
+	if !ok {
+		return nil, xerrors.Errorf("invalid source %q, must be of the form <kind>:<reference>", ref)
+	}
+
+	parts := strings.Split(value, "/")
+
+	switch kind {
+	case KindService:
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, xerrors.Errorf("invalid source %q, must be of the form %s:<namespace>/<name>", ref, KindService)
+		}
+
+		return &Reference{Kind: kind, Namespace: parts[0], Name: parts[1]}, nil
+	case KindConfigMap:
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, xerrors.Errorf("invalid source %q, must be of the form %s:<namespace>/<name>/<key>", ref, KindConfigMap)
+		}
+
+		return &Reference{Kind: kind, Namespace: parts[0], Name: parts[1], Key: parts[2]}, nil
+	case KindNodeAnnotation:
+		if value == "" {
+			return nil, xerrors.Errorf("invalid source %q, must be of the form %s:<key>", ref, KindNodeAnnotation)
+		}
+		if nodeName == "" {
+			return nil, xerrors.Errorf("the node name is required for source %q", ref)
+		}
+
+		// annotation keys may contain a slash, so the value is not split
+		return &Reference{Kind: kind, Name: nodeName, Key: value}, nil
+	default:
+		return nil, xerrors.Errorf("unknown kind of source %q, must be one of %s, %s or %s", kind, KindService, KindConfigMap, KindNodeAnnotation)
+	}
+}
+
+// String returns the reference in the format accepted by ParseReference.
+func (r *Reference) String() string {
+	switch r.Kind {
+	case KindService:
+		return r.Kind + ":" + r.Namespace + "/" + r.Name
+	case KindConfigMap:
+		return r.Kind + ":" + r.Namespace + "/" + r.Name + "/" + r.Key
+	default:
+		return r.Kind + ":" + r.Key
+	}
+}
+
+// Source reads the addresses from the referenced object.
+type Source struct {
+	client client.WithWatch
+	ref    Reference
+}
+
+// NewSource returns a new Source reading the referenced object with the given client.
+func NewSource(c client.WithWatch, ref Reference) *Source {
+	return &Source{client: c, ref: ref}
+}
+
+// String returns the reference of the source.
+func (s *Source) String() string {
+	return s.ref.String()
+}
+
+// Addresses returns the addresses of the referenced object.
+func (s *Source) Addresses(ctx context.Context) ([]string, error) {
+	key := types.NamespacedName{Namespace: s.ref.Namespace, Name: s.ref.Name}
+
+	switch s.ref.Kind {
+	case KindService:
+		svc := &corev1.Service{}
+		if err := s.client.Get(ctx, key, svc); err != nil {
+			return nil, xerrors.Errorf("could not get service %s: %v", key, err)
+		}
+
+		ips := svc.Spec.ClusterIPs
+		if len(ips) == 0 && svc.Spec.ClusterIP != "" {
+			ips = []string{svc.Spec.ClusterIP}
+		}
+		if len(ips) == 0 || ips[0] == corev1.ClusterIPNone {
+			return nil, xerrors.Errorf("service %s has no cluster ip", key)
+		}
+
+		return ips, nil
+	case KindConfigMap:
+		cm := &corev1.ConfigMap{}
+		if err := s.client.Get(ctx, key, cm); err != nil {
+			return nil, xerrors.Errorf("could not get configmap %s: %v", key, err)
+		}
+
+		return splitAddresses(cm.Data[s.ref.Key], "key "+s.ref.Key+" of configmap "+key.String())
+	case KindNodeAnnotation:
+		node := &corev1.Node{}
+		if err := s.client.Get(ctx, key, node); err != nil {
+			return nil, xerrors.Errorf("could not get node %s: %v", key.Name, err)
+		}
+
+		return splitAddresses(node.Annotations[s.ref.Key], "annotation "+s.ref.Key+" of node "+key.Name)
+	default:
+		return nil, xerrors.Errorf("unknown kind of source %q", s.ref.Kind)
+	}
+}
+
+// splitAddresses splits the comma separated list of addresses.
+func splitAddresses(value, desc string) ([]string, error) {
+	var addrs []string
+	for addr := range strings.SplitSeq(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, xerrors.Errorf("%s is empty", desc)
+	}
+
+	return addrs, nil
+}
+
+// Watch returns a channel receiving an event whenever the referenced object changes. Subsequent
+// events are coalesced until the previous one was received. The channel is closed when the watch
+// ends, e.g. when the apiserver closed it, or when the context is cancelled.
+func (s *Source) Watch(ctx context.Context) (<-chan struct{}, error) {
+	var list client.ObjectList
+	switch s.ref.Kind {
+	case KindService:
+		list = &corev1.ServiceList{}
+	case KindConfigMap:
+		list = &corev1.ConfigMapList{}
+	case KindNodeAnnotation:
+		list = &corev1.NodeList{}
+	default:
+		return nil, xerrors.Errorf("unknown kind of source %q", s.ref.Kind)
+	}
+
+	w, err := s.client.Watch(ctx, list,
+		client.InNamespace(s.ref.Namespace),
+		client.MatchingFieldsSelector{Selector: fields.OneTermEqualSelector("metadata.name", s.ref.Name)},
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("could not watch source %s: %v", s.ref.String(), err)
+	}
+
+	events := make(chan struct{}, 1)
+
+	go func() {
+		defer close(events)
+		defer w.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-w.ResultChan():
+				if !ok {
+					klog.V(2).Infof("Watch of source %s closed", s.ref.String())
+					return
+				}
+
+				if event.Type == watch.Error {
+					klog.Warningf("Error watching source %s: %v", s.ref.String(), event.Object)
+					continue
+				}
+
+				if obj, ok := event.Object.(client.Object); ok && obj.GetName() != s.ref.Name {
+					continue
+				}
+
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
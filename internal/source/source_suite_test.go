@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package source
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSource(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Source Suite")
+}
+
+var _ = Describe("ParseReference", func() {
+
+	DescribeTable("should parse valid references",
+		func(ref, nodeName string, expected Reference) {
+			r, err := ParseReference(ref, nodeName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*r).To(Equal(expected))
+			if expected.Kind != KindNodeAnnotation {
+				Expect(r.String()).To(Equal(ref))
+			}
+		},
+		Entry("service", "service:kube-system/apiserver-proxy", "",
+			Reference{Kind: KindService, Namespace: "kube-system", Name: "apiserver-proxy"}),
+		Entry("configmap", "configmap:kube-system/apiserver-proxy/address", "",
+			Reference{Kind: KindConfigMap, Namespace: "kube-system", Name: "apiserver-proxy", Key: "address"}),
+		Entry("node annotation with a slash", "node-annotation:example.com/address", "node-1",
+			Reference{Kind: KindNodeAnnotation, Name: "node-1", Key: "example.com/address"}),
+	)
+
+	DescribeTable("should reject invalid references",
+		func(ref, nodeName string) {
+			_, err := ParseReference(ref, nodeName)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("missing kind", "kube-system/apiserver-proxy", ""),
+		Entry("unknown kind", "secret:kube-system/apiserver-proxy", ""),
+		Entry("service without namespace", "service:apiserver-proxy", ""),
+		Entry("configmap without key", "configmap:kube-system/apiserver-proxy", ""),
+		Entry("node annotation without key", "node-annotation:", "node-1"),
+		Entry("node annotation without node name", "node-annotation:address", ""),
+	)
+})
+
+var _ = Describe("Source", func() {
+
+	var (
+		ctx context.Context
+		c   client.WithWatch
+		svc *corev1.Service
+		cm  *corev1.ConfigMap
+		nd  *corev1.Node
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "apiserver-proxy"},
+			Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.2", ClusterIPs: []string{"10.96.0.2", "fd00::2"}},
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "apiserver-proxy"},
+			Data:       map[string]string{"address": "10.96.0.3, fd00::3"},
+		}
+		nd = &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{"example.com/address": "10.96.0.4"}},
+		}
+		c = fake.NewClientBuilder().WithObjects(svc, cm, nd).Build()
+	})
+
+	addresses := func(ref Reference) ([]string, error) {
+		return NewSource(c, ref).Addresses(ctx)
+	}
+
+	It("should return the cluster ips of the service", func() {
+		Expect(addresses(Reference{Kind: KindService, Namespace: "kube-system", Name: "apiserver-proxy"})).
+			To(Equal([]string{"10.96.0.2", "fd00::2"}))
+	})
+
+	It("should fail for a headless service", func() {
+		svc.Spec.ClusterIP = corev1.ClusterIPNone
+		svc.Spec.ClusterIPs = []string{corev1.ClusterIPNone}
+		Expect(c.Update(ctx, svc)).To(Succeed())
+
+		_, err := addresses(Reference{Kind: KindService, Namespace: "kube-system", Name: "apiserver-proxy"})
+		Expect(err).To(MatchError(ContainSubstring("has no cluster ip")))
+	})
+
+	It("should return the addresses of the configmap key", func() {
+		Expect(addresses(Reference{Kind: KindConfigMap, Namespace: "kube-system", Name: "apiserver-proxy", Key: "address"})).
+			To(Equal([]string{"10.96.0.3", "fd00::3"}))
+	})
+
+	It("should fail for a missing configmap key", func() {
+		_, err := addresses(Reference{Kind: KindConfigMap, Namespace: "kube-system", Name: "apiserver-proxy", Key: "foo"})
+		Expect(err).To(MatchError(ContainSubstring("is empty")))
+	})
+
+	It("should return the addresses of the node annotation", func() {
+		Expect(addresses(Reference{Kind: KindNodeAnnotation, Name: "node-1", Key: "example.com/address"})).
+			To(Equal([]string{"10.96.0.4"}))
+	})
+
+	It("should fail for a missing object", func() {
+		_, err := addresses(Reference{Kind: KindService, Namespace: "kube-system", Name: "foo"})
+		Expect(err).To(MatchError(ContainSubstring("could not get service")))
+	})
+
+	It("should notify about changes of the referenced object only", func() {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		events, err := NewSource(c, Reference{Kind: KindConfigMap, Namespace: "kube-system", Name: "apiserver-proxy", Key: "address"}).Watch(watchCtx)
+		Expect(err).ToNot(HaveOccurred())
+
+		other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "other"}}
+		Expect(c.Create(ctx, other)).To(Succeed())
+		Consistently(events).ShouldNot(Receive())
+
+		cm.Data["address"] = "10.96.0.5"
+		Expect(c.Update(ctx, cm)).To(Succeed())
+		Eventually(events).Should(Receive())
+
+		cancel()
+		Eventually(events).Should(BeClosed())
+	})
+})
@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// DefaultPath is the default path of the state file.
+	DefaultPath = "/run/apiserver-proxy/state.json"
+	// Version is the version of the state file format written by this code base.
+	Version = 1
+)
+
+// State records the resources managed by the sidecar, so they can be removed after a restart
+// even if the configuration changed in between.
+type State struct {
+	// Version is the version of the state file format.
+	Version int `json:"version"`
+	// Interface is the name of the interface the addresses are added to.
+	Interface string `json:"interface"`
+	// Addresses are the managed ip addresses in CIDR notation.
+	Addresses []string `json:"addresses,omitempty"`
+	// CreatedLinks are the names of the interfaces created by the sidecar.
+	CreatedLinks []string `json:"createdLinks,omitempty"`
+	// Rules are the managed rules, nil if no rules are managed.
+	Rules *Rules `json:"rules,omitempty"`
+}
+
+// Rules records the configuration of the managed rules.
+type Rules struct {
+	// Backend is the backend the rules are managed with.
+	Backend string `json:"backend"`
+	// Addresses are the ip addresses the rules are managed for.
+	Addresses []string `json:"addresses"`
+	// Port is the port the rules are managed for.
+	Port uint16 `json:"port"`
+}
+
+// Store reads and writes the state file.
+type Store struct {
+	path string
+}
+
+// NewStore returns a new Store for the state file at the given path.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Load reads the state file. It returns nil if the state file does not exist.
+func (s *Store) Load() (*State, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, xerrors.Errorf("could not read state file %s: %v", s.path, err)
+	}
+
+	st := &State{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, xerrors.Errorf("could not decode state file %s: %v", s.path, err)
+	}
+
+	if st.Version != Version {
+		return nil, xerrors.Errorf("unsupported version %d of state file %s, expected %d", st.Version, s.path, Version)
+	}
+
+	return st, nil
+}
+
+// Save atomically writes the state file by writing a temporary file first and renaming it afterwards.
+func (s *Store) Save(st *State) error {
+	st.Version = Version
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("could not encode state: %v", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return xerrors.Errorf("could not create directory %s: %v", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return xerrors.Errorf("could not create temporary state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return xerrors.Errorf("could not write temporary state file %s: %v", tmp.Name(), err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return xerrors.Errorf("could not sync temporary state file %s: %v", tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return xerrors.Errorf("could not close temporary state file %s: %v", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return xerrors.Errorf("could not rename temporary state file to %s: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// Remove deletes the state file. It succeeds if the state file does not exist.
+func (s *Store) Remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("could not remove state file %s: %v", s.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestState(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Suite")
+}
+
+var _ = Describe("Store", func() {
+
+	var (
+		dir   string
+		path  string
+		store *Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "state")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "apiserver-proxy", "state.json")
+		store = NewStore(path)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should return no state if the file does not exist", func() {
+		st, err := store.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(st).To(BeNil())
+	})
+
+	It("should load the saved state", func() {
+		st := &State{
+			Interface:    "foo",
+			Addresses:    []string{"192.168.0.3/32"},
+			CreatedLinks: []string{"foo"},
+			Rules:        &Rules{Backend: "iptables", Addresses: []string{"192.168.0.3"}, Port: 443},
+		}
+		Expect(store.Save(st)).To(Succeed())
+
+		loaded, err := store.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded).To(Equal(st))
+		Expect(loaded.Version).To(Equal(Version))
+	})
+
+	It("should not leave temporary files behind", func() {
+		Expect(store.Save(&State{Interface: "foo"})).To(Succeed())
+		Expect(store.Save(&State{Interface: "bar"})).To(Succeed())
+
+		entries, err := os.ReadDir(filepath.Dir(path))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("should return error for an unsupported version", func() {
+		Expect(os.MkdirAll(filepath.Dir(path), 0o700)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(`{"version": 2}`), 0o600)).To(Succeed())
+
+		_, err := store.Load()
+		Expect(err).To(MatchError(ContainSubstring("unsupported version 2")))
+	})
+
+	It("should remove the state file", func() {
+		Expect(store.Save(&State{Interface: "foo"})).To(Succeed())
+		Expect(store.Remove()).To(Succeed())
+		Expect(store.Remove()).To(Succeed())
+
+		st, err := store.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(st).To(BeNil())
+	})
+})